@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+func (app *application) listAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	title := app.readString(qs, "title", "")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: []string{"id", "title", "year", "-id", "-title", "-year"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	albums, metadata, err := app.models.Albums.GetAll(title, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"albums": albums, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title   string  `json:"title"`
+		Artist  string  `json:"artist"`
+		Year    int32   `json:"year"`
+		SongIDs []int64 `json:"song_ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	album := &data.Album{
+		Title:   input.Title,
+		Artist:  input.Artist,
+		Year:    input.Year,
+		SongIDs: input.SongIDs,
+	}
+	v := validator.New()
+	if data.ValidateAlbum(v, album); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Albums.Insert(album)
+	if err != nil {
+		app.albumWriteErrorResponse(w, r, v, err)
+		return
+	}
+	if album.SongIDs == nil {
+		album.SongIDs = []int64{}
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/albums/%d", album.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"album": album}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	album := app.readAlbum(w, r)
+	if album == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"album": album}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateAlbumHandler() applies a partial update to an album. A song_ids list
+// replaces the whole track list.
+func (app *application) updateAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	album := app.readAlbum(w, r)
+	if album == nil {
+		return
+	}
+	var input struct {
+		Title   *string  `json:"title"`
+		Artist  *string  `json:"artist"`
+		Year    *int32   `json:"year"`
+		SongIDs *[]int64 `json:"song_ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Title != nil {
+		album.Title = *input.Title
+	}
+	if input.Artist != nil {
+		album.Artist = *input.Artist
+	}
+	if input.Year != nil {
+		album.Year = *input.Year
+	}
+	if input.SongIDs != nil {
+		album.SongIDs = *input.SongIDs
+	}
+	v := validator.New()
+	if data.ValidateAlbum(v, album); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Albums.Update(album)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.albumWriteErrorResponse(w, r, v, err)
+		}
+		return
+	}
+	if album.SongIDs == nil {
+		album.SongIDs = []int64{}
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"album": album}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAlbumHandler() deletes an album and the comments and reviews on it, but
+// not its songs.
+func (app *application) deleteAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Albums.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "album successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readAlbum() helper looks up the album named by the id URL parameter. If there
+// isn't one it sends the error response itself and returns nil.
+func (app *application) readAlbum(w http.ResponseWriter, r *http.Request) *data.Album {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	album, err := app.models.Albums.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return album
+}
+
+// The albumWriteErrorResponse() helper sends the response for an error from saving an
+// album, turning a bad track list into a validation error.
+func (app *application) albumWriteErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrInvalidAlbumSong):
+		v.AddError("song_ids", "must only contain songs which exist and are not in the trash")
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/artwork"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/storage"
+	"nurgazinovd_golang_lg/internal/validator"
+	"path"
+	"strconv"
+	"time"
+)
+
+// The artworkKeys() function returns the blob key of every thumbnail stored under an
+// artwork prefix.
+func artworkKeys(prefix string) []string {
+	keys := make([]string, len(artwork.Sizes))
+	for i, size := range artwork.Sizes {
+		keys[i] = fmt.Sprintf("%s/%d.jpg", prefix, size)
+	}
+	return keys
+}
+
+// The formatSongs() helper gets songs ready to be sent to the client, filling in their
+// artwork URLs and setting the format their durations are written in.
+func (app *application) formatSongs(r *http.Request, songs ...*data.Song) {
+	format := app.contextGetDurationFormat(r)
+	for _, song := range songs {
+		song.DurationFormat = format
+	}
+	app.setArtworkURLs(songs...)
+}
+
+// The setArtworkURLs() helper fills in the artwork URLs of each song, keyed by the
+// thumbnail size. The last element of the blob prefix changes on every upload, so it
+// is included in the URL to let clients and CDNs cache the images indefinitely.
+func (app *application) setArtworkURLs(songs ...*data.Song) {
+	for _, song := range songs {
+		if song.ArtworkKey == "" {
+			song.Artwork = nil
+			continue
+		}
+		song.Artwork = make(map[string]string, len(artwork.Sizes))
+		for _, size := range artwork.Sizes {
+			song.Artwork[strconv.Itoa(size)] = fmt.Sprintf("%s/v1/media/songs/%d/artwork/%d?v=%s",
+				app.config.media.baseURL, song.ID, size, path.Base(song.ArtworkKey))
+		}
+	}
+}
+
+// The storeArtwork() helper renders and stores a thumbnail in each of the standard
+// sizes, returning the blob prefix they were stored under. Nothing from the original
+// file is kept, which also means that any EXIF metadata is thrown away. Only
+// app.config.artwork.maxDecodes images are decoded at once.
+func (app *application) storeArtwork(ctx context.Context, songID int64, b []byte) (string, error) {
+	// Wait for a decode slot, and hold it until the thumbnails have been rendered and
+	// the decoded image can be freed.
+	select {
+	case app.artworkDecodes <- struct{}{}:
+		defer func() { <-app.artworkDecodes }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	img, err := artwork.Decode(b, app.config.artwork.maxPixels)
+	if err != nil {
+		return "", err
+	}
+	suffix, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	prefix := fmt.Sprintf("songs/%d/artwork/%s", songID, suffix)
+	for i, key := range artworkKeys(prefix) {
+		var buf bytes.Buffer
+		err = artwork.Encode(&buf, artwork.Thumbnail(img, artwork.Sizes[i]))
+		if err == nil {
+			_, err = app.storage.Put(ctx, key, &buf, artwork.ContentType)
+		}
+		if err != nil {
+			app.deleteBlobs(artworkKeys(prefix)...)
+			return "", err
+		}
+	}
+	return prefix, nil
+}
+
+// The saveArtwork() helper points the song at a new artwork prefix (or none at all),
+// removes the previous thumbnails and sends the updated song to the client. If the
+// update fails, the new thumbnails are removed instead.
+func (app *application) saveArtwork(w http.ResponseWriter, r *http.Request, song *data.Song, prefix string) {
+	previous := song.ArtworkKey
+	song.ArtworkKey = prefix
+	err := app.models.Songs.Update(song, app.contextGetUser(r).ID)
+	if err != nil {
+		if prefix != "" {
+			app.deleteBlobs(artworkKeys(prefix)...)
+		}
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if previous != "" {
+		app.deleteBlobs(artworkKeys(previous)...)
+	}
+	app.formatSongs(r, song)
+	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) uploadSongArtworkHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.artwork.maxBytes)
+	body, err := app.readUploadBody(r)
+	if err != nil {
+		app.uploadBodyErrorResponse(w, r, err)
+		return
+	}
+	// Artwork is small enough to hold in memory, and the decoders need to read the
+	// header twice anyway.
+	b, err := io.ReadAll(body)
+	if err != nil {
+		app.uploadBodyErrorResponse(w, r, err)
+		return
+	}
+	prefix, err := app.storeArtwork(r.Context(), song.ID, b)
+	if err != nil {
+		v := validator.New()
+		switch {
+		case errors.Is(err, artwork.ErrUnsupportedFormat):
+			app.unsupportedMediaTypeResponse(w, r, "the file must be a JPEG, PNG or WebP image")
+		case errors.Is(err, artwork.ErrTooLarge):
+			v.AddError("file", fmt.Sprintf("must not contain more than %d pixels", app.config.artwork.maxPixels))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, artwork.ErrTooSmall):
+			v.AddError("file", "must not be empty")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.saveArtwork(w, r, song, prefix)
+}
+
+func (app *application) deleteSongArtworkHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	if song.ArtworkKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	app.saveArtwork(w, r, song, "")
+}
+
+// The songArtworkHandler() serves a single thumbnail. Cover art isn't sensitive and is
+// typically loaded by <img> tags, so this is served from the public media router
+// without authentication.
+func (app *application) songArtworkHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	size, err := app.readInt64Param(r, "size")
+	if err != nil || song.ArtworkKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	key := fmt.Sprintf("%s/%d.jpg", song.ArtworkKey, size)
+	if !validator.In(key, artworkKeys(song.ArtworkKey)...) {
+		app.notFoundResponse(w, r)
+		return
+	}
+	rc, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", artwork.ContentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, path.Base(song.ArtworkKey), size))
+	// Only the versioned URLs handed out by the API may be cached forever.
+	if r.URL.Query().Get("v") == path.Base(song.ArtworkKey) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
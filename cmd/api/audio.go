@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/storage"
+	"nurgazinovd_golang_lg/internal/tags"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnsupportedAudio = errors.New("unsupported audio format")
+
+// How long an unfinished resumable upload is kept around for.
+const audioUploadTTL = 24 * time.Hour
+
+// The detectAudioType() function identifies an audio file from its leading bytes and
+// returns its MIME type, or the empty string if it isn't a format that we accept.
+func detectAudioType(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return "audio/mpeg"
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return "audio/mpeg"
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return "audio/ogg"
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return "audio/wav"
+	case len(header) >= 12 && string(header[0:4]) == "FORM" && string(header[8:12]) == "AIFF":
+		return "audio/aiff"
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return "audio/mp4"
+	}
+	return ""
+}
+
+// The storeAudio() helper streams an audio file into the blob store under a fresh key,
+// computing its SHA-256 checksum and sniffing its MIME type on the way through. The
+// song record itself is not updated.
+func (app *application) storeAudio(ctx context.Context, songID int64, body io.Reader) (*data.AudioFile, error) {
+	br := bufio.NewReaderSize(body, 512)
+	header, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	mimeType := detectAudioType(header)
+	if mimeType == "" {
+		return nil, errUnsupportedAudio
+	}
+	suffix, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	audio := &data.AudioFile{
+		Key:      fmt.Sprintf("songs/%d/audio/%s", songID, suffix),
+		MimeType: mimeType,
+	}
+	hash := sha256.New()
+	audio.Size, err = app.storage.Put(ctx, audio.Key, io.TeeReader(br, hash), mimeType)
+	if err != nil {
+		app.storage.Delete(context.Background(), audio.Key)
+		return nil, err
+	}
+	audio.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return audio, nil
+}
+
+// A tagWarning reports a difference between the metadata embedded in an audio file and
+// the song record, or a problem reading that metadata.
+type tagWarning struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// The readAudioTags() helper parses the metadata from an audio file in the blob store.
+// Only the parts of the file containing tags are fetched.
+func (app *application) readAudioTags(ctx context.Context, audio *data.AudioFile) (*tags.Tags, error) {
+	r := storage.NewReaderAt(ctx, app.storage, audio.Key, audio.Size)
+	defer r.Close()
+	return tags.Read(r, audio.Size)
+}
+
+// The compareTags() function lists the fields where the tags disagree with the song.
+// Tags which are missing from the file are ignored, and durations are allowed to differ
+// by a couple of seconds to account for rounding and encoder padding.
+func compareTags(song *data.Song, t *tags.Tags) []tagWarning {
+	warnings := []tagWarning{}
+	if t.Title != "" && t.Title != song.Title {
+		warnings = append(warnings, tagWarning{"title", fmt.Sprintf("the file is tagged %q", t.Title)})
+	}
+	if t.Year != 0 && t.Year != song.Year {
+		warnings = append(warnings, tagWarning{"year", fmt.Sprintf("the file is tagged %d", t.Year)})
+	}
+	if t.Duration > 0 {
+		seconds := tagDuration(t)
+		if diff := seconds - song.Duration; diff > 2 || diff < -2 {
+			warnings = append(warnings, tagWarning{"duration", fmt.Sprintf("the file is %d seconds long", seconds)})
+		}
+	}
+	if len(t.Genres) > 0 && !sameGenres(t.Genres, song.Genres) {
+		warnings = append(warnings, tagWarning{"genres", fmt.Sprintf("the file is tagged %s", strings.Join(t.Genres, ", "))})
+	}
+	return warnings
+}
+
+// The applyTags() function overwrites the song's fields with any values present in the
+// tags.
+func applyTags(song *data.Song, t *tags.Tags) {
+	if t.Title != "" {
+		song.Title = t.Title
+	}
+	if t.Year != 0 {
+		song.Year = t.Year
+	}
+	if t.Duration > 0 {
+		song.Duration = tagDuration(t)
+	}
+	if len(t.Genres) > 0 {
+		song.Genres = t.Genres
+	}
+}
+
+// tagDuration converts the playing time from the tags to whole seconds.
+func tagDuration(t *tags.Tags) data.Duration {
+	return data.Duration(t.Duration.Round(time.Second) / time.Second)
+}
+
+// sameGenres reports whether two genre lists contain the same genres, ignoring order
+// and case.
+func sameGenres(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if strings.EqualFold(x, y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// The attachAudio() helper saves a newly stored audio file against the song and sends
+// the updated song to the client, along with a list of any differences between the
+// file's tags and the record. If applyTagValues is true the tags overwrite the record
+// instead, provided that the result is still a valid song. The previous file, if any,
+// is removed in the background once the record has been updated, and a new waveform
+// is queued.
+func (app *application) attachAudio(w http.ResponseWriter, r *http.Request, song *data.Song, audio *data.AudioFile, applyTagValues bool) {
+	previous := song.Audio
+	song.Audio = audio
+	warnings := []tagWarning{}
+	t, err := app.readAudioTags(r.Context(), audio)
+	switch {
+	case err == nil && applyTagValues:
+		applyTags(song, t)
+		v := validator.New()
+		song.Genres, err = app.canonicalGenres(v, "genres", song.Genres)
+		if err != nil {
+			app.storage.Delete(context.Background(), audio.Key)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if data.ValidateSong(v, song); !v.Valid() {
+			app.storage.Delete(context.Background(), audio.Key)
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	case err == nil:
+		warnings = compareTags(song, t)
+	case errors.Is(err, tags.ErrUnsupportedFormat), errors.Is(err, tags.ErrMalformed):
+		warnings = append(warnings, tagWarning{"tags", "the file's metadata could not be read"})
+	default:
+		app.storage.Delete(context.Background(), audio.Key)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Songs.Update(song, app.contextGetUser(r).ID)
+	if err != nil {
+		app.storage.Delete(context.Background(), audio.Key)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if previous != nil {
+		app.deleteBlobs(previous.Key, waveformKey(previous.Key))
+	}
+	app.generateWaveform(song)
+	app.formatSongs(r, song)
+	err = app.writeJSON(w, http.StatusOK, envelope{"song": song, "warnings": warnings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readApplyTags() helper reads the apply_tags query string parameter, sending a
+// failed validation response and returning false in ok if it is invalid.
+func (app *application) readApplyTags(w http.ResponseWriter, r *http.Request) (applyTagValues, ok bool) {
+	v := validator.New()
+	applyTagValues = app.readBool(r.URL.Query(), "apply_tags", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false, false
+	}
+	return applyTagValues, true
+}
+
+// The deleteBlobs() helper removes blobs from the store in the background, logging
+// rather than returning any errors.
+func (app *application) deleteBlobs(keys ...string) {
+	app.background(func() {
+		for _, key := range keys {
+			err := app.storage.Delete(context.Background(), key)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"key": key})
+			}
+		}
+	})
+}
+
+// The storeAudioError() helper maps the errors returned by storeAudio() to responses.
+func (app *application) storeAudioError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUnsupportedAudio):
+		app.unsupportedMediaTypeResponse(w, r, "the file must be an MP3, FLAC, Ogg, WAV, AIFF or MP4 audio file")
+	case errors.As(err, &maxBytesError):
+		app.contentTooLargeResponse(w, r, maxBytesError.Limit)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) uploadSongAudioHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	applyTagValues, ok := app.readApplyTags(w, r)
+	if !ok {
+		return
+	}
+	// Audio files are far larger than the 1MB readJSON() limit and can take much
+	// longer than the server timeouts to arrive, so relax both for this request.
+	app.extendDeadlines(w, app.config.upload.timeout)
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.upload.maxBytes)
+	body, err := app.readUploadBody(r)
+	if err != nil {
+		app.uploadBodyErrorResponse(w, r, err)
+		return
+	}
+	audio, err := app.storeAudio(r.Context(), song.ID, body)
+	if err != nil {
+		app.storeAudioError(w, r, err)
+		return
+	}
+	app.attachAudio(w, r, song, audio, applyTagValues)
+}
+
+// The audioUploadPartKey() function returns a new blob key for one chunk of a
+// resumable upload. Each attempt at a chunk gets a key of its own, so that a request
+// which loses a race for the chunk can't overwrite or delete the winner's copy.
+func audioUploadPartKey(upload *data.AudioUpload) (string, error) {
+	suffix, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("uploads/%d/%06d-%s", upload.ID, len(upload.PartKeys), suffix), nil
+}
+
+// The purgeAudioUploads() method is run periodically to remove the uploads which were
+// abandoned before they were completed, along with the chunks they had received.
+func (app *application) purgeAudioUploads() {
+	uploads, err := app.models.AudioUploads.DeleteExpired()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	for _, upload := range uploads {
+		app.deleteBlobs(upload.PartKeys...)
+	}
+}
+
+func (app *application) createAudioUploadHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	var input struct {
+		Size int64 `json:"size"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	upload := &data.AudioUpload{
+		SongID: song.ID,
+		UserID: app.contextGetUser(r).ID,
+		Expiry: time.Now().Add(audioUploadTTL),
+		Size:   input.Size,
+	}
+	v := validator.New()
+	if data.ValidateAudioUpload(v, upload, app.config.upload.maxBytes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.AudioUploads.Insert(upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/songs/%d/audio/uploads/%d", song.ID, upload.ID))
+	headers.Set("Upload-Offset", "0")
+	err = app.writeJSON(w, http.StatusCreated, envelope{"upload": upload}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readAudioUpload() helper looks up the upload named in the URL, making sure that
+// it belongs to the current user. It sends the error response itself and returns nil
+// if the upload can't be found.
+func (app *application) readAudioUpload(w http.ResponseWriter, r *http.Request) *data.AudioUpload {
+	songID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	uploadID, err := app.readInt64Param(r, "upload_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	upload, err := app.models.AudioUploads.Get(uploadID, songID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return upload
+}
+
+func (app *application) showAudioUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload := app.readAudioUpload(w, r)
+	if upload == nil {
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+	err := app.writeJSON(w, http.StatusOK, envelope{"upload": upload}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The appendAudioUploadHandler() accepts the next chunk of a resumable upload. The
+// client must send the number of bytes already received in the Upload-Offset header,
+// which lets it resume safely after a dropped connection. Once the final chunk arrives
+// the chunks are assembled and attached to the song.
+func (app *application) appendAudioUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload := app.readAudioUpload(w, r)
+	if upload == nil {
+		return
+	}
+	applyTagValues, ok := app.readApplyTags(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("the Upload-Offset header must contain an integer value"))
+		return
+	}
+	if offset != upload.Received {
+		app.uploadOffsetConflictResponse(w, r, upload.Received)
+		return
+	}
+	app.extendDeadlines(w, app.config.upload.timeout)
+	limit := app.config.upload.chunkBytes
+	if remaining := upload.Size - upload.Received; remaining < limit {
+		limit = remaining
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	key, err := audioUploadPartKey(upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	n, err := app.storage.Put(r.Context(), key, r.Body, "application/octet-stream")
+	if err != nil {
+		app.storage.Delete(context.Background(), key)
+		app.storeAudioError(w, r, err)
+		return
+	}
+	if n == 0 {
+		app.storage.Delete(context.Background(), key)
+		app.badRequestResponse(w, r, errors.New("body must not be empty"))
+		return
+	}
+	err = app.models.AudioUploads.AddPart(upload, key, n)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			// Another request recorded this chunk first, so this copy is unused.
+			app.storage.Delete(context.Background(), key)
+			app.editConflictResponse(w, r)
+		default:
+			// The update may still have been saved, so the chunk is left in place
+			// rather than risk deleting a part which was recorded.
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !upload.Complete() {
+		headers := make(http.Header)
+		headers.Set("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+		err = app.writeJSON(w, http.StatusOK, envelope{"upload": upload}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// This was the final chunk, so assemble the parts into the song's audio file and
+	// clean up the upload whatever the outcome.
+	parts := upload.PartKeys
+	defer func() {
+		err := app.models.AudioUploads.Delete(upload.ID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		app.deleteBlobs(parts...)
+	}()
+	song, err := app.models.Songs.Get(upload.SongID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	body := storage.Concat(r.Context(), app.storage, parts...)
+	defer body.Close()
+	audio, err := app.storeAudio(r.Context(), song.ID, body)
+	if err != nil {
+		app.storeAudioError(w, r, err)
+		return
+	}
+	app.attachAudio(w, r, song, audio, applyTagValues)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// The readChartParams() helper reads the query string parameters shared by the chart
+// endpoints. Charts are always ranked by plays, so there's no sort parameter.
+func (app *application) readChartParams(r *http.Request, v *validator.Validator) (data.ChartWindow, string, data.Filters) {
+	qs := r.URL.Query()
+	period := app.readString(qs, "period", data.ChartWeek)
+	genre := app.readString(qs, "genre", "")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-plays",
+		SortSafelist: []string{"-plays"},
+	}
+	data.ValidateChartPeriod(v, period)
+	data.ValidateFilters(v, filters)
+	return data.NewChartWindow(period, time.Now()), genre, filters
+}
+
+// The showTopChartHandler() sends the most played songs over the last day, week or
+// month, optionally limited to one genre.
+func (app *application) showTopChartHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	window, genre, filters := app.readChartParams(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	entries, metadata, err := app.models.Charts.GetTop(window, genre, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	songs := make([]*data.Song, len(entries))
+	for i, entry := range entries {
+		songs[i] = entry.Song
+	}
+	app.formatSongs(r, songs...)
+	err = app.writeJSON(w, http.StatusOK, envelope{"window": window, "chart": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showTrendingChartHandler() sends the songs whose plays are growing the fastest
+// compared with the window before, which is the same length as the chart's period.
+func (app *application) showTrendingChartHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	window, genre, filters := app.readChartParams(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	entries, metadata, err := app.models.Charts.GetTrending(window, genre, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	songs := make([]*data.Song, len(entries))
+	for i, entry := range entries {
+		songs[i] = entry.Song
+	}
+	app.formatSongs(r, songs...)
+	err = app.writeJSON(w, http.StatusOK, envelope{"window": window, "chart": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The rollUpPlays() method adds newly received plays to the play count rollups. It is
+// run periodically through app.schedule().
+func (app *application) rollUpPlays() {
+	err := app.models.Charts.RollUp()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+// The readCommentFilters() helper reads the paging and sort parameters for a list of
+// comments, which are newest first by default.
+func (app *application) readCommentFilters(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-created_at"),
+		SortSafelist: []string{"created_at", "-created_at"},
+	}
+	data.ValidateFilters(v, filters)
+	return filters
+}
+
+// The readComment() helper looks up the comment named in the URL, sending the error
+// response itself and returning nil if it can't be found. Comments which haven't been
+// published can only be seen by their author.
+func (app *application) readComment(w http.ResponseWriter, r *http.Request) *data.Comment {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	comment, err := app.models.Comments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	if comment.Status != data.CommentPublished && comment.Author.ID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	return comment
+}
+
+// The checkCanPost() helper sends a response and returns false if the current user has
+// been banned from posting.
+func (app *application) checkCanPost(w http.ResponseWriter, r *http.Request) bool {
+	banned, err := app.models.Comments.IsBanned(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if banned {
+		app.bannedFromPostingResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// The readPostFilters() helper reads the query string for a list of the top-level posts
+// on a song or album. It sends the error response itself and returns false if the
+// query string is invalid.
+func (app *application) readPostFilters(w http.ResponseWriter, r *http.Request) (data.Filters, string, bool) {
+	v := validator.New()
+	filters := app.readCommentFilters(r, v)
+	kind := app.readString(r.URL.Query(), "kind", "")
+	v.Check(kind == "" || validator.In(kind, data.CommentKindComment, data.CommentKindReview), "kind", "must be either comment or review")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return filters, kind, false
+	}
+	return filters, kind, true
+}
+
+func (app *application) listSongCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	filters, kind, ok := app.readPostFilters(w, r)
+	if !ok {
+		return
+	}
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	comments, metadata, err := app.models.Comments.GetAllForSong(song.ID, kind, app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAlbumCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	filters, kind, ok := app.readPostFilters(w, r)
+	if !ok {
+		return
+	}
+	album := app.readAlbum(w, r)
+	if album == nil {
+		return
+	}
+	comments, metadata, err := app.models.Comments.GetAllForAlbum(album.ID, kind, app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createSongCommentHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	app.createComment(w, r, &data.Comment{SongID: song.ID}, "song")
+}
+
+func (app *application) createAlbumCommentHandler(w http.ResponseWriter, r *http.Request) {
+	album := app.readAlbum(w, r)
+	if album == nil {
+		return
+	}
+	app.createComment(w, r, &data.Comment{AlbumID: album.ID}, "album")
+}
+
+// The createComment() method posts a comment, reply or review on the song or album the
+// comment already names, which the subject describes in error messages. Posts which
+// trip the word and link filter are saved but held for a moderator, and the response
+// says why.
+func (app *application) createComment(w http.ResponseWriter, r *http.Request, comment *data.Comment, subject string) {
+	var input struct {
+		Kind     string `json:"kind"`
+		ParentID int64  `json:"parent_id"`
+		Title    string `json:"title"`
+		Body     string `json:"body"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	comment.ParentID = input.ParentID
+	comment.Kind = input.Kind
+	comment.Title = input.Title
+	comment.Body = input.Body
+	comment.Status = data.CommentPublished
+	comment.Author = data.PublicUser{ID: user.ID, Name: user.Name}
+	if comment.Kind == "" {
+		comment.Kind = data.CommentKindComment
+	}
+	v := validator.New()
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.checkCanPost(w, r) {
+		return
+	}
+	if comment.HeldReason = app.filter.Check(comment.Title, comment.Body); comment.HeldReason != "" {
+		comment.Status = data.CommentPending
+	}
+	err = app.models.Comments.Insert(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("parent_id", "must be a published comment on this "+subject)
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("kind", "you have already reviewed this "+subject)
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/comments/%d", comment.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"comment": comment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateCommentHandler() lets the author edit their post. The edit goes through the
+// filter again, so a published post can be held by an edit, but a post which is
+// already held or hidden stays that way until a moderator decides.
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	if comment.Author.ID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	if comment.Deleted {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Title *string `json:"title"`
+		Body  *string `json:"body"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Title != nil {
+		comment.Title = *input.Title
+	}
+	if input.Body != nil {
+		comment.Body = *input.Body
+	}
+	v := validator.New()
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.checkCanPost(w, r) {
+		return
+	}
+	if comment.Status == data.CommentPublished {
+		if comment.HeldReason = app.filter.Check(comment.Title, comment.Body); comment.HeldReason != "" {
+			comment.Status = data.CommentPending
+		}
+	}
+	err = app.models.Comments.Update(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteCommentHandler() soft deletes a post. Authors can delete their own posts,
+// and moderators can delete anyone's.
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	user := app.contextGetUser(r)
+	if comment.Author.ID != user.ID {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include("comments:moderate") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+	err := app.models.Comments.Delete(comment.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "comment successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listCommentRepliesHandler() sends a page of the direct replies to a comment.
+// Replies to those replies are fetched in the same way, one level at a time.
+func (app *application) listCommentRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readCommentFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	replies, metadata, err := app.models.Comments.GetReplies(comment.ID, app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"replies": replies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCommentRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	if comment.Deleted {
+		app.notFoundResponse(w, r)
+		return
+	}
+	revisions, err := app.models.Comments.GetRevisions(comment.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) reportCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	v := validator.New()
+	v.Check(comment.Author.ID != user.ID, "comment", "must not be your own comment")
+	if data.ValidateReportReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Comments.Report(comment.ID, user.ID, input.Reason)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "thank you, a moderator will look at this comment"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listModerationQueueHandler() sends a page of the posts held for review, oldest
+// first.
+func (app *application) listModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "created_at",
+		SortSafelist: []string{"created_at"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	comments, metadata, err := app.models.Comments.GetQueue(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The moderateCommentHandler() applies a moderator's decision to a post: approve
+// publishes it, hide hides it, and ban hides it and bans its author from posting.
+func (app *application) moderateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Action string `json:"action"`
+		Reason string `json:"reason"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(validator.In(input.Action, data.ModerationApprove, data.ModerationHide, data.ModerationBan), "action", "must be approve, hide or ban")
+	if input.Action == data.ModerationBan {
+		v.Check(input.Reason != "", "reason", "must be provided")
+	}
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	comment, err := app.models.Comments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	status, reason := data.CommentHidden, input.Reason
+	if input.Action == data.ModerationApprove {
+		status, reason = data.CommentPublished, ""
+	}
+	err = app.models.Comments.Moderate(comment.ID, status, reason)
+	if err == nil && input.Action == data.ModerationBan {
+		err = app.models.Comments.Ban(comment.Author.ID, app.contextGetUser(r).ID, input.Reason)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	comment.Status, comment.HeldReason = status, reason
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Comments.Unban(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "ban successfully lifted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -14,6 +14,9 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// durationFormatContextKey is the key for the duration format the client asked for.
+const durationFormatContextKey = contextKey("durationFormat")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -33,3 +36,17 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetDurationFormat() method returns a new copy of the request with the
+// format durations should be written in added to the context.
+func (app *application) contextSetDurationFormat(r *http.Request, format string) *http.Request {
+	ctx := context.WithValue(r.Context(), durationFormatContextKey, format)
+	return r.WithContext(ctx)
+}
+
+// The contextGetDurationFormat() retrieves the duration format from the request
+// context, returning the default format if none was set.
+func (app *application) contextGetDurationFormat(r *http.Request) string {
+	format, _ := r.Context().Value(durationFormatContextKey).(string)
+	return format
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"nurgazinovd_golang_lg/internal/data"
+)
+
+// The notify() helper queues a notification for a user in the background. Whether it
+// is actually delivered, and when, is decided by the user's preferences and the
+// dispatchNotifications() job below.
+func (app *application) notify(userID int64, kind, message string) {
+	app.background(func() {
+		err := app.models.Notifications.Insert(&data.Notification{
+			UserID:  userID,
+			Kind:    kind,
+			Message: message,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// The notifyNewRelease() helper queues a new release notification for every user who
+// follows one of the song's genres.
+func (app *application) notifyNewRelease(song *data.Song) {
+	app.background(func() {
+		message := fmt.Sprintf("New release in your followed genres: %s (%d)", song.Title, song.Year)
+		err := app.models.Notifications.InsertNewRelease(message, song.Genres)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// The dispatchNotifications() method sends any pending notifications. It is run
+// periodically through app.schedule().
+func (app *application) dispatchNotifications() {
+	app.sendImmediateNotifications()
+	app.sendDigests()
+}
+
+// Send each pending immediate notification as its own email.
+func (app *application) sendImmediateNotifications() {
+	deliveries, err := app.models.Notifications.GetPendingImmediate()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	for _, delivery := range deliveries {
+		for _, notification := range delivery.Notifications {
+			err = app.mailer.Send(delivery.Email, "notification.tmpl", map[string]interface{}{
+				"name":         delivery.Name,
+				"notification": notification,
+			})
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(delivery.UserID)})
+				break
+			}
+			single := &data.Delivery{UserID: delivery.UserID, Notifications: []*data.Notification{notification}}
+			err = app.models.Notifications.MarkSent(single, false)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				break
+			}
+		}
+	}
+}
+
+// Batch the pending notifications for each weekly-digest user into a single email.
+func (app *application) sendDigests() {
+	deliveries, err := app.models.Notifications.GetDueDigests()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	for _, delivery := range deliveries {
+		data := map[string]interface{}{
+			"name":          delivery.Name,
+			"notifications": delivery.Notifications,
+		}
+		err = app.mailer.Send(delivery.Email, "notification_digest.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(delivery.UserID)})
+			continue
+		}
+		err = app.models.Notifications.MarkSent(delivery, true)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
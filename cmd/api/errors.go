@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -79,3 +81,40 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) bannedFromPostingResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been banned from posting comments and reviews"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) contentTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	message := fmt.Sprintf("the request body must not be larger than %d bytes", limit)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+// The uploadBodyErrorResponse() method sends the response for an error returned while
+// reading an upload request body.
+func (app *application) uploadBodyErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		app.contentTooLargeResponse(w, r, maxBytesError.Limit)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) uploadOffsetConflictResponse(w http.ResponseWriter, r *http.Request, offset int64) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	message := fmt.Sprintf("the upload offset doesn't match, resume the upload from byte %d", offset)
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) invalidSignatureResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or revoked media url"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
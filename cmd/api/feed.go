@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+// The showFeedHandler() sends a page of the current user's feed: new songs from the
+// artists they follow, and songs saved or liked by the users they follow. The feed is
+// paged with the opaque cursor from the previous page's metadata, rather than with page
+// numbers, so that items added in the meantime don't shift the pages.
+func (app *application) showFeedHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	pageSize := app.readInt(qs, "page_size", 20, v)
+	v.Check(pageSize > 0, "page_size", "must be greater than zero")
+	v.Check(pageSize <= 100, "page_size", "must be a maximum of 100")
+	var cursor *data.FeedCursor
+	if s := app.readCursor(qs, v); s != "" {
+		var err error
+		cursor, err = data.ParseFeedCursor(s)
+		v.Check(err == nil, "cursor", "must be a cursor returned by a previous request")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	items, next, err := app.models.Feed.Get(app.contextGetUser(r).ID, cursor, pageSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	songs := make([]*data.Song, len(items))
+	for i, item := range items {
+		songs[i] = item.Song
+	}
+	app.formatSongs(r, songs...)
+	metadata := data.Metadata{PageSize: pageSize}
+	if next != nil {
+		metadata.NextCursor = next.String()
+	}
+	app.signCursors(&metadata)
+	err = app.writeJSON(w, http.StatusOK, envelope{"feed": items, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
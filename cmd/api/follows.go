@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strings"
+)
+
+// The readFollowFilters() helper reads the paging and sort parameters shared by the
+// following and followers lists, which are most recently followed first by default.
+func (app *application) readFollowFilters(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-followed_at"),
+		SortSafelist: []string{"followed_at", "name", "-followed_at", "-name"},
+	}
+	data.ValidateFilters(v, filters)
+	return filters
+}
+
+// The readArtistParam() helper returns the artist name from the URL. It comes from a
+// catch-all parameter, so that names containing a slash (such as "AC/DC") can be sent
+// with the slash percent-encoded.
+func (app *application) readArtistParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return strings.TrimPrefix(params.ByName("name"), "/")
+}
+
+func (app *application) listFollowedArtistsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readFollowFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	artists, metadata, err := app.models.Follows.GetArtists(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"artists": artists, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The followArtistHandler() makes the current user follow an artist. Artists are the
+// people credited with the artist role on songs, so a name which isn't credited as an
+// artist anywhere is not found.
+func (app *application) followArtistHandler(w http.ResponseWriter, r *http.Request) {
+	name := app.readArtistParam(r)
+	if name == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	artist, err := app.models.Follows.FollowArtist(app.contextGetUser(r).ID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"artist": artist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unfollowArtistHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Follows.UnfollowArtist(app.contextGetUser(r).ID, app.readArtistParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "artist successfully unfollowed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listUserFollowsHandler() method returns a handler which sends a page of the users
+// the current user follows, or of their followers.
+func (app *application) listUserFollowsHandler(followers bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		filters := app.readFollowFilters(r, v)
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		get, key := app.models.Follows.GetFollowing, "following"
+		if followers {
+			get, key = app.models.Follows.GetFollowers, "followers"
+		}
+		follows, metadata, err := get(app.contextGetUser(r).ID, filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{key: follows, "metadata": metadata}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+	v := validator.New()
+	if v.Check(id != user.ID, "id", "must not be your own user"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Follows.FollowUser(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully followed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Follows.UnfollowUser(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully unfollowed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPrivacySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.models.Follows.GetPrivacySettings(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"privacy": settings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePrivacySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.models.Follows.GetPrivacySettings(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var input struct {
+		HideActivity *bool `json:"hide_activity"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.HideActivity != nil {
+		settings.HideActivity = *input.HideActivity
+	}
+	err = app.models.Follows.UpdatePrivacySettings(settings)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"privacy": settings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strings"
+)
+
+// The canonicalGenres() helper turns the genre names a client sent into the canonical
+// names used by the catalogue. Names which aren't genres or aliases are reported as a
+// validation error against the given key.
+func (app *application) canonicalGenres(v *validator.Validator, key string, genres []string) ([]string, error) {
+	canonical, unknown, err := app.models.Genres.Canonicalize(genres)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		v.AddError(key, fmt.Sprintf("must only contain known genres (unknown: %s)", strings.Join(unknown, ", ")))
+	}
+	return canonical, nil
+}
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showGenreHandler(w http.ResponseWriter, r *http.Request) {
+	genre := app.readGenre(w, r)
+	if genre == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string   `json:"name"`
+		ParentID int64    `json:"parent_id"`
+		Aliases  []string `json:"aliases"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	genre := &data.Genre{
+		Name:     strings.TrimSpace(input.Name),
+		ParentID: input.ParentID,
+		Aliases:  input.Aliases,
+	}
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+	v := validator.New()
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Genres.Insert(genre)
+	if err != nil {
+		app.genreWriteErrorResponse(w, r, v, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateGenreHandler() renames, moves or changes the aliases of a genre. Renaming
+// keeps the old name as an alias and rewrites the songs which use it. A parent_id of 0
+// makes the genre a top-level one.
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	genre := app.readGenre(w, r)
+	if genre == nil {
+		return
+	}
+	var input struct {
+		Name     *string  `json:"name"`
+		ParentID *int64   `json:"parent_id"`
+		Aliases  []string `json:"aliases"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	oldName := genre.Name
+	if input.Name != nil {
+		genre.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.ParentID != nil {
+		genre.ParentID = *input.ParentID
+	}
+	if input.Aliases != nil {
+		genre.Aliases = input.Aliases
+	}
+	v := validator.New()
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Genres.Update(genre, oldName)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.genreWriteErrorResponse(w, r, v, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The mergeGenreHandler() folds the genre into the one given by "into", which takes
+// over its names and subgenres, and rewrites the songs filed under it.
+func (app *application) mergeGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Into int64 `json:"into"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Into > 0, "into", "must be provided")
+	v.Check(input.Into != id, "into", "must be a different genre")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	rewritten, err := app.models.Genres.Merge(id, input.Into)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrInvalidGenreParent):
+			v.AddError("into", "must not be a subgenre of the genre being merged")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	genre, err := app.models.Genres.Get(input.Into)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"genre": genre, "songs_rewritten": rewritten}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readGenre() helper looks up the genre named by the id URL parameter. If there
+// isn't one it sends the error response itself and returns nil.
+func (app *application) readGenre(w http.ResponseWriter, r *http.Request) *data.Genre {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return genre
+}
+
+// The genreWriteErrorResponse() helper sends the response for an error from saving a
+// genre, turning the ones caused by the client's input into validation errors.
+func (app *application) genreWriteErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrDuplicateGenreName):
+		v.AddError("name", "a genre with this name or alias already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrInvalidGenreParent):
+		v.AddError("parent_id", "must be an existing genre which is not a subgenre of this one")
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"nurgazinovd_golang_lg/internal/data"
 	"nurgazinovd_golang_lg/internal/validator"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Retrieve the "id" URL parameter from the current request context, then convert it to
@@ -24,6 +30,38 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// The readInt64Param() helper works like readIDParam() for a URL parameter with a
+// different name.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return id, nil
+}
+
+// The readSong() helper looks up the song named in the URL, sending the error response
+// itself and returning nil if it can't be found.
+func (app *application) readSong(w http.ResponseWriter, r *http.Request) *data.Song {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	song, err := app.models.Songs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return song
+}
+
 // Define an envelope type.
 type envelope map[string]interface{}
 
@@ -77,6 +115,116 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The randomHex() helper returns n random bytes encoded as hex, for use in blob keys.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// The readUploadBody() helper returns the file sent in an upload request, which may be
+// either the raw request body or a "file" field in a multipart form. The caller should
+// limit the size of r.Body first.
+func (app *application) readUploadBody(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("multipart body must contain a file field")
+			}
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// The readBool() helper reads a boolean value from the query string, in the same way
+// as readInt(). Any value accepted by strconv.ParseBool() is allowed.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// The readTime() helper reads a timestamp from the query string, which may be given
+// either in RFC 3339 format or as a date, meaning midnight UTC at the start of that
+// day. It returns the zero time if the key is missing.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	v.AddError(key, "must be an RFC 3339 timestamp or a date in YYYY-MM-DD format")
+	return time.Time{}
+}
+
+// The readDuration() helper reads a duration from the query string, in any of the
+// formats accepted in request bodies. It returns 0 if the key is missing.
+func (app *application) readDuration(qs url.Values, key string, v *validator.Validator) data.Duration {
+	s := qs.Get(key)
+	if s == "" {
+		return 0
+	}
+	d, err := data.ParseDuration(s)
+	if err != nil {
+		v.AddError(key, err.Error())
+		return 0
+	}
+	return d
+}
+
+// The readCursor() helper reads the signed cursor parameter from the query string and
+// returns the value it carries, or an empty string if there isn't one. A cursor which
+// has been tampered with is reported as a validation error.
+func (app *application) readCursor(qs url.Values, v *validator.Validator) string {
+	token := qs.Get("cursor")
+	if token == "" {
+		return ""
+	}
+	value, err := app.signer.VerifyToken(token)
+	if err != nil {
+		v.AddError("cursor", "must be a cursor returned by a previous request")
+		return ""
+	}
+	return value
+}
+
+// The signCursors() helper signs the cursors in a list's metadata before they are sent
+// to the client, so that readCursor() can trust them when they come back.
+func (app *application) signCursors(metadata *data.Metadata) {
+	if metadata.NextCursor != "" {
+		metadata.NextCursor = app.signer.SignToken(metadata.NextCursor)
+	}
+	if metadata.PrevCursor != "" {
+		metadata.PrevCursor = app.signer.SignToken(metadata.PrevCursor)
+	}
+}
+
 // Change the data parameter to have the type envelope instead of interface{}.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
@@ -152,6 +300,20 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// The extendDeadlines() helper pushes back the server's read and write deadlines for
+// the current request, for handlers which need longer than the server-wide timeouts.
+func (app *application) extendDeadlines(w http.ResponseWriter, d time.Duration) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(d)
+	err := rc.SetReadDeadline(deadline)
+	if err == nil {
+		err = rc.SetWriteDeadline(deadline)
+	}
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter.
 	app.wg.Add(1)
@@ -167,3 +329,70 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// The schedule() helper runs fn every interval for the lifetime of the application.
+// Each run is executed through app.background(), so that a graceful shutdown waits for
+// any job which is in progress and a panic is recovered. The next run is only started
+// once the previous one has finished, so that a slow run can't overlap the next one
+// and, for example, send the same notifications twice.
+func (app *application) schedule(interval time.Duration, fn func()) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			done := make(chan struct{})
+			app.background(func() {
+				defer close(done)
+				fn()
+			})
+			<-done
+		}
+	}()
+}
+
+// The clientIP() helper returns the address of the client which made the request. The
+// X-Forwarded-For and X-Real-Ip headers are only believed when the request came from a
+// trusted proxy, since anyone else can set them to whatever they like. The forwarded
+// addresses are read from the right, skipping over any further trusted proxies, so
+// that addresses the client prepended itself are ignored.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !app.trustedProxy(ip) {
+		return ip
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !app.trustedProxy(hop) {
+				return ip
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-Ip")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+// The trustedProxy() helper reports whether the address belongs to one of the
+// configured trusted proxies.
+func (app *application) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range app.config.proxies.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+// The listLibraryHandler() method returns a handler which sends a page of one of the
+// current user's library lists, most recently added first by default.
+func (app *application) listLibraryHandler(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var filters data.Filters
+		v := validator.New()
+		qs := r.URL.Query()
+		filters.Page = app.readInt(qs, "page", 1, v)
+		filters.PageSize = app.readInt(qs, "page_size", 20, v)
+		filters.Sort = app.readString(qs, "sort", "-added_at")
+		filters.SortSafelist = []string{"added_at", "title", "-added_at", "-title"}
+		if list == data.LibraryRatings {
+			filters.SortSafelist = append(filters.SortSafelist, "rating", "-rating")
+		}
+		if data.ValidateFilters(v, filters); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		entries, metadata, err := app.models.Library.GetAll(list, app.contextGetUser(r).ID, filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		songs := make([]*data.Song, len(entries))
+		for i, entry := range entries {
+			songs[i] = entry.Song
+		}
+		app.formatSongs(r, songs...)
+		err = app.writeJSON(w, http.StatusOK, envelope{list: entries, "metadata": metadata}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// The writeLibrarySong() helper sends a song after a change to the user's library, read
+// back from the database so that its like count and the user's rating are up to date.
+func (app *application) writeLibrarySong(w http.ResponseWriter, r *http.Request, id int64) {
+	song, err := app.models.Songs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	song.UserRating, err = app.models.Library.GetRating(app.contextGetUser(r).ID, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.formatSongs(r, song)
+	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addToLibraryHandler() method returns a handler which saves or likes a song for
+// the current user. Doing so twice is harmless.
+func (app *application) addToLibraryHandler(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		song := app.readSong(w, r)
+		if song == nil {
+			return
+		}
+		err := app.models.Library.Add(list, app.contextGetUser(r).ID, song.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.writeLibrarySong(w, r, song.ID)
+	}
+}
+
+// The removeFromLibraryHandler() method returns a handler which removes a song from one
+// of the current user's library lists.
+func (app *application) removeFromLibraryHandler(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		err = app.models.Library.Remove(list, app.contextGetUser(r).ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		app.writeLibrarySong(w, r, id)
+	}
+}
+
+func (app *application) rateSongHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	var input struct {
+		Rating int32 `json:"rating"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateRating(v, input.Rating); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Library.Rate(app.contextGetUser(r).ID, song.ID, input.Rating)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeLibrarySong(w, r, song.ID)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"mime"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/lrc"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strings"
+	"time"
+)
+
+// The largest LRC file we accept.
+const maxLRCBytes = 1 << 20
+
+// The readLanguageParam() helper reads the language tag from the URL, converting it to
+// lower case. It returns the empty string if the tag isn't valid.
+func (app *application) readLanguageParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	language := strings.ToLower(params.ByName("language"))
+	if !validator.Matches(language, data.LanguageRX) {
+		return ""
+	}
+	return language
+}
+
+// The lyricsFromLRC() function converts a parsed LRC file into lyrics.
+func lyricsFromLRC(f *lrc.File, lyrics *data.Lyrics) {
+	if !f.Synced {
+		texts := make([]string, len(f.Lines))
+		for i, line := range f.Lines {
+			texts[i] = line.Text
+		}
+		lyrics.Synced = false
+		lyrics.Lines = nil
+		lyrics.Text = strings.Join(texts, "\n")
+		return
+	}
+	lines := make([]data.LyricLine, len(f.Lines))
+	for i, line := range f.Lines {
+		lines[i] = data.LyricLine{Time: line.Time.Milliseconds(), Text: line.Text}
+	}
+	lyrics.SetLines(lines)
+}
+
+// The lyricsToLRC() function converts lyrics into an LRC file, adding tags for the song
+// title, language and length.
+func lyricsToLRC(lyrics *data.Lyrics, song *data.Song) *lrc.File {
+	f := &lrc.File{
+		Tags: map[string]string{
+			"ti":     song.Title,
+			"la":     lyrics.Language,
+			"length": fmt.Sprintf("%d:%02d", song.Duration/60, song.Duration%60),
+		},
+		Synced: lyrics.Synced,
+	}
+	if !lyrics.Synced {
+		for _, text := range strings.Split(lyrics.Text, "\n") {
+			f.Lines = append(f.Lines, lrc.Line{Text: text})
+		}
+		return f
+	}
+	for _, line := range lyrics.Lines {
+		f.Lines = append(f.Lines, lrc.Line{Time: time.Duration(line.Time) * time.Millisecond, Text: line.Text})
+	}
+	return f
+}
+
+func (app *application) listSongLyricsHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	lyrics, err := app.models.Lyrics.GetAllForSong(song.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"lyrics": lyrics}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showSongLyricsHandler() sends one language variant of a song's lyrics, either as
+// JSON (the default) or as an LRC file if the format query string parameter is "lrc".
+func (app *application) showSongLyricsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	format := app.readString(r.URL.Query(), "format", "json")
+	v.Check(validator.In(format, "json", "lrc"), "format", "must be either json or lrc")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	language := app.readLanguageParam(r)
+	if language == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	lyrics, err := app.models.Lyrics.Get(song.ID, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if format == "json" {
+		err = app.writeJSON(w, http.StatusOK, envelope{"lyrics": lyrics}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("%d.%s.lrc", song.ID, language),
+	}))
+	err = lrc.Write(w, lyricsToLRC(lyrics, song))
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The putSongLyricsHandler() creates or replaces the lyrics in one language. The body
+// may be JSON containing either plain text or an array of timed lines, or an LRC file
+// sent either as the raw body or in a multipart form.
+func (app *application) putSongLyricsHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	language := app.readLanguageParam(r)
+	if language == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	lyrics, err := app.models.Lyrics.Get(song.ID, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			lyrics = &data.Lyrics{SongID: song.ID, Language: language}
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var input struct {
+			Text  string           `json:"text"`
+			Lines []data.LyricLine `json:"lines"`
+		}
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if input.Lines != nil {
+			lyrics.SetLines(input.Lines)
+		} else {
+			lyrics.Synced = false
+			lyrics.Lines = nil
+			lyrics.Text = input.Text
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, maxLRCBytes)
+		body, err := app.readUploadBody(r)
+		if err != nil {
+			app.uploadBodyErrorResponse(w, r, err)
+			return
+		}
+		f, err := lrc.Parse(body)
+		if err != nil {
+			app.uploadBodyErrorResponse(w, r, err)
+			return
+		}
+		lyricsFromLRC(f, lyrics)
+	}
+	v := validator.New()
+	if data.ValidateLyrics(v, lyrics, song); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Lyrics.Upsert(lyrics)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"lyrics": lyrics}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSongLyricsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	language := app.readLanguageParam(r)
+	if language == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Lyrics.Delete(id, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "lyrics successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
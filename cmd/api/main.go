@@ -3,14 +3,21 @@ package main
 import (
 	"context"      // New import
 	"database/sql" // New import
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/lib/pq"
+	"net"
 	"nurgazinovd_golang_lg/internal/data"
 	"nurgazinovd_golang_lg/internal/jsonlog"
+	"nurgazinovd_golang_lg/internal/lastfm"
 	"nurgazinovd_golang_lg/internal/mailer"
+	"nurgazinovd_golang_lg/internal/moderation"
+	"nurgazinovd_golang_lg/internal/storage"
+	"nurgazinovd_golang_lg/internal/suggest"
+	"nurgazinovd_golang_lg/internal/urlsign"
 	"os"
 	"runtime"
 	"strings"
@@ -47,15 +54,83 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// proxies holds the reverse proxies whose X-Forwarded-For and X-Real-Ip headers
+	// are believed by clientIP().
+	proxies struct {
+		trusted []*net.IPNet
+	}
+	notifications struct {
+		interval time.Duration
+	}
+	storage struct {
+		backend string
+		dir     string
+		s3      storage.S3Config
+	}
+	upload struct {
+		maxBytes   int64
+		chunkBytes int64
+		timeout    time.Duration
+	}
+	artwork struct {
+		maxBytes   int64
+		maxPixels  int
+		maxDecodes int
+	}
+	stream struct {
+		idleTimeout time.Duration
+	}
+	media struct {
+		baseURL    string
+		keys       []urlsign.Key
+		defaultTTL time.Duration
+		maxTTL     time.Duration
+	}
+	lastfm struct {
+		keys map[string]string
+	}
+	rollups struct {
+		interval time.Duration
+	}
+	recommendations struct {
+		interval time.Duration
+	}
+	suggest struct {
+		interval time.Duration
+		limiter  struct {
+			rps   float64
+			burst int
+		}
+	}
+	trash struct {
+		retention time.Duration
+	}
+	comments struct {
+		words     []string
+		linkHosts []string
+		limiter   struct {
+			perMinute float64
+			burst     int
+		}
+	}
 }
 
 // Update the application struct to hold a new Mailer instance.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.BlobStore
+	signer  *urlsign.Signer
+	filter  *moderation.Filter
+	// suggestions is the autocomplete index, which is rebuilt periodically by
+	// app.buildSuggestions().
+	suggestions suggest.Index
+	// artworkDecodes holds a slot for each artwork image being decoded, since a
+	// decoded image takes up to 4 bytes per pixel.
+	artworkDecodes chan struct{}
+	wg             sync.WaitGroup
 }
 
 func main() {
@@ -80,6 +155,70 @@ func main() {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	flag.Func("trusted-proxies", "Trusted reverse proxy addresses or CIDR ranges (space separated)", func(val string) error {
+		for _, field := range strings.Fields(val) {
+			if !strings.Contains(field, "/") {
+				if strings.Contains(field, ":") {
+					field += "/128"
+				} else {
+					field += "/32"
+				}
+			}
+			_, network, err := net.ParseCIDR(field)
+			if err != nil {
+				return err
+			}
+			cfg.proxies.trusted = append(cfg.proxies.trusted, network)
+		}
+		return nil
+	})
+	flag.DurationVar(&cfg.notifications.interval, "notifications-interval", time.Minute, "How often pending notifications and digests are dispatched")
+	flag.StringVar(&cfg.storage.backend, "storage-backend", "local", "Media storage backend (local|s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./media", "Directory for the local media storage backend")
+	flag.StringVar(&cfg.storage.s3.Endpoint, "s3-endpoint", "", "S3-compatible endpoint URL")
+	flag.StringVar(&cfg.storage.s3.Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.Bucket, "s3-bucket", "", "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.AccessKey, "s3-access-key", "", "S3 access key")
+	flag.StringVar(&cfg.storage.s3.SecretKey, "s3-secret-key", "", "S3 secret key")
+
+	flag.Int64Var(&cfg.upload.maxBytes, "upload-max-bytes", 200<<20, "Maximum audio upload size in bytes")
+	flag.Int64Var(&cfg.upload.chunkBytes, "upload-chunk-bytes", 8<<20, "Maximum size of a single resumable upload chunk in bytes")
+	flag.DurationVar(&cfg.upload.timeout, "upload-timeout", 10*time.Minute, "Read and write timeout for upload requests")
+	flag.Int64Var(&cfg.artwork.maxBytes, "artwork-max-bytes", 10<<20, "Maximum artwork upload size in bytes")
+	flag.IntVar(&cfg.artwork.maxPixels, "artwork-max-pixels", 16_000_000, "Maximum number of pixels in an uploaded artwork image")
+	flag.IntVar(&cfg.artwork.maxDecodes, "artwork-max-decodes", 4, "Maximum number of artwork images decoded at once")
+	flag.DurationVar(&cfg.stream.idleTimeout, "stream-idle-timeout", 30*time.Second, "Write timeout between chunks of an audio stream")
+	flag.StringVar(&cfg.media.baseURL, "media-base-url", "", "Base URL for signed media links, such as a CDN origin (defaults to relative links)")
+	flag.Func("media-signing-keys", "Signed media URL keys as id:secret (space separated, first key signs)", func(val string) error {
+		keys, err := urlsign.ParseKeys(val)
+		cfg.media.keys = keys
+		return err
+	})
+	flag.DurationVar(&cfg.media.defaultTTL, "media-url-ttl", time.Hour, "Default lifetime of signed media URLs")
+	flag.DurationVar(&cfg.media.maxTTL, "media-url-max-ttl", 24*time.Hour, "Maximum lifetime of signed media URLs")
+	flag.Func("lastfm-api-keys", "API keys accepted by the Last.fm scrobbling API as key:secret (space separated)", func(val string) error {
+		keys, err := lastfm.ParseKeys(val)
+		cfg.lastfm.keys = keys
+		return err
+	})
+	flag.DurationVar(&cfg.rollups.interval, "rollup-interval", 5*time.Minute, "How often new plays are added to the play count rollups")
+	flag.DurationVar(&cfg.recommendations.interval, "recommendations-interval", 6*time.Hour, "How often song similarities and recommendations are rebuilt")
+	flag.DurationVar(&cfg.suggest.interval, "suggest-interval", 5*time.Minute, "How often the autocomplete index is rebuilt")
+	flag.Float64Var(&cfg.suggest.limiter.rps, "suggest-limiter-rps", 10, "Autocomplete rate limiter maximum requests per second")
+	flag.IntVar(&cfg.suggest.limiter.burst, "suggest-limiter-burst", 20, "Autocomplete rate limiter maximum burst")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted songs stay in the trash before they are purged")
+	flag.Func("comments-wordlist", "File of words and phrases which hold a comment for review, one per line", func(val string) error {
+		words, err := moderation.LoadWords(val)
+		cfg.comments.words = words
+		return err
+	})
+	flag.Func("comments-link-hosts", "Hosts which comments may link to without being held for review (space separated)", func(val string) error {
+		cfg.comments.linkHosts = strings.Fields(val)
+		return nil
+	})
+	flag.Float64Var(&cfg.comments.limiter.perMinute, "comments-limiter-per-minute", 6, "Maximum comments, reviews, edits and reports per user per minute")
+	flag.IntVar(&cfg.comments.limiter.burst, "comments-limiter-burst", 3, "Maximum burst of comments, reviews, edits and reports per user")
+	rebuildRollups := flag.Bool("rebuild-rollups", false, "Rebuild the play count rollups from the raw plays and exit")
 	// Create a new version boolean flag with the default value of false.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -100,6 +239,37 @@ func main() {
 	}
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
+	// The rollups are derived entirely from the plays table, so if they are ever lost
+	// or suspect they can be recomputed from scratch.
+	if *rebuildRollups {
+		err = data.NewModels(db).Charts.RebuildRollups()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("play count rollups rebuilt", nil)
+		return
+	}
+	store, err := openStorage(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	// Without any configured keys, fall back to a random one so that development
+	// works out of the box. Signed URLs won't survive a restart in that case.
+	if len(cfg.media.keys) == 0 {
+		key, err := urlsign.RandomKey()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.media.keys = []urlsign.Key{key}
+		logger.PrintInfo("no media signing keys configured, using a random key", nil)
+	}
+	signer, err := urlsign.New(cfg.media.keys...)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	if cfg.artwork.maxDecodes < 1 {
+		logger.PrintFatal(errors.New("artwork-max-decodes must be at least 1"), nil)
+	}
 	// Initialize a new Mailer instance using the settings from the command line
 	// flags, and add it to the application struct.
 	expvar.NewString("version").Set(version)
@@ -116,11 +286,32 @@ func main() {
 		return time.Now().Unix()
 	}))
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+		signer:  signer,
+		filter:  moderation.New(cfg.comments.words, cfg.comments.linkHosts),
+		// Limit how many artwork images are decoded at once, so that a burst of
+		// large uploads can't exhaust memory.
+		artworkDecodes: make(chan struct{}, cfg.artwork.maxDecodes),
 	}
+	// Make sure the plays table has a partition for the current month before any plays
+	// are reported, rather than letting them fall into the default partition.
+	app.createPlayPartitions()
+	// Build the autocomplete index before serving, so that suggestions work straight
+	// away.
+	app.buildSuggestions()
+	// Start the scheduled background jobs.
+	app.schedule(cfg.notifications.interval, app.dispatchNotifications)
+	app.schedule(time.Hour, app.purgeRevocations)
+	app.schedule(time.Hour, app.purgeAudioUploads)
+	app.schedule(24*time.Hour, app.createPlayPartitions)
+	app.schedule(cfg.rollups.interval, app.rollUpPlays)
+	app.schedule(cfg.recommendations.interval, app.buildRecommendations)
+	app.schedule(cfg.suggest.interval, app.buildSuggestions)
+	app.schedule(time.Hour, app.purgeTrash)
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -154,3 +345,16 @@ func openDB(cfg config) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// The openStorage() function returns the blob store selected by the storage-backend
+// flag.
+func openStorage(cfg config) (storage.BlobStore, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.NewLocalStore(cfg.storage.dir)
+	case "s3":
+		return storage.NewS3Store(cfg.storage.s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
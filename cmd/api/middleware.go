@@ -28,7 +28,10 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// The rateLimit() method returns middleware which limits how often each IP address can
+// make the requests it wraps. The requests share one limiter per IP address, however
+// many handlers are wrapped, so that they draw on the same budget.
+func (app *application) rateLimit(rps float64, burst int) func(http.Handler) http.Handler {
 	type client struct {
 		limiter  *rate.Limiter
 		lastSeen time.Time
@@ -49,26 +52,77 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			mu.Unlock()
 		}
 	}()
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			// Use the realip.FromRequest() function to get the client's real IP address.
-			ip := realip.FromRequest(r)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.config.limiter.enabled {
+				// Use the realip.FromRequest() function to get the client's real IP address.
+				ip := realip.FromRequest(r)
+				mu.Lock()
+				if _, found := clients[ip]; !found {
+					clients[ip] = &client{
+						limiter: rate.NewLimiter(rate.Limit(rps), burst),
+					}
+				}
+				clients[ip].lastSeen = time.Now()
+				if !clients[ip].limiter.Allow() {
+					mu.Unlock()
+					app.rateLimitExceededResponse(w, r)
+					return
+				}
+				mu.Unlock()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The rateLimitUser() method returns middleware which limits how often each user can
+// make the requests it wraps, on top of the per-IP limit applied by rateLimit(). The
+// requests share one limiter per user, however many handlers are wrapped. It relies on
+// the user already having been authenticated.
+func (app *application) rateLimitUser(rps float64, burst int) func(http.HandlerFunc) http.HandlerFunc {
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+	var (
+		mu      sync.Mutex
+		clients = make(map[int64]*client)
+	)
+	// Forget users once their limiter would have refilled completely.
+	idle := 3 * time.Minute
+	if refill := time.Duration(float64(burst) / rps * float64(time.Second)); rps > 0 && refill > idle {
+		idle = refill
+	}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
 			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+			for id, client := range clients {
+				if time.Since(client.lastSeen) > idle {
+					delete(clients, id)
 				}
 			}
-			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
+			mu.Unlock()
+		}
+	}()
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			id := app.contextGetUser(r).ID
+			mu.Lock()
+			if _, found := clients[id]; !found {
+				clients[id] = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+			}
+			clients[id].lastSeen = time.Now()
+			if !clients[id].limiter.Allow() {
 				mu.Unlock()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
 			mu.Unlock()
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
 }
 
 func (app *application) authenticate(next http.Handler) http.Handler {
@@ -202,7 +256,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 						// Set the necessary preflight response headers, as discussed
 						// previously.
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Range")
 						// Write the headers along with a 200 OK status and return from
 						// the middleware with no further action.
 						w.WriteHeader(http.StatusOK)
@@ -241,3 +295,22 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
 	})
 }
+
+// The durationFormat() middleware reads the duration_format query string parameter,
+// which selects how song durations are written in the response, and stores it in the
+// request context for app.formatSongs().
+func (app *application) durationFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("duration_format")
+		if format != "" {
+			v := validator.New()
+			v.Check(validator.In(format, data.DurationISO8601, data.DurationClock, data.DurationSeconds), "duration_format", "must be iso8601, clock or seconds")
+			if !v.Valid() {
+				app.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+			r = app.contextSetDurationFormat(r, format)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+func (app *application) showNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	prefs, err := app.models.Notifications.GetPreferences(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"notifications": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	prefs, err := app.models.Notifications.GetPreferences(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Use pointers so that we can tell which fields were left out of the request body,
+	// in the same way as updateSongHandler.
+	var input struct {
+		NewReleases     *bool    `json:"new_releases"`
+		PlaylistInvites *bool    `json:"playlist_invites"`
+		SecurityAlerts  *bool    `json:"security_alerts"`
+		FollowedGenres  []string `json:"followed_genres"`
+		Delivery        *string  `json:"delivery"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.NewReleases != nil {
+		prefs.NewReleases = *input.NewReleases
+	}
+	if input.PlaylistInvites != nil {
+		prefs.PlaylistInvites = *input.PlaylistInvites
+	}
+	// Security alerts can't be switched off, but we still accept the key so that the
+	// validator can give the client a clear error message.
+	if input.SecurityAlerts != nil {
+		prefs.SecurityAlerts = *input.SecurityAlerts
+	}
+	if input.Delivery != nil {
+		prefs.Delivery = *input.Delivery
+	}
+	v := validator.New()
+	if input.FollowedGenres != nil {
+		prefs.FollowedGenres, err = app.canonicalGenres(v, "followed_genres", input.FollowedGenres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if data.ValidateNotificationPreferences(v, prefs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Notifications.UpdatePreferences(prefs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"notifications": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// playInput is a single play report as sent by a client.
+type playInput struct {
+	SongID   int64           `json:"song_id"`
+	PlayedAt time.Time       `json:"played_at"`
+	MsPlayed int32           `json:"ms_played"`
+	Client   data.PlayClient `json:"client"`
+}
+
+// A rejectedPlay reports why one play in a batch wasn't accepted.
+type rejectedPlay struct {
+	Index  int               `json:"index"`
+	Errors map[string]string `json:"errors"`
+}
+
+// The createPlaysHandler() records plays for the current user. The body is either a
+// single play report, or {"plays": [...]} for a batch of reports saved up while the
+// client was offline. A single report which fails validation is rejected outright, but
+// the valid reports in a batch are recorded and the others listed in the response, so
+// that one bad event can't block a client's whole backlog. Reports which have already
+// been recorded are ignored, so batches can be retried safely.
+func (app *application) createPlaysHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		playInput
+		Plays []playInput `json:"plays"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	batch := input.Plays != nil
+	if !batch {
+		input.Plays = []playInput{input.playInput}
+	}
+	v := validator.New()
+	v.Check(len(input.Plays) >= 1, "plays", "must contain at least 1 play")
+	v.Check(len(input.Plays) <= data.MaxPlayBatch, "plays", "must not contain more than 500 plays")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	songIDs := make([]int64, len(input.Plays))
+	for i, p := range input.Plays {
+		songIDs[i] = p.SongID
+	}
+	durations, err := app.models.Songs.GetDurations(songIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	now := time.Now()
+	plays := []*data.Play{}
+	rejected := []rejectedPlay{}
+	for i, p := range input.Plays {
+		play := &data.Play{
+			UserID:   user.ID,
+			SongID:   p.SongID,
+			PlayedAt: p.PlayedAt,
+			MsPlayed: p.MsPlayed,
+			Client:   p.Client,
+		}
+		v := validator.New()
+		if data.ValidatePlay(v, play, durations[p.SongID], now); !v.Valid() {
+			if !batch {
+				app.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+			rejected = append(rejected, rejectedPlay{Index: i, Errors: v.Errors})
+			continue
+		}
+		plays = append(plays, play)
+	}
+	inserted, err := app.models.Plays.Insert(plays)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if !batch {
+		status = http.StatusCreated
+	}
+	output := envelope{
+		"accepted":   inserted,
+		"duplicates": len(plays) - inserted,
+		"rejected":   rejected,
+	}
+	err = app.writeJSON(w, status, output, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listPlaysHandler() sends a page of the current user's listening history, most
+// recent first by default.
+func (app *application) listPlaysHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-played_at")
+	filters.SortSafelist = []string{"played_at", "-played_at"}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	plays, metadata, err := app.models.Plays.GetAllForUser(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"plays": plays, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createPlayPartitions() method makes sure the plays table has partitions ready
+// for the coming month. It is run at startup and then daily through app.schedule().
+func (app *application) createPlayPartitions() {
+	err := app.models.Plays.CreatePartitions(time.Now())
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+// The readRankedFilters() helper reads the page and page_size parameters for a list
+// which is always ranked by score, so there's no sort parameter.
+func (app *application) readRankedFilters(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-score",
+		SortSafelist: []string{"-score"},
+	}
+	data.ValidateFilters(v, filters)
+	return filters
+}
+
+// The listRecommendationsHandler() sends a page of the current user's recommendations.
+// These are built periodically by app.buildRecommendations(), so new users won't have
+// any until it has run.
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readRankedFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	recommendations, metadata, err := app.models.Recommendations.GetAllForUser(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, rec := range recommendations {
+		app.formatSongs(r, rec.Song)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recommendations": recommendations, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listSimilarSongsHandler() sends the songs most similar to a song, by co-listening
+// where there's enough play history and by shared genres otherwise.
+func (app *application) listSimilarSongsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readRankedFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	similar, metadata, err := app.models.Recommendations.GetSimilar(song, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, s := range similar {
+		app.formatSongs(r, s.Song)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"similar": similar, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The buildRecommendations() method rebuilds the song similarities and every user's
+// recommendations. It is run periodically through app.schedule().
+func (app *application) buildRecommendations() {
+	err := app.models.Recommendations.Build()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+// The readSongRevision() helper fetches the version of a song given in the URL,
+// sending a 404 Not Found response if there is no such version. Like readSong(), it
+// sends any error response itself and returns nil.
+func (app *application) readSongRevision(w http.ResponseWriter, r *http.Request, song *data.Song) *data.SongRevision {
+	version, err := app.readInt64Param(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	revision, err := app.models.Songs.GetRevision(song.ID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return revision
+}
+
+// The listSongRevisionsHandler() sends a page of the versions of a song, newest first,
+// each with the song's details as of that version and the user who made it.
+func (app *application) listSongRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-version",
+		SortSafelist: []string{"-version"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	revisions, metadata, err := app.models.Songs.GetRevisions(song.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	format := app.contextGetDurationFormat(r)
+	for _, revision := range revisions {
+		revision.Song.DurationFormat = format
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The diffSongRevisionsHandler() sends the fields which changed between two versions
+// of a song. The versions are given by the from and to parameters, which default to
+// the current version and the one before it.
+func (app *application) diffSongRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	to := app.readInt(qs, "to", int(song.Version), v)
+	from := app.readInt(qs, "from", to-1, v)
+	v.Check(from >= 1, "from", "must be greater than zero")
+	v.Check(to <= int(song.Version), "to", "must not be later than the current version")
+	v.Check(from < to, "from", "must be earlier than to")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var revisions [2]*data.SongRevision
+	for i, version := range []int{from, to} {
+		revision, err := app.models.Songs.GetRevision(song.ID, int32(version))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				// Songs which existed before revisions were kept have no history
+				// before their version at the time.
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		revision.Song.DurationFormat = app.contextGetDurationFormat(r)
+		revisions[i] = revision
+	}
+	changes, err := data.DiffSnapshots(revisions[0].Song, revisions[1].Song)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	diff := envelope{"from": from, "to": to, "changes": changes}
+	err = app.writeJSON(w, http.StatusOK, envelope{"diff": diff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The revertSongHandler() puts a song's details back to how they were as of an earlier
+// version. This saves them as a new version, so the history leading up to the revert
+// is kept. The audio file and artwork stay as they are.
+func (app *application) revertSongHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	revision := app.readSongRevision(w, r, song)
+	if revision == nil {
+		return
+	}
+	v := validator.New()
+	if v.Check(revision.Version < song.Version, "version", "must be earlier than the current version"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	revision.Song.Apply(song)
+	// The genres may have been renamed or merged since, so they are brought up to date
+	// in the same way as for any other edit.
+	var err error
+	song.Genres, err = app.canonicalGenres(v, "genres", song.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateSong(v, song); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Songs.Update(song, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateISRC):
+			v.AddError("isrc", "a song with this ISRC already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.formatSongs(r, song)
+	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"expvar"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
 )
 
 func (app *application) routes() http.Handler {
+	// Posting comments, reviews and reports has its own limit for each user, shared by
+	// all of the endpoints which do so.
+	limitPosting := app.rateLimitUser(app.config.comments.limiter.perMinute/60, app.config.comments.limiter.burst)
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
@@ -18,9 +22,112 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/songs/:id", app.requirePermission("songs:read", app.showSongHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/songs/:id", app.requirePermission("songs:write", app.updateSongHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/songs/:id", app.requirePermission("songs:write", app.deleteSongHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/songs/:id/restore", app.requirePermission("songs:write", app.restoreSongHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/revisions", app.requirePermission("songs:read", app.listSongRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/revisions/diff", app.requirePermission("songs:read", app.diffSongRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/songs/:id/revisions/:version/revert", app.requirePermission("songs:write", app.revertSongHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/stream", app.requirePermission("songs:read", app.streamSongHandler))
+	router.HandlerFunc(http.MethodHead, "/v1/songs/:id/stream", app.requirePermission("songs:read", app.streamSongHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/similar", app.requirePermission("songs:read", app.listSimilarSongsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/waveform", app.requirePermission("songs:read", app.showSongWaveformHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/songs/:id/stream-url", app.requirePermission("songs:read", app.createStreamURLHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/songs/:id/audio", app.requirePermission("songs:write", app.uploadSongAudioHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/songs/:id/audio/uploads", app.requirePermission("songs:write", app.createAudioUploadHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/audio/uploads/:upload_id", app.requirePermission("songs:write", app.showAudioUploadHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/songs/:id/audio/uploads/:upload_id", app.requirePermission("songs:write", app.appendAudioUploadHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/songs/:id/artwork", app.requirePermission("songs:write", app.uploadSongArtworkHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/songs/:id/artwork", app.requirePermission("songs:write", app.deleteSongArtworkHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/lyrics", app.requirePermission("songs:read", app.listSongLyricsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/lyrics/:language", app.requirePermission("songs:read", app.showSongLyricsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/songs/:id/lyrics/:language", app.requirePermission("songs:write", app.putSongLyricsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/songs/:id/lyrics/:language", app.requirePermission("songs:write", app.deleteSongLyricsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums", app.requirePermission("songs:read", app.listAlbumsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums", app.requirePermission("songs:write", app.createAlbumHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id", app.requirePermission("songs:read", app.showAlbumHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/albums/:id", app.requirePermission("songs:write", app.updateAlbumHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/albums/:id", app.requirePermission("songs:write", app.deleteAlbumHandler))
+	// Comments and reviews can be posted on songs and albums. Once posted, they are
+	// read, edited and moderated through /v1/comments whichever they are on.
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/comments", app.requirePermission("songs:read", app.listSongCommentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/songs/:id/comments", app.requireActivatedUser(limitPosting(app.createSongCommentHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id/comments", app.requirePermission("songs:read", app.listAlbumCommentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/albums/:id/comments", app.requireActivatedUser(limitPosting(app.createAlbumCommentHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/comments/:id", app.requirePermission("songs:read", app.showCommentHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/comments/:id", app.requireActivatedUser(limitPosting(app.updateCommentHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/comments/:id", app.requireActivatedUser(app.deleteCommentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/comments/:id/replies", app.requirePermission("songs:read", app.listCommentRepliesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/comments/:id/revisions", app.requirePermission("songs:read", app.listCommentRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/comments/:id/reports", app.requireActivatedUser(limitPosting(app.reportCommentHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/comments", app.requirePermission("comments:moderate", app.listModerationQueueHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/comments/:id", app.requirePermission("comments:moderate", app.moderateCommentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/moderation/bans/:id", app.requirePermission("comments:moderate", app.unbanUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("songs:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("genres:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.requirePermission("songs:read", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("genres:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres/:id/merge", app.requirePermission("genres:write", app.mergeGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/search", app.requirePermission("songs:read", app.searchHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/suggest", app.requirePermission("songs:read", app.suggestHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/charts/top", app.requirePermission("songs:read", app.showTopChartHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/charts/trending", app.requirePermission("songs:read", app.showTrendingChartHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/works/:iswc", app.requirePermission("songs:read", app.showWorkHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trash/songs", app.requirePermission("songs:write", app.listTrashedSongsHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/notifications", app.requireActivatedUser(app.showNotificationPreferencesHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/notifications", app.requireActivatedUser(app.updateNotificationPreferencesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/library/songs", app.requireActivatedUser(app.listLibraryHandler(data.LibrarySongs)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/library/songs/:id", app.requireActivatedUser(app.addToLibraryHandler(data.LibrarySongs)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/library/songs/:id", app.requireActivatedUser(app.removeFromLibraryHandler(data.LibrarySongs)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/library/likes", app.requireActivatedUser(app.listLibraryHandler(data.LibraryLikes)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/library/likes/:id", app.requireActivatedUser(app.addToLibraryHandler(data.LibraryLikes)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/library/likes/:id", app.requireActivatedUser(app.removeFromLibraryHandler(data.LibraryLikes)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/library/ratings", app.requireActivatedUser(app.listLibraryHandler(data.LibraryRatings)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/library/ratings/:id", app.requireActivatedUser(app.rateSongHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/library/ratings/:id", app.requireActivatedUser(app.removeFromLibraryHandler(data.LibraryRatings)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/following/artists", app.requireActivatedUser(app.listFollowedArtistsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/following/artists/*name", app.requireActivatedUser(app.followArtistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/following/artists/*name", app.requireActivatedUser(app.unfollowArtistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/following/users", app.requireActivatedUser(app.listUserFollowsHandler(false)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/following/users/:id", app.requireActivatedUser(app.followUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/following/users/:id", app.requireActivatedUser(app.unfollowUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/followers", app.requireActivatedUser(app.listUserFollowsHandler(true)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/privacy", app.requireActivatedUser(app.showPrivacySettingsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/privacy", app.requireActivatedUser(app.updatePrivacySettingsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/recommendations", app.requireActivatedUser(app.listRecommendationsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/plays", app.requireActivatedUser(app.listPlaysHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/now-playing", app.requireActivatedUser(app.showNowPlayingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/feed", app.requireActivatedUser(app.showFeedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/plays", app.requireActivatedUser(app.createPlaysHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/scrobbles/unmatched", app.requirePermission("songs:write", app.listUnmatchedScrobblesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/scrobbles/unmatched/:id/resolve", app.requirePermission("songs:write", app.resolveUnmatchedScrobbleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/scrobbles/unmatched/:id", app.requirePermission("songs:write", app.deleteUnmatchedScrobbleHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/stream-urls/revocations", app.requireActivatedUser(app.revokeStreamURLHandler))
+	// Scrobbling clients speak the Last.fm protocol, which authenticates with signed
+	// parameters and a session key rather than an Authorization header.
+	router.HandlerFunc(http.MethodGet, "/2.0/", app.lastfmHandler)
+	router.HandlerFunc(http.MethodPost, "/2.0/", app.lastfmHandler)
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// Signed media URLs are used by clients which can't send an Authorization header,
+	// such as <audio> tags and CDNs, so they get their own router which bypasses the
+	// authenticate() middleware. The signature is checked by the handler instead.
+	// Artwork thumbnails are public and are served from here too.
+	media := httprouter.New()
+	media.NotFound = http.HandlerFunc(app.notFoundResponse)
+	media.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	media.HandlerFunc(http.MethodGet, "/v1/media/songs/:id", app.signedStreamHandler)
+	media.HandlerFunc(http.MethodHead, "/v1/media/songs/:id", app.signedStreamHandler)
+	media.HandlerFunc(http.MethodGet, "/v1/media/songs/:id/artwork/:size", app.songArtworkHandler)
+	media.HandlerFunc(http.MethodHead, "/v1/media/songs/:id/artwork/:size", app.songArtworkHandler)
+	// Autocomplete requests come on every keystroke, so they have their own per-IP
+	// limit rather than using up the budget shared by everything else.
+	limit := app.rateLimit(app.config.limiter.rps, app.config.limiter.burst)
+	limitSuggest := app.rateLimit(app.config.suggest.limiter.rps, app.config.suggest.limiter.burst)
+	api := app.authenticate(app.durationFormat(router))
+	mux := http.NewServeMux()
+	mux.Handle("/v1/media/", limit(media))
+	mux.Handle("/v1/suggest", limitSuggest(api))
+	mux.Handle("/", limit(api))
+	return app.metrics(app.recoverPanic(app.enableCORS(mux)))
 }
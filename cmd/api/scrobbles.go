@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/lastfm"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The largest request body accepted by the scrobbling API.
+const maxScrobbleBytes = 1 << 20
+
+// scrobbleClient is recorded as the client of plays which arrive as scrobbles.
+var scrobbleClient = data.PlayClient{Name: "Last.fm API", Version: "2.0"}
+
+// The lastfmErrorResponse() helper sends an error in the Last.fm format.
+func (app *application) lastfmErrorResponse(w http.ResponseWriter, r *http.Request, code int, message string) {
+	err := lastfm.WriteError(w, r.Form.Get("format"), &lastfm.Error{Code: code, Message: message})
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The lastfmServerErrorResponse() helper logs an unexpected error and tells the client
+// to try again later.
+func (app *application) lastfmServerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.lastfmErrorResponse(w, r, lastfm.CodeTemporaryError, "There was a temporary error processing your request. Please try again")
+}
+
+// The lastfmResponse() helper sends a successful response in the Last.fm format.
+func (app *application) lastfmResponse(w http.ResponseWriter, r *http.Request, name string, v interface{}) {
+	err := lastfm.WriteResponse(w, r.Form.Get("format"), name, v)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The lastfmHandler() implements the Last.fm 2.0 web service endpoint for scrobbling
+// clients. Every request must be signed with the secret of one of the configured API
+// keys, and everything but auth.getMobileSession needs a session key as well.
+func (app *application) lastfmHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxScrobbleBytes)
+	err := r.ParseForm()
+	if err != nil {
+		app.lastfmErrorResponse(w, r, lastfm.CodeInvalidParameters, "Invalid parameters - the request could not be parsed")
+		return
+	}
+	secret, ok := app.config.lastfm.keys[r.Form.Get("api_key")]
+	if !ok {
+		app.lastfmErrorResponse(w, r, lastfm.CodeInvalidAPIKey, "Invalid API key - You must be granted a valid key by last.fm")
+		return
+	}
+	if !lastfm.Verify(r.Form, secret) {
+		app.lastfmErrorResponse(w, r, lastfm.CodeInvalidSignature, "Invalid method signature supplied")
+		return
+	}
+	method := strings.ToLower(r.Form.Get("method"))
+	if method == "auth.getmobilesession" {
+		app.lastfmMobileSession(w, r)
+		return
+	}
+	user := app.lastfmSessionUser(w, r)
+	if user == nil {
+		return
+	}
+	switch method {
+	case "track.updatenowplaying":
+		app.lastfmUpdateNowPlaying(w, r, user)
+	case "track.scrobble":
+		app.lastfmScrobble(w, r, user)
+	default:
+		app.lastfmErrorResponse(w, r, lastfm.CodeInvalidMethod, "Invalid Method - No method with that name in this package")
+	}
+}
+
+// The lastfmMobileSession() method exchanges a user's credentials for a session key.
+// Clients send the username parameter, which holds the user's email address here.
+func (app *application) lastfmMobileSession(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.GetByEmail(r.Form.Get("username"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.lastfmErrorResponse(w, r, lastfm.CodeAuthFailed, "Authentication Failed - You do not have permissions to access the service")
+		default:
+			app.lastfmServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	match, err := user.Password.Matches(r.Form.Get("password"))
+	if err != nil {
+		app.lastfmServerErrorResponse(w, r, err)
+		return
+	}
+	if !match || !user.Activated {
+		app.lastfmErrorResponse(w, r, lastfm.CodeAuthFailed, "Authentication Failed - You do not have permissions to access the service")
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, data.ScrobbleSessionTTL, data.ScopeScrobble)
+	if err != nil {
+		app.lastfmServerErrorResponse(w, r, err)
+		return
+	}
+	app.notify(user.ID, data.NotificationSecurityAlert, fmt.Sprintf("New scrobbling session for your account from %s", app.clientIP(r)))
+	app.lastfmResponse(w, r, "session", lastfm.Session{Name: user.Name, Key: token.Plaintext})
+}
+
+// The lastfmSessionUser() helper returns the user for the session key of the request.
+// If there isn't one, it sends an error and returns nil.
+func (app *application) lastfmSessionUser(w http.ResponseWriter, r *http.Request) *data.User {
+	sk := r.Form.Get("sk")
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, sk); !v.Valid() {
+		app.lastfmErrorResponse(w, r, lastfm.CodeInvalidSession, "Invalid session key - Please re-authenticate")
+		return nil
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeScrobble, sk)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.lastfmErrorResponse(w, r, lastfm.CodeInvalidSession, "Invalid session key - Please re-authenticate")
+		default:
+			app.lastfmServerErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return user
+}
+
+// The matchScrobble() helper finds the song for a scrobbled track, returning nil if
+// there isn't a confident match.
+func (app *application) matchScrobble(s lastfm.Scrobble) (*data.Song, error) {
+	song, err := app.models.Songs.MatchScrobble(s.Artist, s.Track, data.Duration(s.Duration/time.Second))
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return song, err
+}
+
+// scrobbleTrack builds the response for a scrobbled track, reporting our title for the
+// song as a correction if it differs from what the client sent.
+func scrobbleTrack(s lastfm.Scrobble, song *data.Song) lastfm.Track {
+	title := ""
+	if song != nil {
+		title = song.Title
+	}
+	return lastfm.Track{
+		Track:          lastfm.NewText(s.Track, title),
+		Artist:         lastfm.NewText(s.Artist, ""),
+		Album:          lastfm.NewText(s.Album, ""),
+		AlbumArtist:    lastfm.NewText(s.AlbumArtist, ""),
+		IgnoredMessage: lastfm.NewIgnoredMessage(lastfm.IgnoredNone, ""),
+	}
+}
+
+func (app *application) lastfmUpdateNowPlaying(w http.ResponseWriter, r *http.Request, user *data.User) {
+	s, err := lastfm.ParseNowPlaying(r.Form)
+	if err != nil {
+		var lfmErr *lastfm.Error
+		if errors.As(err, &lfmErr) {
+			app.lastfmErrorResponse(w, r, lfmErr.Code, lfmErr.Message)
+			return
+		}
+		app.lastfmServerErrorResponse(w, r, err)
+		return
+	}
+	song, err := app.matchScrobble(s)
+	if err != nil {
+		app.lastfmServerErrorResponse(w, r, err)
+		return
+	}
+	now := time.Now()
+	np := &data.NowPlaying{
+		Artist:    s.Artist,
+		Track:     s.Track,
+		Album:     s.Album,
+		StartedAt: now,
+		ExpiresAt: now.Add(data.NowPlayingTTL),
+	}
+	if s.Duration > 0 {
+		np.ExpiresAt = now.Add(s.Duration)
+	}
+	if song != nil {
+		np.SongID = song.ID
+	}
+	err = app.models.Scrobbles.SetNowPlaying(user.ID, np)
+	if err != nil {
+		app.lastfmServerErrorResponse(w, r, err)
+		return
+	}
+	app.lastfmResponse(w, r, "nowplaying", scrobbleTrack(s, song))
+}
+
+// The lastfmScrobble() method records a batch of scrobbles in the user's listening
+// history. Scrobbles which can't be matched to a song are still accepted, but are
+// queued for review instead. Scrobbles outside the window which plays may be reported
+// for are ignored, as Last.fm does.
+func (app *application) lastfmScrobble(w http.ResponseWriter, r *http.Request, user *data.User) {
+	scrobbles, err := lastfm.ParseScrobbles(r.Form)
+	if err != nil {
+		var lfmErr *lastfm.Error
+		if errors.As(err, &lfmErr) {
+			app.lastfmErrorResponse(w, r, lfmErr.Code, lfmErr.Message)
+			return
+		}
+		app.lastfmServerErrorResponse(w, r, err)
+		return
+	}
+	now := time.Now()
+	result := lastfm.Scrobbles{}
+	plays := []*data.Play{}
+	for _, s := range scrobbles {
+		v := validator.New()
+		if data.ValidatePlayedAt(v, s.Timestamp, now); !v.Valid() {
+			track := scrobbleTrack(s, nil)
+			track.Timestamp = strconv.FormatInt(s.Timestamp.Unix(), 10)
+			if s.Timestamp.After(now) {
+				track.IgnoredMessage = lastfm.NewIgnoredMessage(lastfm.IgnoredTimestampNew, "Timestamp was too far in the future")
+			} else {
+				track.IgnoredMessage = lastfm.NewIgnoredMessage(lastfm.IgnoredTimestampOld, "Timestamp was too old")
+			}
+			result.Ignored++
+			result.Scrobbles = append(result.Scrobbles, track)
+			continue
+		}
+		duration := data.Duration(s.Duration / time.Second)
+		song, err := app.matchScrobble(s)
+		if err != nil {
+			app.lastfmServerErrorResponse(w, r, err)
+			return
+		}
+		if song != nil {
+			plays = append(plays, &data.Play{
+				UserID:   user.ID,
+				SongID:   song.ID,
+				PlayedAt: s.Timestamp,
+				MsPlayed: data.ScrobbleMsPlayed(duration, song.Duration),
+				Client:   scrobbleClient,
+			})
+		} else {
+			err = app.models.Scrobbles.QueueUnmatched(&data.UnmatchedScrobble{
+				UserID:   user.ID,
+				Artist:   s.Artist,
+				Track:    s.Track,
+				Album:    s.Album,
+				PlayedAt: s.Timestamp,
+				Duration: duration,
+			})
+			if err != nil {
+				app.lastfmServerErrorResponse(w, r, err)
+				return
+			}
+		}
+		track := scrobbleTrack(s, song)
+		track.Timestamp = strconv.FormatInt(s.Timestamp.Unix(), 10)
+		result.Accepted++
+		result.Scrobbles = append(result.Scrobbles, track)
+	}
+	_, err = app.models.Plays.Insert(plays)
+	if err != nil {
+		app.lastfmServerErrorResponse(w, r, err)
+		return
+	}
+	app.lastfmResponse(w, r, "scrobbles", result)
+}
+
+// The showNowPlayingHandler() sends the track the current user's player last reported
+// as playing, or null if nothing is playing.
+func (app *application) showNowPlayingHandler(w http.ResponseWriter, r *http.Request) {
+	np, err := app.models.Scrobbles.GetNowPlaying(app.contextGetUser(r).ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"now_playing": np}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUnmatchedScrobblesHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "received_at")
+	filters.SortSafelist = []string{"received_at", "played_at", "artist", "track", "-received_at", "-played_at", "-artist", "-track"}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	scrobbles, metadata, err := app.models.Scrobbles.GetAllUnmatched(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"scrobbles": scrobbles, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The resolveUnmatchedScrobbleHandler() matches a queued scrobble to a song by hand,
+// moving it into the listening history of the user who scrobbled it.
+func (app *application) resolveUnmatchedScrobbleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		SongID int64 `json:"song_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	song, err := app.models.Songs.Get(input.SongID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v := validator.New()
+			v.AddError("song_id", "must refer to an existing song")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	play, err := app.models.Scrobbles.Resolve(id, song, scrobbleClient)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"play": play}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUnmatchedScrobbleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Scrobbles.DeleteUnmatched(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "scrobble successfully discarded"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
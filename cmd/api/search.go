@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+// The searchHandler() looks for the query across the catalogue and sends the best
+// matches of each kind, for a search box which shows them side by side. Only songs and
+// artists are searched here, and albums are searched by title through /v1/albums.
+// Each match comes with a highlight marking the words which matched.
+func (app *application) searchHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	query := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 5, v)
+	v.Check(query != "", "q", "must be provided")
+	v.Check(len(query) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	songs, _, _, err := app.models.Songs.GetAll(data.SongFilters{Title: query, GenresMode: data.GenresAll}, nil, app.contextGetUser(r).ID, data.Filters{
+		Page:         1,
+		PageSize:     limit,
+		Sort:         "relevance",
+		SortSafelist: []string{"relevance"},
+		SkipTotal:    true,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	artists, err := app.models.Search.Artists(query, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.formatSongs(r, songs...)
+	err = app.writeJSON(w, http.StatusOK, envelope{"songs": songs, "artists": artists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
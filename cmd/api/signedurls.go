@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/url"
+	"nurgazinovd_golang_lg/internal/urlsign"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The createStreamURLHandler() mints a signed, expiring URL for the song's audio which
+// can be used without an Authorization header, for example by an <audio> tag.
+func (app *application) createStreamURLHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	if song.Audio == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Both fields are optional, so an empty request body is allowed here.
+	var input struct {
+		TTL    *int `json:"ttl"`
+		BindIP bool `json:"bind_ip"`
+	}
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	ttl := app.config.media.defaultTTL
+	if input.TTL != nil {
+		ttl = time.Duration(*input.TTL) * time.Second
+	}
+	v := validator.New()
+	v.Check(ttl > 0, "ttl", "must be greater than zero")
+	v.Check(ttl <= app.config.media.maxTTL, "ttl", fmt.Sprintf("must not be more than %d seconds", int(app.config.media.maxTTL.Seconds())))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	claims := urlsign.Claims{
+		SongID: song.ID,
+		UserID: app.contextGetUser(r).ID,
+		Expiry: time.Now().Add(ttl).Truncate(time.Second),
+	}
+	if input.BindIP {
+		claims.IP = app.clientIP(r)
+	}
+	link := fmt.Sprintf("%s/v1/media/songs/%d?%s", app.config.media.baseURL, song.ID, app.signer.Sign(claims).Encode())
+	err := app.writeJSON(w, http.StatusCreated, envelope{"url": link, "expiry": claims.Expiry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The signedStreamHandler() serves a song's audio to anyone holding a valid signed URL.
+// It is routed around the authenticate() middleware, so the signature, its revocation
+// status and the signing user's permissions are all checked here instead.
+func (app *application) signedStreamHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	qs := r.URL.Query()
+	claims, err := app.signer.Verify(id, qs, app.clientIP(r), time.Now())
+	if err != nil {
+		app.invalidSignatureResponse(w, r)
+		return
+	}
+	revoked, err := app.models.Revocations.IsRevoked(claims.Signature)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if revoked {
+		app.invalidSignatureResponse(w, r)
+		return
+	}
+	// The URL carries the permissions of the user who minted it, so stop honouring it
+	// as soon as they lose access.
+	permissions, err := app.models.Permissions.GetAllForUser(claims.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permissions.Include("songs:read") {
+		app.invalidSignatureResponse(w, r)
+		return
+	}
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	app.serveAudio(w, r, song)
+}
+
+// The revokeStreamURLHandler() kills a signed URL before it expires. Users can revoke
+// URLs that they minted themselves, and users with the songs:write permission can
+// revoke anybody's.
+func (app *application) revokeStreamURLHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL string `json:"url"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.URL != "", "url", "must be provided")
+	link, err := url.Parse(input.URL)
+	v.Check(err == nil && strings.HasPrefix(link.Path, "/v1/media/songs/"), "url", "must be a signed media URL")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	songID, err := strconv.ParseInt(strings.TrimPrefix(link.Path, "/v1/media/songs/"), 10, 64)
+	if err != nil {
+		v.AddError("url", "must be a signed media URL")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Verify the URL as if it were being requested from the IP address it is bound
+	// to (if any), so that we can trust the user ID and expiry it carries.
+	qs := link.Query()
+	claims, err := app.signer.Verify(songID, qs, qs.Get("ip"), time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, urlsign.ErrExpired):
+			v.AddError("url", "has already expired")
+		default:
+			v.AddError("url", "must be a signed media URL")
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	if claims.UserID != user.ID {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include("songs:write") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+	err = app.models.Revocations.Insert(claims.Signature, claims.UserID, claims.Expiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "url successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The purgeRevocations() method drops revocations for URLs which have since expired.
+// It is run periodically through app.schedule().
+func (app *application) purgeRevocations() {
+	err := app.models.Revocations.DeleteExpired()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
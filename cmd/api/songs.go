@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/julienschmidt/httprouter"
 	"net/http"
 	"nurgazinovd_golang_lg/internal/data"
 	"nurgazinovd_golang_lg/internal/validator"
+	"strings"
 )
 
 func (app *application) createSongHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,6 +17,9 @@ func (app *application) createSongHandler(w http.ResponseWriter, r *http.Request
 		Year     int32         `json:"year"`
 		Duration data.Duration `json:"duration"`
 		Genres   []string      `json:"genres"`
+		ISRC     string        `json:"isrc"`
+		ISWC     string        `json:"iswc"`
+		Credits  data.Credits  `json:"credits"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -26,19 +31,36 @@ func (app *application) createSongHandler(w http.ResponseWriter, r *http.Request
 		Year:     input.Year,
 		Duration: input.Duration,
 		Genres:   input.Genres,
+		ISRC:     validator.NormalizeCode(input.ISRC),
+		ISWC:     validator.NormalizeCode(input.ISWC),
+		Credits:  input.Credits,
 	}
 	v := validator.New()
+	song.Genres, err = app.canonicalGenres(v, "genres", song.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	if data.ValidateSong(v, song); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Songs.Insert(song)
+	err = app.models.Songs.Insert(song, app.contextGetUser(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateISRC):
+			v.AddError("isrc", "a song with this ISRC already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+	// Let users who follow any of the song's genres know about the new release.
+	app.notifyNewRelease(song)
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/songs/%d", song.ID))
+	app.formatSongs(r, song)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"song": song}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -61,6 +83,7 @@ func (app *application) showSongHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	app.formatSongs(r, song)
 	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -82,7 +105,6 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 			app.serverErrorResponse(w, r, err)
 		}
 		return
-		return
 	}
 	// Use pointers for the Title, Year and Duration fields.
 	var input struct {
@@ -90,6 +112,9 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 		Year     *int32         `json:"year"`
 		Duration *data.Duration `json:"duration"`
 		Genres   []string       `json:"genres"`
+		ISRC     *string        `json:"isrc"`
+		ISWC     *string        `json:"iswc"`
+		Credits  data.Credits   `json:"credits"`
 	}
 	// Decode the JSON as normal.
 	err = app.readJSON(w, r, &input)
@@ -116,21 +141,41 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 	if input.Genres != nil {
 		song.Genres = input.Genres // Note that we don't need to dereference a slice.
 	}
+	if input.ISRC != nil {
+		song.ISRC = validator.NormalizeCode(*input.ISRC)
+	}
+	if input.ISWC != nil {
+		song.ISWC = validator.NormalizeCode(*input.ISWC)
+	}
+	if input.Credits != nil {
+		song.Credits = input.Credits
+	}
 	v := validator.New()
+	if input.Genres != nil {
+		song.Genres, err = app.canonicalGenres(v, "genres", song.Genres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	if data.ValidateSong(v, song); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Songs.Update(song)
+	err = app.models.Songs.Update(song, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateISRC):
+			v.AddError("isrc", "a song with this ISRC already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.formatSongs(r, song)
 	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -144,9 +189,9 @@ func (app *application) deleteSongHandler(w http.ResponseWriter, r *http.Request
 		app.notFoundResponse(w, r)
 		return
 	}
-	// Delete the song from the database, sending a 404 Not Found response to the
-	// client if there isn't a matching record.
-	err = app.models.Songs.Delete(id)
+	// Move the song to the trash, sending a 404 Not Found response to the client if
+	// there isn't a matching record. Its media files are kept until it is purged.
+	err = app.models.Songs.Delete(id, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -165,30 +210,109 @@ func (app *application) deleteSongHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) listSongsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.SongFilters
+		Facets []string
 		data.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 	input.Title = app.readString(qs, "title", "")
+	input.Lyrics = app.readString(qs, "lyrics", "")
+	input.ISRC = validator.NormalizeCode(app.readString(qs, "isrc", ""))
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.GenresMode = app.readString(qs, "genres_mode", data.GenresAll)
+	input.IncludeSubgenres = app.readBool(qs, "include_subgenres", false, v)
+	input.YearMin = int32(app.readInt(qs, "year_min", 0, v))
+	input.YearMax = int32(app.readInt(qs, "year_max", 0, v))
+	input.DurationMin = app.readDuration(qs, "duration_min", v)
+	input.DurationMax = app.readDuration(qs, "duration_max", v)
+	input.AddedSince = app.readTime(qs, "added_since", v)
+	// Facet counts are opt-in, since they mean reading every matching song.
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	for _, facet := range input.Facets {
+		v.Check(validator.In(facet, data.FacetGenres, data.FacetDecade), "facets", "must only contain genres and decade")
+	}
+	v.Check(validator.Unique(input.Facets), "facets", "must not contain duplicate values")
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "duration", "-id", "-title", "-year", "-duration"}
+	// The sort can list several columns, such as "-year,title", which are applied in
+	// turn to break ties. Title searches are sorted by relevance unless the client
+	// asks otherwise.
+	defaultSort := "id"
+	if input.Title != "" {
+		defaultSort = "relevance"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{"id", "title", "year", "duration", "plays", "relevance", "-id", "-title", "-year", "-duration", "-plays"}
+	input.Filters.MultiSort = true
+	v.Check(input.Title != "" || !validator.In("relevance", strings.Split(input.Filters.Sort, ",")...), "sort", "must not be relevance without a title search")
+	// A cursor from a previous page switches to keyset pagination. Counting the total
+	// is optional, and off by default for cursor pages, since it means reading every
+	// matching song.
+	if s := app.readCursor(qs, v); s != "" {
+		cursor, err := data.ParseCursor(s)
+		if err != nil {
+			v.AddError("cursor", "must be a cursor returned by a previous request")
+		}
+		input.Filters.Cursor = cursor
+	}
+	input.Filters.SkipTotal = !app.readBool(qs, "include_total", input.Filters.Cursor == nil, v)
+	data.ValidateSongFilters(v, input.SongFilters)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Filter by the canonical names of the genres. Names which aren't genres are kept
+	// as they are, and simply match nothing.
+	if len(input.Genres) > 0 {
+		canonical, unknown, err := app.models.Genres.Canonicalize(input.Genres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		input.Genres = append(canonical, unknown...)
+	}
 	// Accept the metadata struct as a return value.
-	songs, metadata, err := app.models.Songs.GetAll(input.Title, input.Genres, input.Filters)
+	songs, metadata, facets, err := app.models.Songs.GetAll(input.SongFilters, input.Facets, app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Include the metadata in the response envelope, and the facets if any were asked
+	// for.
+	app.signCursors(&metadata)
+	app.formatSongs(r, songs...)
+	env := envelope{"songs": songs, "metadata": metadata}
+	if facets != nil {
+		env["facets"] = facets
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showWorkHandler() looks up a composition by its ISWC, which may be given in
+// either its compact or printed form, and sends every recording of it.
+func (app *application) showWorkHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	iswc := validator.NormalizeCode(params.ByName("iswc"))
+	if !validator.IsISWC(iswc) {
+		app.notFoundResponse(w, r)
+		return
+	}
+	songs, err := app.models.Songs.GetAllForWork(iswc)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Include the metadata in the response envelope.
-	err = app.writeJSON(w, http.StatusOK, envelope{"songs": songs, "metadata": metadata}, nil)
+	if len(songs) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	app.formatSongs(r, songs...)
+	work := envelope{"iswc": iswc, "recordings": songs}
+	err = app.writeJSON(w, http.StatusOK, envelope{"work": work}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
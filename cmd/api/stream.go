@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/storage"
+	"time"
+)
+
+// idleTimeoutWriter wraps a http.ResponseWriter so that every successful write pushes
+// the connection's write deadline back by the given timeout. This lets long streams
+// outlive the server-wide WriteTimeout, while still dropping clients which stop
+// reading altogether.
+type idleTimeoutWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *idleTimeoutWriter) Write(p []byte) (int, error) {
+	err := w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *idleTimeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// The serveAudio() helper streams the song's audio file to the client. The Range,
+// If-Range and conditional request headers are all handled by http.ServeContent(),
+// reading from the blob store lazily so that only the requested bytes are fetched.
+func (app *application) serveAudio(w http.ResponseWriter, r *http.Request, song *data.Song) {
+	if song.Audio == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", song.Audio.MimeType)
+	w.Header().Set("ETag", `"`+song.Audio.Checksum+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	body := storage.NewReadSeeker(r.Context(), app.storage, song.Audio.Key, song.Audio.Size)
+	defer body.Close()
+	iw := &idleTimeoutWriter{
+		ResponseWriter: w,
+		rc:             http.NewResponseController(w),
+		timeout:        app.config.stream.idleTimeout,
+	}
+	http.ServeContent(iw, r, "", time.Time{}, body)
+}
+
+func (app *application) streamSongHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	app.serveAudio(w, r, song)
+}
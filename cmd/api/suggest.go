@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/suggest"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+// The suggestHandler() sends completions for what the client has typed into a search
+// box, drawn from song titles, artists and genres and ranked by popularity. They come
+// from the in-memory index rather than the database, so they are only as fresh as the
+// last rebuild.
+func (app *application) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	query := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 10, v)
+	v.Check(query != "", "q", "must be provided")
+	v.Check(len(query) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= suggest.MaxLimit, "limit", fmt.Sprintf("must be a maximum of %d", suggest.MaxLimit))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	suggestions := app.suggestions.Lookup(query, limit)
+	err := app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The buildSuggestions() method rebuilds the autocomplete index from the catalogue. It
+// is run at startup and then periodically through app.schedule(). If the catalogue
+// can't be read, the old index is kept.
+func (app *application) buildSuggestions() {
+	suggestions, err := app.models.Search.Suggestions()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	app.suggestions.Replace(suggestions)
+}
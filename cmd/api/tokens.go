@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"nurgazinovd_golang_lg/internal/data"
 	"nurgazinovd_golang_lg/internal/validator"
@@ -59,6 +60,9 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	// Send the user a security alert about the new sign-in. These can't be switched
+	// off in the notification preferences.
+	app.notify(user.ID, data.NotificationSecurityAlert, fmt.Sprintf("New sign-in to your account from %s", app.clientIP(r)))
 	// Encode the token to JSON and send it in the response along with a 201 Created
 	// status code.
 	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
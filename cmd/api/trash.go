@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// The listTrashedSongsHandler() sends a page of the songs in the trash, most recently
+// deleted first unless the client asks otherwise.
+func (app *application) listTrashedSongsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-deleted_at"),
+		SortSafelist: []string{"deleted_at", "title", "-deleted_at", "-title"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	songs, metadata, err := app.models.Songs.GetTrash(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.formatSongs(r, songs...)
+	err = app.writeJSON(w, http.StatusOK, envelope{"songs": songs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The restoreSongHandler() takes a song out of the trash. The client must send the
+// version of the song it saw in the trash, so that a song which has been deleted and
+// restored again in the meantime isn't restored by mistake. A song whose ISRC has been
+// given to another song since it was deleted can't be restored until one of them
+// changes.
+func (app *application) restoreSongHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	song, err := app.models.Songs.GetDeleted(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input struct {
+		Version *int32 `json:"version"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.Version != nil, "version", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if *input.Version != song.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+	err = app.models.Songs.Restore(song, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateISRC):
+			v.AddError("isrc", "a song with this ISRC already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.formatSongs(r, song)
+	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The purgeTrash() method is run periodically to permanently delete the songs which
+// have been in the trash for longer than the retention period, and then their media
+// files.
+func (app *application) purgeTrash() {
+	songs, err := app.models.Songs.Purge(time.Now().Add(-app.config.trash.retention))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	for _, song := range songs {
+		if song.Audio != nil {
+			app.deleteBlobs(song.Audio.Key, waveformKey(song.Audio.Key))
+		}
+		if song.ArtworkKey != "" {
+			app.deleteBlobs(artworkKeys(song.ArtworkKey)...)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"nurgazinovd_golang_lg/internal/data"
+	"nurgazinovd_golang_lg/internal/storage"
+	"nurgazinovd_golang_lg/internal/validator"
+	"nurgazinovd_golang_lg/internal/waveform"
+	"time"
+)
+
+// How long the waveform job may spend on a single file.
+const waveformTimeout = 10 * time.Minute
+
+// The waveformKey() function returns the blob key of the peaks computed for an audio
+// file. Deriving it from the audio key means the two are always replaced and deleted
+// together.
+func waveformKey(audioKey string) string {
+	return audioKey + ".waveform"
+}
+
+// The generateWaveform() helper marks the song's waveform as pending and queues a
+// background job to compute it. Only WAV and FLAC files are decoded; anything else is
+// marked as unsupported straight away. The song's WaveformStatus is updated to match.
+func (app *application) generateWaveform(song *data.Song) {
+	audio := song.Audio
+	status := data.WaveformPending
+	if !waveform.Supported(audio.MimeType) {
+		status = data.WaveformUnsupported
+	}
+	err := app.models.Songs.UpdateWaveformStatus(song.ID, audio.Key, status)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	song.WaveformStatus = status
+	if status != data.WaveformPending {
+		return
+	}
+	app.background(func() {
+		status := data.WaveformReady
+		err := app.computeWaveform(song.ID, audio)
+		if err != nil {
+			status = data.WaveformFailed
+			app.logger.PrintError(err, map[string]string{"key": audio.Key})
+		}
+		err = app.models.Songs.UpdateWaveformStatus(song.ID, audio.Key, status)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// The computeWaveform() helper decodes an audio file from the blob store and stores its
+// peaks alongside it.
+func (app *application) computeWaveform(songID int64, audio *data.AudioFile) error {
+	err := app.models.Songs.UpdateWaveformStatus(songID, audio.Key, data.WaveformProcessing)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), waveformTimeout)
+	defer cancel()
+	rc, err := app.storage.Get(ctx, audio.Key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := waveform.Compute(rc, audio.MimeType)
+	if err != nil {
+		return err
+	}
+	b, err := w.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = app.storage.Put(ctx, waveformKey(audio.Key), bytes.NewReader(b), "application/octet-stream")
+	return err
+}
+
+// The showSongWaveformHandler() sends the song's waveform at the resolution given by
+// the points query string parameter. The data array holds a min and max value for
+// each point, scaled to 16 bits. If the waveform is still being computed we send a
+// 202 Accepted response with the current status instead.
+func (app *application) showSongWaveformHandler(w http.ResponseWriter, r *http.Request) {
+	song := app.readSong(w, r)
+	if song == nil {
+		return
+	}
+	v := validator.New()
+	points := app.readInt(r.URL.Query(), "points", 1024, v)
+	v.Check(points > 0, "points", "must be greater than zero")
+	v.Check(points <= waveform.MaxPoints, "points", "must be a maximum of 8192")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	switch song.WaveformStatus {
+	case data.WaveformReady:
+	case data.WaveformPending, data.WaveformProcessing:
+		headers := make(http.Header)
+		headers.Set("Retry-After", "5")
+		err := app.writeJSON(w, http.StatusAccepted, envelope{"waveform_status": song.WaveformStatus}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	default:
+		app.notFoundResponse(w, r)
+		return
+	}
+	rc, err := app.storage.Get(r.Context(), waveformKey(song.Audio.Key))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var wf waveform.Waveform
+	err = wf.UnmarshalBinary(b)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	peaks := wf.Points(points)
+	values := make([]int16, 0, 2*len(peaks))
+	for _, p := range peaks {
+		values = append(values, p.Min, p.Max)
+	}
+	output := envelope{
+		"points": len(peaks),
+		"bits":   16,
+		"data":   values,
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"waveform": output}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package artwork validates uploaded cover images and renders the fixed-size JPEG
+// thumbnails that we serve to clients. Decoding and resizing are done in pure Go.
+package artwork
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+	ErrTooSmall          = errors.New("image dimensions are too small")
+)
+
+// Sizes lists the width and height, in pixels, of each square thumbnail we generate.
+var Sizes = []int{64, 300, 640}
+
+// ContentType is the MIME type of the generated thumbnails.
+const ContentType = "image/jpeg"
+
+// Every format has its own decoder, so that we only ever accept the formats we have
+// sniffed rather than anything registered with the image package.
+var decoders = map[string]struct {
+	decode       func(io.Reader) (image.Image, error)
+	decodeConfig func(io.Reader) (image.Config, error)
+}{
+	"jpeg": {jpeg.Decode, jpeg.DecodeConfig},
+	"png":  {png.Decode, png.DecodeConfig},
+	"webp": {webp.Decode, webp.DecodeConfig},
+}
+
+// DetectFormat identifies an image from its magic bytes, returning "jpeg", "png" or
+// "webp", or the empty string for anything else.
+func DetectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("\xFF\xD8\xFF")):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1A\n")):
+		return "png"
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// Decode decodes an image after checking its dimensions from the header alone. This
+// stops a small, highly compressed file from expanding into an enormous bitmap in
+// memory. Any metadata in the file, such as EXIF, is discarded by decoding.
+func Decode(b []byte, maxPixels int) (image.Image, error) {
+	d, ok := decoders[DetectFormat(b)]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+	cfg, err := d.decodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width < 1 || cfg.Height < 1 {
+		return nil, ErrTooSmall
+	}
+	if cfg.Width > maxPixels/cfg.Height {
+		return nil, ErrTooLarge
+	}
+	img, err := d.decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	return img, nil
+}
+
+// Thumbnail crops the centre square out of an image and scales it to size x size
+// pixels. Transparent areas are flattened onto a white background, as JPEG has no
+// alpha channel.
+func Thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	return dst
+}
+
+// Encode writes a thumbnail as a baseline JPEG. The output carries no metadata.
+func Encode(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
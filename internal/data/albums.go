@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+var ErrInvalidAlbumSong = errors.New("invalid album song")
+
+// An Album is a release grouping songs into a track list. SongIDs lists the tracks in
+// order, leaving out any songs which are in the trash.
+type Album struct {
+	ID      int64   `json:"id"`
+	Title   string  `json:"title"`
+	Artist  string  `json:"artist"`
+	Year    int32   `json:"year"`
+	SongIDs []int64 `json:"song_ids"`
+	Version int32   `json:"version"`
+}
+
+func ValidateAlbum(v *validator.Validator, album *Album) {
+	v.Check(album.Title != "", "title", "must be provided")
+	v.Check(len(album.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(album.Artist != "", "artist", "must be provided")
+	v.Check(len(album.Artist) <= 200, "artist", "must not be more than 200 bytes long")
+	v.Check(album.Year != 0, "year", "must be provided")
+	v.Check(album.Year >= 1888, "year", "must be greater than 1888")
+	v.Check(album.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	v.Check(len(album.SongIDs) <= 200, "song_ids", "must not contain more than 200 songs")
+	seen := make(map[int64]bool)
+	for _, id := range album.SongIDs {
+		v.Check(id > 0, "song_ids", "must only contain positive song IDs")
+		v.Check(!seen[id], "song_ids", "must not contain duplicate songs")
+		seen[id] = true
+	}
+}
+
+// albumColumns lists the columns read by every query which returns albums.
+const albumColumns = `albums.id, albums.title, albums.artist, albums.year,
+    ARRAY(SELECT album_songs.song_id FROM album_songs
+        INNER JOIN songs ON songs.id = album_songs.song_id
+        WHERE album_songs.album_id = albums.id AND songs.deleted_at IS NULL
+        ORDER BY album_songs.position),
+    albums.version`
+
+func scanAlbum(row rowScanner, extra ...interface{}) (*Album, error) {
+	var album Album
+	dest := append(extra,
+		&album.ID,
+		&album.Title,
+		&album.Artist,
+		&album.Year,
+		pq.Array(&album.SongIDs),
+		&album.Version,
+	)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	if album.SongIDs == nil {
+		album.SongIDs = []int64{}
+	}
+	return &album, nil
+}
+
+type AlbumModel struct {
+	DB *sql.DB
+}
+
+// The setAlbumSongs() function replaces the track list of an album within a
+// transaction. It returns ErrInvalidAlbumSong if any of the songs don't exist or are
+// in the trash.
+func setAlbumSongs(ctx context.Context, tx *sql.Tx, albumID int64, songIDs []int64) error {
+	query := `
+SELECT count(*)
+FROM songs
+WHERE id = ANY($1) AND deleted_at IS NULL`
+	var found int
+	err := tx.QueryRowContext(ctx, query, pq.Array(songIDs)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(songIDs) {
+		return ErrInvalidAlbumSong
+	}
+	query = `
+DELETE FROM album_songs
+WHERE album_id = $1`
+	_, err = tx.ExecContext(ctx, query, albumID)
+	if err != nil {
+		return err
+	}
+	query = `
+INSERT INTO album_songs (album_id, song_id, position)
+SELECT $1, given.id, given.i
+FROM unnest($2::bigint[]) WITH ORDINALITY AS given(id, i)`
+	_, err = tx.ExecContext(ctx, query, albumID, pq.Array(songIDs))
+	return err
+}
+
+func (m AlbumModel) Insert(album *Album) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+INSERT INTO albums (title, artist, year)
+VALUES ($1, $2, $3)
+RETURNING id, version`
+	err = tx.QueryRowContext(ctx, query, album.Title, album.Artist, album.Year).Scan(&album.ID, &album.Version)
+	if err != nil {
+		return err
+	}
+	err = setAlbumSongs(ctx, tx, album.ID, album.SongIDs)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m AlbumModel) Get(id int64) (*Album, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT ` + albumColumns + `
+FROM albums
+WHERE albums.id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	album, err := scanAlbum(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return album, nil
+}
+
+// The Update() method saves the album and its track list, using the version number for
+// optimistic locking in the same way as SongModel.Update().
+func (m AlbumModel) Update(album *Album) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+UPDATE albums
+SET title = $1, artist = $2, year = $3, version = version + 1
+WHERE id = $4 AND version = $5
+RETURNING version`
+	args := []interface{}{album.Title, album.Artist, album.Year, album.ID, album.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&album.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	err = setAlbumSongs(ctx, tx, album.ID, album.SongIDs)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The Delete() method deletes an album along with the comments and reviews on it. The
+// songs on it are left alone.
+func (m AlbumModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM albums
+WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAll() method returns a page of the albums whose title matches the search, or
+// of all albums if title is empty.
+func (m AlbumModel) GetAll(title string, filters Filters) ([]*Album, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), `+albumColumns+`
+FROM albums
+WHERE (to_tsvector('simple', albums.title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+ORDER BY albums.%s %s, albums.id ASC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, title, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	albums := []*Album{}
+	for rows.Next() {
+		album, err := scanAlbum(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		albums = append(albums, album)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return albums, metadata, nil
+}
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// AudioFile describes the media attached to a song. The storage key is never exposed
+// to clients.
+type AudioFile struct {
+	Key      string `json:"-"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum_sha256"`
+	MimeType string `json:"mime_type"`
+}
+
+// The possible states of the waveform job for an audio file.
+const (
+	WaveformPending     = "pending"
+	WaveformProcessing  = "processing"
+	WaveformReady       = "ready"
+	WaveformFailed      = "failed"
+	WaveformUnsupported = "unsupported"
+)
+
+// nullAudio is used to scan the nullable audio columns of the songs table.
+type nullAudio struct {
+	key      sql.NullString
+	size     sql.NullInt64
+	checksum sql.NullString
+	mime     sql.NullString
+}
+
+func (n nullAudio) audio() *AudioFile {
+	if !n.key.Valid {
+		return nil
+	}
+	return &AudioFile{
+		Key:      n.key.String,
+		Size:     n.size.Int64,
+		Checksum: n.checksum.String,
+		MimeType: n.mime.String,
+	}
+}
+
+func toNullAudio(a *AudioFile) nullAudio {
+	if a == nil {
+		return nullAudio{}
+	}
+	return nullAudio{
+		key:      sql.NullString{String: a.Key, Valid: true},
+		size:     sql.NullInt64{Int64: a.Size, Valid: true},
+		checksum: sql.NullString{String: a.Checksum, Valid: true},
+		mime:     sql.NullString{String: a.MimeType, Valid: true},
+	}
+}
+
+// An AudioUpload tracks a resumable, chunked upload of a song's audio file. The chunks
+// themselves are kept in the blob store until the upload is complete.
+type AudioUpload struct {
+	ID        int64     `json:"id"`
+	SongID    int64     `json:"song_id"`
+	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+	Size      int64     `json:"size"`
+	Received  int64     `json:"received"`
+	// PartKeys are the blob keys of the chunks received so far, in order.
+	PartKeys []string `json:"-"`
+}
+
+// Complete reports whether every byte of the upload has been received.
+func (u *AudioUpload) Complete() bool {
+	return u.Received == u.Size
+}
+
+func ValidateAudioUpload(v *validator.Validator, upload *AudioUpload, maxBytes int64) {
+	v.Check(upload.Size > 0, "size", "must be greater than zero")
+	v.Check(upload.Size <= maxBytes, "size", "must not be larger than the maximum upload size")
+}
+
+type AudioUploadModel struct {
+	DB *sql.DB
+}
+
+func (m AudioUploadModel) Insert(upload *AudioUpload) error {
+	query := `
+INSERT INTO audio_uploads (song_id, user_id, expiry, size)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at`
+	args := []interface{}{upload.SongID, upload.UserID, upload.Expiry, upload.Size}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&upload.ID, &upload.CreatedAt)
+}
+
+// The Get() method returns an unexpired upload belonging to the given user and song.
+func (m AudioUploadModel) Get(id, songID, userID int64) (*AudioUpload, error) {
+	query := `
+SELECT id, song_id, user_id, created_at, expiry, size, received, part_keys
+FROM audio_uploads
+WHERE id = $1 AND song_id = $2 AND user_id = $3 AND expiry > $4`
+	var upload AudioUpload
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id, songID, userID, time.Now()).Scan(
+		&upload.ID,
+		&upload.SongID,
+		&upload.UserID,
+		&upload.CreatedAt,
+		&upload.Expiry,
+		&upload.Size,
+		&upload.Received,
+		pq.Array(&upload.PartKeys),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &upload, nil
+}
+
+// The AddPart() method records a newly stored chunk of n bytes under the given key. The
+// current received count acts as the version number here, so two clients racing to
+// upload the same chunk can't both succeed.
+func (m AudioUploadModel) AddPart(upload *AudioUpload, key string, n int64) error {
+	query := `
+UPDATE audio_uploads
+SET received = received + $1, part_keys = array_append(part_keys, $2)
+WHERE id = $3 AND received = $4
+RETURNING received, part_keys`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, n, key, upload.ID, upload.Received).Scan(&upload.Received, pq.Array(&upload.PartKeys))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m AudioUploadModel) Delete(id int64) error {
+	query := `
+DELETE FROM audio_uploads
+WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// The DeleteExpired() method removes the uploads which expired before they were
+// completed, returning them so that the caller can remove their chunks from the blob
+// store.
+func (m AudioUploadModel) DeleteExpired() ([]*AudioUpload, error) {
+	query := `
+DELETE FROM audio_uploads
+WHERE expiry < $1
+RETURNING id, song_id, user_id, created_at, expiry, size, received, part_keys`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	uploads := []*AudioUpload{}
+	for rows.Next() {
+		var upload AudioUpload
+		err := rows.Scan(
+			&upload.ID,
+			&upload.SongID,
+			&upload.UserID,
+			&upload.CreatedAt,
+			&upload.Expiry,
+			&upload.Size,
+			&upload.Received,
+			pq.Array(&upload.PartKeys),
+		)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &upload)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
@@ -0,0 +1,314 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// PlayRollupLag is how far behind the present the rollups stop. Plays are stamped with
+// the start time of the transaction which inserts them, so this leaves time for any
+// transaction still in progress to commit before its plays are rolled up.
+const PlayRollupLag = time.Minute
+
+// The periods which charts can cover.
+const (
+	ChartDay   = "day"
+	ChartWeek  = "week"
+	ChartMonth = "month"
+)
+
+// Songs must have at least TrendingMinPlays plays in the current window to trend.
+// TrendingSmoothing is added to the previous window's plays when working out the
+// growth, so that a song going from one play to three doesn't outrank one going from a
+// thousand to two thousand.
+const (
+	TrendingMinPlays  = 3
+	TrendingSmoothing = 10
+)
+
+// rollupQueries add the plays received in the window between $1 and $2 to each of the
+// rollups. Hours and days are in UTC.
+var rollupQueries = []string{`
+INSERT INTO song_plays_hourly (song_id, hour, plays, ms_played)
+SELECT song_id, date_trunc('hour', played_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', count(*), sum(ms_played)
+FROM plays
+WHERE received_at > $1 AND received_at <= $2
+GROUP BY 1, 2
+ON CONFLICT (song_id, hour) DO UPDATE
+SET plays = song_plays_hourly.plays + EXCLUDED.plays, ms_played = song_plays_hourly.ms_played + EXCLUDED.ms_played`, `
+INSERT INTO song_plays_daily (song_id, day, plays, ms_played)
+SELECT song_id, (played_at AT TIME ZONE 'UTC')::date, count(*), sum(ms_played)
+FROM plays
+WHERE received_at > $1 AND received_at <= $2
+GROUP BY 1, 2
+ON CONFLICT (song_id, day) DO UPDATE
+SET plays = song_plays_daily.plays + EXCLUDED.plays, ms_played = song_plays_daily.ms_played + EXCLUDED.ms_played`, `
+INSERT INTO song_play_counts (song_id, plays, ms_played)
+SELECT song_id, count(*), sum(ms_played)
+FROM plays
+WHERE received_at > $1 AND received_at <= $2
+GROUP BY 1
+ON CONFLICT (song_id) DO UPDATE
+SET plays = song_play_counts.plays + EXCLUDED.plays, ms_played = song_play_counts.ms_played + EXCLUDED.ms_played`,
+}
+
+// ValidateChartPeriod checks the period of a chart.
+func ValidateChartPeriod(v *validator.Validator, period string) {
+	v.Check(validator.In(period, ChartDay, ChartWeek, ChartMonth), "period", "must be day, week or month")
+}
+
+// A ChartWindow is the span of time a chart covers. The day chart covers the last 24
+// complete hours, and the others the last 7 or 30 complete days, all in UTC. The
+// previous window is the same length again, immediately before.
+type ChartWindow struct {
+	Period string    `json:"period"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// NewChartWindow returns the window for the period ending at now.
+func NewChartWindow(period string, now time.Time) ChartWindow {
+	now = now.UTC()
+	w := ChartWindow{Period: period}
+	switch period {
+	case ChartDay:
+		w.To = now.Truncate(time.Hour)
+		w.From = w.To.Add(-24 * time.Hour)
+	case ChartWeek:
+		w.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		w.From = w.To.AddDate(0, 0, -7)
+	default:
+		w.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		w.From = w.To.AddDate(0, 0, -30)
+	}
+	return w
+}
+
+// Previous returns the window of the same length immediately before this one.
+func (w ChartWindow) Previous() ChartWindow {
+	return ChartWindow{Period: w.Period, From: w.From.Add(-w.To.Sub(w.From)), To: w.From}
+}
+
+// rollup returns the rollup table and time column to read the window from.
+func (w ChartWindow) rollup() (string, string) {
+	if w.Period == ChartDay {
+		return "song_plays_hourly", "hour"
+	}
+	return "song_plays_daily", "day"
+}
+
+// bound converts a time into a query argument for the window's rollup. The daily
+// rollup is keyed by date, which Postgres would otherwise convert to a time in the
+// session's time zone for the comparison.
+func (w ChartWindow) bound(t time.Time) interface{} {
+	if w.Period == ChartDay {
+		return t
+	}
+	return t.Format("2006-01-02")
+}
+
+// A ChartEntry is a song's place in a chart.
+type ChartEntry struct {
+	Rank  int   `json:"rank"`
+	Plays int64 `json:"plays"`
+	Song  *Song `json:"song"`
+}
+
+// A TrendingEntry is a song's place in the trending chart. Velocity is the growth in
+// plays against the previous window.
+type TrendingEntry struct {
+	Rank          int     `json:"rank"`
+	Plays         int64   `json:"plays"`
+	PreviousPlays int64   `json:"previous_plays"`
+	Velocity      float64 `json:"velocity"`
+	Song          *Song   `json:"song"`
+}
+
+type ChartModel struct {
+	DB *sql.DB
+}
+
+// The RollUp() method adds the plays received since the last run to the rollups. The
+// state row is locked for the duration, so that concurrent runs from several
+// instances of the application queue up rather than counting plays twice.
+func (m ChartModel) RollUp() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var from, to time.Time
+	query := `
+SELECT rolled_up_to, date_trunc('second', NOW() - make_interval(secs => $1))
+FROM play_rollup_state
+FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, PlayRollupLag.Seconds()).Scan(&from, &to)
+	if err != nil {
+		return err
+	}
+	if !to.After(from) {
+		return nil
+	}
+	err = rollUp(ctx, tx, from, to)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The RebuildRollups() method throws the rollups away and recomputes them from every
+// play received so far.
+func (m ChartModel) RebuildRollups() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var to time.Time
+	query := `
+SELECT date_trunc('second', NOW() - make_interval(secs => $1))
+FROM play_rollup_state
+FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, PlayRollupLag.Seconds()).Scan(&to)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"song_plays_hourly", "song_plays_daily", "song_play_counts"} {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+table)
+		if err != nil {
+			return err
+		}
+	}
+	err = rollUp(ctx, tx, time.Unix(0, 0), to)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rollUp adds the plays received after from and up to to to each rollup, and records
+// how far the rollups have got.
+func rollUp(ctx context.Context, tx *sql.Tx, from, to time.Time) error {
+	for _, query := range rollupQueries {
+		_, err := tx.ExecContext(ctx, query, from, to)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE play_rollup_state SET rolled_up_to = $1`, to)
+	return err
+}
+
+// The GetTop() method returns a page of the songs with the most plays in the window,
+// optionally limited to one genre.
+func (m ChartModel) GetTop(window ChartWindow, genre string, filters Filters) ([]*ChartEntry, Metadata, error) {
+	table, column := window.rollup()
+	query := fmt.Sprintf(`
+WITH totals AS (
+    SELECT song_id, sum(plays)::bigint AS plays
+    FROM %[1]s
+    WHERE %[2]s >= $1 AND %[2]s < $2
+    GROUP BY song_id
+)
+SELECT count(*) OVER(), totals.plays, `+songColumns+`
+FROM totals
+INNER JOIN songs ON songs.id = totals.song_id
+`+songStatsJoin+`
+WHERE songs.deleted_at IS NULL AND ($3 = '' OR $3 = ANY(songs.genres))
+ORDER BY totals.plays DESC, songs.id ASC
+LIMIT $4 OFFSET $5`, table, column)
+	args := []interface{}{window.bound(window.From), window.bound(window.To), genre, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	entries := []*ChartEntry{}
+	for rows.Next() {
+		entry := ChartEntry{Rank: filters.offset() + len(entries) + 1}
+		entry.Song, err = scanSong(rows, &totalRecords, &entry.Plays)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+// The GetTrending() method returns a page of the songs whose plays grew the fastest
+// against the previous window, optionally limited to one genre.
+func (m ChartModel) GetTrending(window ChartWindow, genre string, filters Filters) ([]*TrendingEntry, Metadata, error) {
+	table, column := window.rollup()
+	previous := window.Previous()
+	query := fmt.Sprintf(`
+WITH recent AS (
+    SELECT song_id, sum(plays)::bigint AS plays
+    FROM %[1]s
+    WHERE %[2]s >= $1 AND %[2]s < $2
+    GROUP BY song_id
+), earlier AS (
+    SELECT song_id, sum(plays)::bigint AS plays
+    FROM %[1]s
+    WHERE %[2]s >= $3 AND %[2]s < $1
+    GROUP BY song_id
+), trending AS (
+    SELECT recent.song_id, recent.plays, COALESCE(earlier.plays, 0) AS previous_plays,
+        (recent.plays - COALESCE(earlier.plays, 0))::float8 / (COALESCE(earlier.plays, 0) + $4) AS velocity
+    FROM recent
+    LEFT JOIN earlier ON earlier.song_id = recent.song_id
+    WHERE recent.plays >= $5 AND recent.plays > COALESCE(earlier.plays, 0)
+)
+SELECT count(*) OVER(), trending.plays, trending.previous_plays, trending.velocity, `+songColumns+`
+FROM trending
+INNER JOIN songs ON songs.id = trending.song_id
+`+songStatsJoin+`
+WHERE songs.deleted_at IS NULL AND ($6 = '' OR $6 = ANY(songs.genres))
+ORDER BY trending.velocity DESC, trending.plays DESC, songs.id ASC
+LIMIT $7 OFFSET $8`, table, column)
+	args := []interface{}{
+		window.bound(window.From),
+		window.bound(window.To),
+		window.bound(previous.From),
+		TrendingSmoothing,
+		TrendingMinPlays,
+		genre,
+		filters.limit(),
+		filters.offset(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	entries := []*TrendingEntry{}
+	for rows.Next() {
+		entry := TrendingEntry{Rank: filters.offset() + len(entries) + 1}
+		entry.Song, err = scanSong(rows, &totalRecords, &entry.Plays, &entry.PreviousPlays, &entry.Velocity)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
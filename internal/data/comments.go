@@ -0,0 +1,521 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strings"
+	"time"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Define constants for the kinds of post. Reviews are longer, have a title and can't be
+// replies, and a user can only have one review of each song or album.
+const (
+	CommentKindComment = "comment"
+	CommentKindReview  = "review"
+)
+
+// Define constants for the moderation status of a post. Pending posts have been held
+// for review, either by the word and link filter or because they were reported, and
+// are only visible to their author until a moderator approves them.
+const (
+	CommentPublished = "published"
+	CommentPending   = "pending"
+	CommentHidden    = "hidden"
+)
+
+// Define constants for the decisions a moderator can make about a held post. Banning
+// hides the post and stops its author from posting again.
+const (
+	ModerationApprove = "approve"
+	ModerationHide    = "hide"
+	ModerationBan     = "ban"
+)
+
+// CommentReportThreshold is the number of open reports which sends a published post
+// back to the moderation queue.
+const CommentReportThreshold = 3
+
+// A Comment is a comment, reply or review on either a song or an album, so exactly one
+// of SongID and AlbumID is set.
+type Comment struct {
+	ID         int64      `json:"id"`
+	SongID     int64      `json:"song_id,omitempty"`
+	AlbumID    int64      `json:"album_id,omitempty"`
+	ParentID   int64      `json:"parent_id,omitempty"`
+	Kind       string     `json:"kind"`
+	Title      string     `json:"title,omitempty"`
+	Body       string     `json:"body"`
+	Status     string     `json:"status"`
+	HeldReason string     `json:"held_reason,omitempty"`
+	Author     PublicUser `json:"author"`
+	Replies    int64      `json:"replies"`
+	// Reports is the number of open reports, which is only filled in for the
+	// moderation queue.
+	Reports   int64      `json:"reports,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	Version   int32      `json:"version"`
+}
+
+// A CommentRevision is one version of a post's title and body.
+type CommentRevision struct {
+	Version   int32     `json:"version"`
+	Title     string    `json:"title,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(validator.In(comment.Kind, CommentKindComment, CommentKindReview), "kind", "must be either comment or review")
+	v.Check(strings.TrimSpace(comment.Body) != "", "body", "must be provided")
+	switch comment.Kind {
+	case CommentKindReview:
+		v.Check(strings.TrimSpace(comment.Title) != "", "title", "must be provided")
+		v.Check(len(comment.Title) <= 200, "title", "must not be more than 200 bytes long")
+		v.Check(len(comment.Body) <= 20_000, "body", "must not be more than 20000 bytes long")
+		v.Check(comment.ParentID == 0, "parent_id", "must not be set for a review")
+	default:
+		v.Check(comment.Title == "", "title", "must not be set for a comment")
+		v.Check(len(comment.Body) <= 2000, "body", "must not be more than 2000 bytes long")
+	}
+}
+
+func ValidateReportReason(v *validator.Validator, reason string) {
+	v.Check(strings.TrimSpace(reason) != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// commentColumns lists the columns read by every query which returns posts, in the
+// order expected by scanComment(). The query must join the author from users.
+const commentColumns = `comments.id, COALESCE(comments.song_id, 0), COALESCE(comments.album_id, 0),
+    COALESCE(comments.parent_id, 0), comments.kind,
+    comments.title, comments.body, comments.status, COALESCE(comments.held_reason, ''), users.id, users.name,
+    (SELECT count(*) FROM comments AS replies
+     WHERE replies.parent_id = comments.id AND replies.status = 'published' AND replies.deleted_at IS NULL),
+    (SELECT count(*) FROM comment_reports
+     WHERE comment_reports.comment_id = comments.id AND comment_reports.resolved_at IS NULL),
+    comments.created_at, comments.edited_at, comments.deleted_at IS NOT NULL, comments.version`
+
+// The scanComment() function reads a post selected using commentColumns, after any
+// extra destinations. The text of deleted posts is left out.
+func scanComment(row rowScanner, extra ...interface{}) (*Comment, int64, error) {
+	var comment Comment
+	var reports int64
+	dest := append(extra,
+		&comment.ID,
+		&comment.SongID,
+		&comment.AlbumID,
+		&comment.ParentID,
+		&comment.Kind,
+		&comment.Title,
+		&comment.Body,
+		&comment.Status,
+		&comment.HeldReason,
+		&comment.Author.ID,
+		&comment.Author.Name,
+		&comment.Replies,
+		&reports,
+		&comment.CreatedAt,
+		&comment.EditedAt,
+		&comment.Deleted,
+		&comment.Version,
+	)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if comment.Deleted {
+		comment.Title = ""
+		comment.Body = ""
+	}
+	return &comment, reports, nil
+}
+
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method saves a new post. A reply must be to a published post on the same
+// song or album, and ErrRecordNotFound is returned if it isn't.
+func (m CommentModel) Insert(comment *Comment) error {
+	query := `
+INSERT INTO comments (song_id, album_id, user_id, parent_id, kind, title, body, status, held_reason)
+SELECT NULLIF($1::bigint, 0), NULLIF($9::bigint, 0), $2::bigint, NULLIF($3::bigint, 0), $4::text, $5::text,
+    $6::text, $7::text, NULLIF($8::text, '')
+WHERE $3 = 0 OR EXISTS (
+    SELECT 1 FROM comments
+    WHERE id = $3 AND COALESCE(song_id, 0) = $1 AND COALESCE(album_id, 0) = $9
+    AND status = 'published' AND deleted_at IS NULL
+)
+RETURNING id, created_at, version`
+	args := []interface{}{
+		comment.SongID,
+		comment.Author.ID,
+		comment.ParentID,
+		comment.Kind,
+		comment.Title,
+		comment.Body,
+		comment.Status,
+		comment.HeldReason,
+		comment.AlbumID,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "comments_review_key"`,
+			err.Error() == `pq: duplicate key value violates unique constraint "comments_album_review_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The Get() method returns a post, unless it is on a song which is in the trash. Posts
+// on albums have no song, hence the left join.
+func (m CommentModel) Get(id int64) (*Comment, error) {
+	query := `
+SELECT ` + commentColumns + `
+FROM comments
+INNER JOIN users ON users.id = comments.user_id
+LEFT JOIN songs ON songs.id = comments.song_id
+WHERE comments.id = $1 AND songs.deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	comment, _, err := scanComment(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return comment, nil
+}
+
+// The Update() method saves an edit to a post, keeping the version it replaces in
+// comment_revisions. It uses the version number for optimistic locking in the same way
+// as SongModel.Update().
+func (m CommentModel) Update(comment *Comment) error {
+	query := `
+WITH previous AS (
+    INSERT INTO comment_revisions (comment_id, version, title, body, created_at)
+    SELECT id, version, title, body, COALESCE(edited_at, created_at)
+    FROM comments
+    WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+    ON CONFLICT (comment_id, version) DO NOTHING
+)
+UPDATE comments
+SET title = $3, body = $4, status = $5, held_reason = NULLIF($6, ''), edited_at = NOW(), version = version + 1
+WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+RETURNING edited_at, version`
+	args := []interface{}{
+		comment.ID,
+		comment.Version,
+		comment.Title,
+		comment.Body,
+		comment.Status,
+		comment.HeldReason,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.EditedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The Delete() method soft deletes a post. Its row is kept, so that replies to it stay
+// in their thread and moderators can still see what it said.
+func (m CommentModel) Delete(id int64) error {
+	query := `
+UPDATE comments
+SET deleted_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAllForSong() method returns a page of the top-level posts on a song, of the
+// given kind or of both kinds if kind is empty.
+func (m CommentModel) GetAllForSong(songID int64, kind string, viewerID int64, filters Filters) ([]*Comment, Metadata, error) {
+	return m.getAll("comments.song_id = $4 AND comments.parent_id IS NULL AND (comments.kind = $5 OR $5 = '')", viewerID, filters, songID, kind)
+}
+
+// The GetAllForAlbum() method returns a page of the top-level posts on an album, of the
+// given kind or of both kinds if kind is empty.
+func (m CommentModel) GetAllForAlbum(albumID int64, kind string, viewerID int64, filters Filters) ([]*Comment, Metadata, error) {
+	return m.getAll("comments.album_id = $4 AND comments.parent_id IS NULL AND (comments.kind = $5 OR $5 = '')", viewerID, filters, albumID, kind)
+}
+
+// The GetReplies() method returns a page of the direct replies to a post.
+func (m CommentModel) GetReplies(parentID int64, viewerID int64, filters Filters) ([]*Comment, Metadata, error) {
+	return m.getAll("comments.parent_id = $4", viewerID, filters, parentID)
+}
+
+// The getAll() method lists the posts matching the where clause which the viewer is
+// allowed to see: published posts, and their own posts whatever their status. Deleted
+// posts are only listed if they have replies, to hold their thread together. The where
+// clause's own arguments start at $4.
+func (m CommentModel) getAll(where string, viewerID int64, filters Filters, args ...interface{}) ([]*Comment, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), `+commentColumns+`
+FROM comments
+INNER JOIN users ON users.id = comments.user_id
+WHERE %s
+AND (comments.status = 'published' OR comments.user_id = $3)
+AND (comments.deleted_at IS NULL OR EXISTS (
+    SELECT 1 FROM comments AS replies
+    WHERE replies.parent_id = comments.id AND replies.status = 'published' AND replies.deleted_at IS NULL
+))
+ORDER BY comments.%s %s, comments.id ASC
+LIMIT $1 OFFSET $2`, where, filters.sortColumn(), filters.sortDirection())
+	args = append([]interface{}{filters.limit(), filters.offset(), viewerID}, args...)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	comments := []*Comment{}
+	for rows.Next() {
+		comment, _, err := scanComment(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, comment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return comments, metadata, nil
+}
+
+// The GetRevisions() method returns every version of a post, newest first.
+func (m CommentModel) GetRevisions(id int64) ([]*CommentRevision, error) {
+	query := `
+SELECT version, title, body, COALESCE(edited_at, created_at)
+FROM comments
+WHERE id = $1
+UNION ALL
+SELECT version, title, body, created_at
+FROM comment_revisions
+WHERE comment_id = $1
+ORDER BY version DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []*CommentRevision{}
+	for rows.Next() {
+		var revision CommentRevision
+		err := rows.Scan(&revision.Version, &revision.Title, &revision.Body, &revision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// The Report() method records a user's report of a post. Reporting the same post twice
+// is harmless. Once a published post has CommentReportThreshold open reports it is
+// held for review.
+func (m CommentModel) Report(commentID, userID int64, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+INSERT INTO comment_reports (comment_id, user_id, reason)
+VALUES ($1, $2, $3)
+ON CONFLICT (comment_id, user_id) DO NOTHING`
+	_, err = tx.ExecContext(ctx, query, commentID, userID, reason)
+	if err != nil {
+		return err
+	}
+	query = `
+UPDATE comments
+SET status = 'pending', held_reason = $2
+WHERE id = $1 AND status = 'published'
+AND (SELECT count(*) FROM comment_reports WHERE comment_id = $1 AND resolved_at IS NULL) >= $3`
+	reason = fmt.Sprintf("reported by %d users", CommentReportThreshold)
+	_, err = tx.ExecContext(ctx, query, commentID, reason, CommentReportThreshold)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The GetQueue() method returns a page of the posts waiting for a moderator, oldest
+// first, along with the number of open reports on each.
+func (m CommentModel) GetQueue(filters Filters) ([]*Comment, Metadata, error) {
+	query := `
+SELECT count(*) OVER(), ` + commentColumns + `
+FROM comments
+INNER JOIN users ON users.id = comments.user_id
+LEFT JOIN songs ON songs.id = comments.song_id
+WHERE comments.status = 'pending' AND comments.deleted_at IS NULL AND songs.deleted_at IS NULL
+ORDER BY comments.created_at ASC, comments.id ASC
+LIMIT $1 OFFSET $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	comments := []*Comment{}
+	for rows.Next() {
+		comment, reports, err := scanComment(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comment.Reports = reports
+		comments = append(comments, comment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return comments, metadata, nil
+}
+
+// The Moderate() method sets the status of a post following a moderator's decision,
+// and closes its open reports. The reason is shown to the author of a hidden post.
+func (m CommentModel) Moderate(id int64, status, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+UPDATE comments
+SET status = $2, held_reason = NULLIF($3, '')
+WHERE id = $1 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, id, status, reason)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	query = `
+UPDATE comment_reports
+SET resolved_at = NOW()
+WHERE comment_id = $1 AND resolved_at IS NULL`
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The Ban() method stops a user from posting, and hides any of their posts which are
+// still waiting in the moderation queue.
+func (m CommentModel) Ban(userID, moderatorID int64, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+INSERT INTO comment_bans (user_id, banned_by, reason)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason, created_at = NOW()`
+	_, err = tx.ExecContext(ctx, query, userID, moderatorID, reason)
+	if err != nil {
+		return err
+	}
+	query = `
+UPDATE comments
+SET status = 'hidden', held_reason = $2
+WHERE user_id = $1 AND status = 'pending'`
+	_, err = tx.ExecContext(ctx, query, userID, reason)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m CommentModel) Unban(userID int64) error {
+	query := `
+DELETE FROM comment_bans
+WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m CommentModel) IsBanned(userID int64) (bool, error) {
+	query := `
+SELECT EXISTS (SELECT 1 FROM comment_bans WHERE user_id = $1)`
+	var banned bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&banned)
+	return banned, err
+}
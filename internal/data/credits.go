@@ -0,0 +1,96 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"nurgazinovd_golang_lg/internal/validator"
+)
+
+var ErrDuplicateISRC = errors.New("duplicate isrc")
+
+// Define constants for the roles which can be credited on a song. The artists are the
+// performers, and only they count as the song's artists when following, searching and
+// matching scrobbles.
+const (
+	CreditArtist   = "artist"
+	CreditWriter   = "writer"
+	CreditComposer = "composer"
+	CreditProducer = "producer"
+)
+
+// A Credit names a contributor to a song. Share is their percentage split of the
+// royalties for their role, if the splits have been agreed.
+type Credit struct {
+	Name  string   `json:"name"`
+	Role  string   `json:"role"`
+	Share *float64 `json:"share,omitempty"`
+}
+
+// Credits is stored in a single jsonb column, so it implements the driver.Valuer and
+// sql.Scanner interfaces.
+type Credits []Credit
+
+func (c Credits) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(c)
+}
+
+// The Artists() method returns the credits for the song's performers.
+func (c Credits) Artists() Credits {
+	var artists Credits
+	for _, credit := range c {
+		if credit.Role == CreditArtist {
+			artists = append(artists, credit)
+		}
+	}
+	return artists
+}
+
+func (c *Credits) Scan(src interface{}) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Credits", src)
+	}
+	err := json.Unmarshal(b, c)
+	if err != nil {
+		return err
+	}
+	// Leave songs without credits as a nil slice, so they're omitted from the JSON.
+	if len(*c) == 0 {
+		*c = nil
+	}
+	return nil
+}
+
+// ValidateCredits checks a song's credits. Splits are optional, but within each role
+// either every credit has a share or none do, and the shares must add up to 100%.
+func ValidateCredits(v *validator.Validator, credits Credits) {
+	v.Check(len(credits) <= 50, "credits", "must not contain more than 50 credits")
+	seen := make(map[Credit]bool)
+	totals := make(map[string]float64)
+	withShares := make(map[string]int)
+	counts := make(map[string]int)
+	for _, credit := range credits {
+		v.Check(credit.Name != "", "credits", "must have a name for every credit")
+		v.Check(len(credit.Name) <= 200, "credits", "must not have names more than 200 bytes long")
+		v.Check(validator.In(credit.Role, CreditArtist, CreditWriter, CreditComposer, CreditProducer), "credits", "must have a role of artist, writer, composer or producer")
+		key := Credit{Name: credit.Name, Role: credit.Role}
+		v.Check(!seen[key], "credits", "must not credit the same person twice in the same role")
+		seen[key] = true
+		counts[credit.Role]++
+		if credit.Share != nil {
+			v.Check(*credit.Share > 0 && *credit.Share <= 100, "credits", "must have shares between 0 and 100")
+			totals[credit.Role] += *credit.Share
+			withShares[credit.Role]++
+		}
+	}
+	for role, n := range withShares {
+		v.Check(n == counts[role], "credits", fmt.Sprintf("must have a share for every %s, or none", role))
+		v.Check(math.Abs(totals[role]-100) < 0.005, "credits", fmt.Sprintf("must have %s shares adding up to 100", role))
+	}
+}
@@ -3,12 +3,47 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var ErrInvalidDurationFormat = errors.New("invalid duration format")
 
+// Define constants for the formats a Duration can be written in. DurationSeconds is a
+// bare number of seconds, DurationClock is "m:ss" or "h:mm:ss", and DurationISO8601 is
+// an ISO 8601 duration such as "PT3M45S". The default, an empty format, is the
+// original "<n> seconds" string.
+const (
+	DurationSeconds = "seconds"
+	DurationClock   = "clock"
+	DurationISO8601 = "iso8601"
+)
+
+// durationFormats describes the accepted input formats, for error messages.
+const durationFormats = `a number of seconds (225), "225 seconds", "3:45", "1:03:45", ISO 8601 ("PT3M45S") or Go style ("3m45s")`
+
+// A DurationError describes a duration which couldn't be parsed. It matches
+// ErrInvalidDurationFormat with errors.Is().
+type DurationError struct {
+	Value  string
+	Reason string
+}
+
+func (e *DurationError) Error() string {
+	return fmt.Sprintf("invalid duration %q: %s; durations can be given as %s", e.Value, e.Reason, durationFormats)
+}
+
+func (e *DurationError) Unwrap() error {
+	return ErrInvalidDurationFormat
+}
+
+// isoDurationRX matches the time part of an ISO 8601 duration, which is all that a
+// song needs.
+var isoDurationRX = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
 type Duration int32
 
 func (r Duration) MarshalJSON() ([]byte, error) {
@@ -17,19 +52,128 @@ func (r Duration) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
+// The MarshalFormat() method writes the duration as JSON in one of the Duration*
+// formats, falling back to MarshalJSON() for the default format.
+func (r Duration) MarshalFormat(format string) ([]byte, error) {
+	hours, minutes, seconds := r/3600, r/60%60, r%60
+	switch format {
+	case DurationSeconds:
+		return []byte(strconv.Itoa(int(r))), nil
+	case DurationClock:
+		if hours > 0 {
+			return []byte(strconv.Quote(fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds))), nil
+		}
+		return []byte(strconv.Quote(fmt.Sprintf("%d:%02d", r/60, seconds))), nil
+	case DurationISO8601:
+		var b strings.Builder
+		b.WriteString("PT")
+		if hours > 0 {
+			fmt.Fprintf(&b, "%dH", hours)
+		}
+		if minutes > 0 {
+			fmt.Fprintf(&b, "%dM", minutes)
+		}
+		if seconds > 0 || r == 0 {
+			fmt.Fprintf(&b, "%dS", seconds)
+		}
+		return []byte(strconv.Quote(b.String())), nil
+	default:
+		return r.MarshalJSON()
+	}
+}
+
+// The UnmarshalJSON() method accepts a duration either as a bare JSON number of
+// seconds or as a string in any of the formats understood by ParseDuration().
 func (r *Duration) UnmarshalJSON(jsonValue []byte) error {
-	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
-	if err != nil {
-		return ErrInvalidDurationFormat
+	s := string(jsonValue)
+	if s == "null" {
+		return nil
 	}
-	parts := strings.Split(unquotedJSONValue, " ")
-	if len(parts) != 2 || parts[1] != "seconds" {
-		return ErrInvalidDurationFormat
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	} else if strings.HasPrefix(s, `"`) {
+		return &DurationError{Value: s, Reason: "is not a valid JSON string"}
 	}
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	d, err := ParseDuration(s)
 	if err != nil {
-		return ErrInvalidDurationFormat
+		return err
 	}
-	*r = Duration(i)
+	*r = d
 	return nil
 }
+
+// ParseDuration parses a duration given as a number of seconds, "<n> seconds", "mm:ss",
+// "h:mm:ss", an ISO 8601 duration such as "PT3M45S", or a Go duration string such as
+// "3m45s". Durations are whole seconds, and the error is a *DurationError saying what
+// is wrong.
+func ParseDuration(s string) (Duration, error) {
+	value := strings.TrimSpace(s)
+	fail := func(reason string) (Duration, error) {
+		return 0, &DurationError{Value: s, Reason: reason}
+	}
+	var seconds int64
+	switch {
+	case value == "":
+		return fail("must not be empty")
+	case strings.HasPrefix(value, "-"):
+		return fail("must not be negative")
+	case strings.HasSuffix(value, " seconds"):
+		n, err := strconv.ParseInt(strings.TrimSuffix(value, " seconds"), 10, 64)
+		if err != nil {
+			return fail(`must have a whole number before "seconds"`)
+		}
+		seconds = n
+	case strings.Contains(value, ":"):
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 {
+			return fail("must have no more than three clock fields, as in h:mm:ss")
+		}
+		for i, part := range parts {
+			n, err := strconv.ParseInt(part, 10, 64)
+			if err != nil || part == "" || strings.HasPrefix(part, "+") {
+				return fail("must only contain digits between the colons")
+			}
+			if i > 0 && (len(part) != 2 || n > 59) {
+				return fail("must have two-digit minutes and seconds between 00 and 59 after a colon")
+			}
+			seconds = seconds*60 + n
+			if seconds > math.MaxInt32 {
+				return fail("is too long")
+			}
+		}
+	case strings.HasPrefix(value, "P"):
+		m := isoDurationRX.FindStringSubmatch(value)
+		if m == nil || value == "PT" {
+			return fail(`must be an ISO 8601 duration made of hours, minutes and whole seconds, such as "PT1H3M45S"`)
+		}
+		for i, unit := range []int64{3600, 60, 1} {
+			if m[i+1] != "" {
+				n, err := strconv.ParseInt(m[i+1], 10, 64)
+				if err != nil || n > math.MaxInt32 {
+					return fail("is too long")
+				}
+				seconds += n * unit
+			}
+		}
+	default:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			seconds = n
+			break
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fail("is not in a recognised format")
+		}
+		if d%time.Second != 0 {
+			return fail("must be a whole number of seconds")
+		}
+		seconds = int64(d / time.Second)
+	}
+	if seconds < 0 {
+		return fail("must not be negative")
+	}
+	if seconds > math.MaxInt32 {
+		return fail("is too long")
+	}
+	return Duration(seconds), nil
+}
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Define constants for the kinds of item in a feed: a new song from a followed artist,
+// and a song saved or liked by a followed user.
+const (
+	FeedNewRelease = "new_release"
+	FeedSaved      = "saved"
+	FeedLiked      = "liked"
+)
+
+// A FeedItem is one entry in a user's feed. Artist is set for new releases, and User
+// for the activity of followed users.
+type FeedItem struct {
+	Kind      string      `json:"kind"`
+	CreatedAt time.Time   `json:"created_at"`
+	Artist    string      `json:"artist,omitempty"`
+	User      *PublicUser `json:"user,omitempty"`
+	Song      *Song       `json:"song"`
+}
+
+// A FeedCursor marks the position of the last item on a page of the feed. Items are
+// ordered newest first, with the kind, song and user breaking ties, so the next page
+// starts with the first item which sorts after the cursor.
+type FeedCursor struct {
+	CreatedAt time.Time `json:"t"`
+	Kind      string    `json:"k"`
+	SongID    int64     `json:"s"`
+	UserID    int64     `json:"u"`
+}
+
+// The String() method encodes the cursor as an opaque URL-safe token.
+func (c FeedCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// The ParseFeedCursor() function decodes a token made by FeedCursor.String(),
+// returning ErrInvalidCursor if it isn't one.
+func ParseFeedCursor(s string) (*FeedCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor FeedCursor
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || cursor.CreatedAt.IsZero() || cursor.SongID < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// feedAfterCursor returns a condition which is true for the items of one branch of the
+// feed which sort after the cursor in placeholders $2 to $5. The time is compared on its
+// own as well, so that the indexes on it can be used.
+func feedAfterCursor(kind, createdAt, songID, userID string) string {
+	return fmt.Sprintf(`($2::timestamptz IS NULL OR (%[2]s <= $2::timestamptz
+    AND (%[2]s, %[1]s::text, %[3]s, %[4]s::bigint) < ($2::timestamptz, $3::text, $4::bigint, $5::bigint)))`,
+		kind, createdAt, songID, userID)
+}
+
+// songNotDeleted returns an EXISTS expression which is true if the song isn't in the
+// trash.
+func songNotDeleted(songID string) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM songs WHERE songs.id = %s AND songs.deleted_at IS NULL)`, songID)
+}
+
+type FeedModel struct {
+	DB *sql.DB
+}
+
+// The Get() method returns up to limit items of the user's feed, starting after the
+// cursor (or from the newest item if it is nil), along with the cursor for the next
+// page. The next cursor is nil on the last page.
+//
+// Feeds aren't stored, but put together from the follows each time they are read, so
+// writes never have to fan out to every follower. Each branch of the union checks the
+// cursor itself, where the added_at and (user_id, created_at) indexes can skip the items
+// before it, and stops after a page's worth of items. Users who hide their activity are
+// left out here too, so changing the setting takes effect straight away.
+//
+// There are no playlists, so the activity of followed users is the songs they save and
+// like rather than their playlist updates.
+func (m FeedModel) Get(userID int64, cursor *FeedCursor, limit int) ([]*FeedItem, *FeedCursor, error) {
+	query := `
+SELECT items.kind, items.created_at, items.user_id, COALESCE(users.name, ''), COALESCE(items.artist, ''), ` + songColumns + `
+FROM ((
+    SELECT $6::text AS kind, songs.added_at AS created_at, songs.id AS song_id, 0::bigint AS user_id, (
+        SELECT artist_follows.name FROM artist_follows
+        WHERE artist_follows.user_id = $1 AND artist_follows.artist = ANY(song_artists(songs.credits))
+        ORDER BY artist_follows.name
+        LIMIT 1
+    ) AS artist
+    FROM songs
+    WHERE song_artists(songs.credits) && ARRAY(SELECT artist FROM artist_follows WHERE user_id = $1)
+    AND songs.deleted_at IS NULL
+    AND ` + feedAfterCursor("$6", "songs.added_at", "songs.id", "0") + `
+    ORDER BY songs.added_at DESC, songs.id DESC
+    LIMIT $9
+) UNION ALL (
+    SELECT $7, library_songs.created_at, library_songs.song_id, library_songs.user_id, ''
+    FROM user_follows
+    INNER JOIN library_songs ON library_songs.user_id = user_follows.followee_id
+    WHERE user_follows.follower_id = $1
+    AND NOT EXISTS (SELECT 1 FROM privacy_settings WHERE user_id = user_follows.followee_id AND hide_activity)
+    AND ` + songNotDeleted("library_songs.song_id") + `
+    AND ` + feedAfterCursor("$7", "library_songs.created_at", "library_songs.song_id", "library_songs.user_id") + `
+    ORDER BY library_songs.created_at DESC, library_songs.song_id DESC, library_songs.user_id DESC
+    LIMIT $9
+) UNION ALL (
+    SELECT $8, song_likes.created_at, song_likes.song_id, song_likes.user_id, ''
+    FROM user_follows
+    INNER JOIN song_likes ON song_likes.user_id = user_follows.followee_id
+    WHERE user_follows.follower_id = $1
+    AND NOT EXISTS (SELECT 1 FROM privacy_settings WHERE user_id = user_follows.followee_id AND hide_activity)
+    AND ` + songNotDeleted("song_likes.song_id") + `
+    AND ` + feedAfterCursor("$8", "song_likes.created_at", "song_likes.song_id", "song_likes.user_id") + `
+    ORDER BY song_likes.created_at DESC, song_likes.song_id DESC, song_likes.user_id DESC
+    LIMIT $9
+)) AS items
+INNER JOIN songs ON songs.id = items.song_id
+` + songStatsJoin + `
+LEFT JOIN users ON users.id = items.user_id
+ORDER BY items.created_at DESC, items.kind DESC, items.song_id DESC, items.user_id DESC
+LIMIT $9`
+	args := []interface{}{userID, nil, "", 0, 0, FeedNewRelease, FeedSaved, FeedLiked, limit + 1}
+	if cursor != nil {
+		args[1], args[2], args[3], args[4] = cursor.CreatedAt, cursor.Kind, cursor.SongID, cursor.UserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	items := []*FeedItem{}
+	for rows.Next() {
+		var item FeedItem
+		var user PublicUser
+		item.Song, err = scanSong(rows, &item.Kind, &item.CreatedAt, &user.ID, &user.Name, &item.Artist)
+		if err != nil {
+			return nil, nil, err
+		}
+		if user.ID != 0 {
+			item.User = &user
+		}
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	// We asked for one more item than needed, to find out whether there's another page.
+	if len(items) <= limit {
+		return items, nil, nil
+	}
+	items = items[:limit]
+	last := items[limit-1]
+	next := &FeedCursor{CreatedAt: last.CreatedAt, Kind: last.Kind, SongID: last.Song.ID}
+	if last.User != nil {
+		next.UserID = last.User.ID
+	}
+	return items, next, nil
+}
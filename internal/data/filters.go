@@ -1,9 +1,15 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
 	"math"
 	"nurgazinovd_golang_lg/internal/validator" // New import
 	"strings"
+	"time"
+	"unicode"
 )
 
 type Filters struct {
@@ -11,6 +17,48 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// Cursor switches a list which supports it from offset to keyset pagination, and
+	// SkipTotal leaves out the count of matching records, which is expensive for big
+	// lists.
+	Cursor    *Cursor
+	SkipTotal bool
+	// MultiSort allows Sort to list several comma-separated columns, such as
+	// "-year,title", for lists which build their ORDER BY clause with sortKeys().
+	MultiSort bool
+}
+
+// maxSortColumns is the most columns a multi-column sort may list.
+const maxSortColumns = 3
+
+// A Cursor marks a position in a sorted list by the sort key of the row at the edge of
+// a page, ending with the row's id. A Before cursor fetches the page before the row
+// rather than the page after it. Cursors are only valid for the sort they were made
+// for.
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Key    []string `json:"k"`
+	Before bool     `json:"b,omitempty"`
+}
+
+// The String() method encodes the cursor as a URL-safe token.
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// The ParseCursor() function decodes a token made by Cursor.String(), returning
+// ErrInvalidCursor if it isn't one.
+func ParseCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || len(cursor.Key) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safelist
@@ -34,6 +82,112 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// A sortKey is one expression of an ORDER BY clause.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// The sortFields() method splits the Sort field into the columns it lists, each with
+// its optional hyphen prefix.
+func (f Filters) sortFields() []string {
+	if !f.MultiSort {
+		return []string{f.Sort}
+	}
+	return strings.Split(f.Sort, ",")
+}
+
+// The sortKeys() method returns the ORDER BY expressions for the filters, using
+// columns to find the SQL expression for each sort column, and ending with idExpr to
+// break ties. The id sorts in the same direction as the column before it, so that a
+// sort by one column can be served by an index on (column, id). The order is reversed
+// when fetching the page before a cursor.
+func (f Filters) sortKeys(columns map[string]string, idExpr string) []sortKey {
+	var keys []sortKey
+	hasID := false
+	for _, field := range f.sortFields() {
+		if !validator.In(field, f.SortSafelist...) {
+			panic("unsafe sort parameter: " + f.Sort)
+		}
+		column := strings.TrimPrefix(field, "-")
+		keys = append(keys, sortKey{expr: columns[column], desc: strings.HasPrefix(field, "-")})
+		hasID = hasID || column == "id"
+	}
+	if !hasID {
+		keys = append(keys, sortKey{expr: idExpr, desc: keys[len(keys)-1].desc})
+	}
+	if f.Cursor != nil && f.Cursor.Before {
+		for i := range keys {
+			keys[i].desc = !keys[i].desc
+		}
+	}
+	return keys
+}
+
+// The orderBy() function returns the ORDER BY clause for the keys, without the
+// keywords.
+func orderBy(keys []sortKey) string {
+	clauses := make([]string, len(keys))
+	for i, key := range keys {
+		clauses[i] = key.expr + " ASC"
+		if key.desc {
+			clauses[i] = key.expr + " DESC"
+		}
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// The sortKeyColumn() function returns a column expression which selects the sort key
+// of each row as a text array, in the form a Cursor holds it. Each expression is put in
+// parentheses before the cast, since a cast binds more tightly than any operator in it.
+func sortKeyColumn(keys []sortKey) string {
+	exprs := make([]string, len(keys))
+	for i, key := range keys {
+		exprs[i] = "(" + key.expr + ")::text"
+	}
+	return "ARRAY[" + strings.Join(exprs, ", ") + "]"
+}
+
+// The pageMetadata() function works out the metadata for a page of rows, which were
+// fetched with one extra row to find out whether there's another page. It is given the
+// sort key of each row, in the order the rows were fetched, and returns the number of
+// rows to keep. Rows fetched before a cursor come in reverse, and the caller must put
+// them back in order.
+func pageMetadata(f Filters, keys [][]string, totalRecords int) (Metadata, int) {
+	more := len(keys) > f.PageSize
+	if more {
+		keys = keys[:f.PageSize]
+	}
+	var metadata Metadata
+	hasNext, hasPrev := more, f.Page > 1
+	switch {
+	case f.Cursor == nil && f.SkipTotal:
+		metadata = Metadata{CurrentPage: f.Page, PageSize: f.PageSize}
+	case f.Cursor == nil:
+		metadata = calculateMetadata(totalRecords, f.Page, f.PageSize)
+	case f.Cursor.Before:
+		metadata = Metadata{PageSize: f.PageSize, TotalRecords: totalRecords}
+		hasNext, hasPrev = true, more
+	default:
+		metadata = Metadata{PageSize: f.PageSize, TotalRecords: totalRecords}
+		hasPrev = true
+	}
+	if len(keys) == 0 {
+		return metadata, 0
+	}
+	first, last := keys[0], keys[len(keys)-1]
+	if f.Cursor != nil && f.Cursor.Before {
+		first, last = last, first
+	}
+	if hasNext {
+		metadata.NextCursor = Cursor{Sort: f.Sort, Key: last}.String()
+	}
+	if hasPrev {
+		metadata.PrevCursor = Cursor{Sort: f.Sort, Key: first, Before: true}.String()
+	}
+	return metadata, len(keys)
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -47,8 +201,21 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	// Check that the sort parameter matches a value in the safelist, or for lists
+	// which allow it, that each of the columns it lists does and that none of them is
+	// repeated.
+	fields := f.sortFields()
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		v.Check(validator.In(field, f.SortSafelist...), "sort", "invalid sort value")
+		columns[i] = strings.TrimPrefix(field, "-")
+	}
+	v.Check(validator.Unique(columns), "sort", "must not sort by the same column twice")
+	v.Check(len(fields) <= maxSortColumns, "sort", fmt.Sprintf("must not list more than %d columns", maxSortColumns))
+	if f.Cursor != nil {
+		v.Check(f.Page == 1, "page", "must not be used with a cursor")
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "must be used with the sort it was returned for")
+	}
 }
 
 type Metadata struct {
@@ -57,6 +224,10 @@ type Metadata struct {
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+	// The cursors are set for lists which can be paged with a cursor, and are empty on
+	// the last and first pages respectively.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -77,3 +248,182 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// A filterBuilder puts together the WHERE clause of a list query from optional
+// conditions, numbering the placeholders for their arguments as it goes. Each method
+// leaves its condition out when given a zero value, so that the query only contains
+// the filters the client asked for and Postgres can pick the indexes to suit them.
+type filterBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// The arg() method adds an argument to the query and returns its placeholder.
+func (b *filterBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// The add() method adds a condition, replacing each %s in the format with the
+// placeholder for the matching value.
+func (b *filterBuilder) add(format string, values ...interface{}) {
+	placeholders := make([]interface{}, len(values))
+	for i, value := range values {
+		placeholders[i] = b.arg(value)
+	}
+	b.conditions = append(b.conditions, fmt.Sprintf(format, placeholders...))
+}
+
+// The equal() method matches rows where expr is exactly the value.
+func (b *filterBuilder) equal(expr string, value string) {
+	if value != "" {
+		b.add(expr+" = %s", value)
+	}
+}
+
+// A textQuery is a search added to a filterBuilder, which can also be used to rank the
+// matching rows and to highlight the words which matched.
+type textQuery struct {
+	expr    string
+	tsquery string
+	text    string
+}
+
+// The search() method matches rows where the text in expr contains the words of the
+// query, with the last word matched as a prefix so that results come up while the
+// client is still typing it. Failing that, rows where a word in expr is similar to the
+// query by trigram are matched too, so that a misspelling still finds something. It
+// returns nil if the query is empty.
+func (b *filterBuilder) search(expr string, query string) *textQuery {
+	if strings.TrimSpace(query) == "" {
+		return nil
+	}
+	q := &textQuery{expr: expr, tsquery: b.arg(prefixQuery(query)), text: b.arg(strings.ToLower(query))}
+	b.conditions = append(b.conditions, q.matches(q.expr))
+	return q
+}
+
+// The matches() method returns a condition which is true if the text in expr matches
+// the query, in the same way as the condition added by search(). It can be used to
+// narrow down the rows before the text searched is worked out, and is TRUE if the query
+// is empty.
+func (q *textQuery) matches(expr string) string {
+	if q == nil {
+		return "TRUE"
+	}
+	return fmt.Sprintf("(to_tsvector('simple', %[1]s) @@ to_tsquery('simple', %[2]s) OR %[3]s <%% lower(%[1]s))", expr, q.tsquery, q.text)
+}
+
+// The rank() method returns an expression scoring how well each row matches the
+// query, higher being better. Word matches score on top of trigram similarity, so that
+// they come before near misses.
+func (q *textQuery) rank() string {
+	if q == nil {
+		return "0"
+	}
+	return fmt.Sprintf("(ts_rank(to_tsvector('simple', %[1]s), to_tsquery('simple', %[2]s)) + word_similarity(%[3]s, lower(%[1]s)))", q.expr, q.tsquery, q.text)
+}
+
+// The headline() method returns an expression for the text in expr with the words
+// matching the query wrapped in <mark> tags. Rows which only matched by trigram come
+// back without any marks.
+func (q *textQuery) headline() string {
+	if q == nil {
+		return "''"
+	}
+	return fmt.Sprintf("ts_headline('simple', %s, to_tsquery('simple', %s), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')", q.expr, q.tsquery)
+}
+
+// The prefixQuery() function turns what the client typed into a tsquery which matches
+// all of its words, treating the last one as a prefix. Anything other than letters and
+// digits is dropped, which keeps the tsquery syntax out of the client's hands.
+func prefixQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+	words[len(words)-1] += ":*"
+	return strings.Join(words, " & ")
+}
+
+// The between() method matches rows where expr falls within the inclusive range. A
+// bound of zero is left open.
+func (b *filterBuilder) between(expr string, min, max int64) {
+	if min != 0 {
+		b.add(expr+" >= %s", min)
+	}
+	if max != 0 {
+		b.add(expr+" <= %s", max)
+	}
+}
+
+// The since() method matches rows where the timestamp in expr is at or after t.
+func (b *filterBuilder) since(expr string, t time.Time) {
+	if !t.IsZero() {
+		b.add(expr+" >= %s", t)
+	}
+}
+
+// The arrayMatch() method matches rows where the array in expr contains all of the
+// values, or any of them if all is false. Both operators can use a GIN index.
+func (b *filterBuilder) arrayMatch(expr string, values []string, all bool) {
+	if len(values) == 0 {
+		return
+	}
+	op := "&&"
+	if all {
+		op = "@>"
+	}
+	b.add(expr+" "+op+" %s", pq.Array(values))
+}
+
+// The keyset() method matches the rows which come after the cursor in the order of
+// the keys. When the keys are all sorted in the same direction, this is a row
+// comparison, which Postgres can answer by seeking in an index on the keys. Otherwise
+// the condition is spelled out key by key. The text values are cast to the type of
+// each key by Postgres.
+func (b *filterBuilder) keyset(keys []sortKey, cursor *Cursor) {
+	if cursor == nil {
+		return
+	}
+	placeholders := make([]string, len(keys))
+	exprs := make([]string, len(keys))
+	sameDirection := true
+	for i, key := range keys {
+		placeholders[i] = b.arg(cursor.Key[i])
+		exprs[i] = key.expr
+		sameDirection = sameDirection && key.desc == keys[0].desc
+	}
+	if sameDirection {
+		op := ">"
+		if keys[0].desc {
+			op = "<"
+		}
+		b.conditions = append(b.conditions, fmt.Sprintf("(%s) %s (%s)", strings.Join(exprs, ", "), op, strings.Join(placeholders, ", ")))
+		return
+	}
+	var clauses []string
+	for i, key := range keys {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, keys[j].expr+" = "+placeholders[j])
+		}
+		op := ">"
+		if key.desc {
+			op = "<"
+		}
+		terms = append(terms, key.expr+" "+op+" "+placeholders[i])
+		clauses = append(clauses, "("+strings.Join(terms, " AND ")+")")
+	}
+	b.conditions = append(b.conditions, "("+strings.Join(clauses, " OR ")+")")
+}
+
+// The where() method returns the conditions joined with AND, without the keyword.
+func (b *filterBuilder) where() string {
+	if len(b.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(b.conditions, "\n\t\tAND ")
+}
@@ -0,0 +1,267 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A PublicUser holds the details of a user which other users are allowed to see.
+type PublicUser struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// An ArtistFollow is an artist followed by a user. Artists are matched against song
+// credits without regard to case, and Name is the spelling used in the credits.
+type ArtistFollow struct {
+	Name       string    `json:"name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// A UserFollow is the other side of a follow between two users: the followed user in a
+// following list, or the follower in a followers list.
+type UserFollow struct {
+	FollowedAt time.Time  `json:"followed_at"`
+	User       PublicUser `json:"user"`
+}
+
+type FollowModel struct {
+	DB *sql.DB
+}
+
+// The FollowArtist() method makes the user follow the artist with the given name,
+// returning ErrRecordNotFound if nobody of that name is credited as the artist of any
+// song. Following an artist twice is harmless.
+func (m FollowModel) FollowArtist(userID int64, name string) (*ArtistFollow, error) {
+	query := `
+WITH artist AS (
+    SELECT credit->>'name' AS name
+    FROM songs, jsonb_array_elements(songs.credits) AS credit
+    WHERE song_artists(songs.credits) @> ARRAY[lower($2)]
+    AND credit->>'role' = 'artist' AND lower(credit->>'name') = lower($2)
+    AND songs.deleted_at IS NULL
+    ORDER BY songs.id
+    LIMIT 1
+)
+INSERT INTO artist_follows (user_id, artist, name)
+SELECT $1, lower(name), name FROM artist
+ON CONFLICT (user_id, artist) DO UPDATE SET name = artist_follows.name
+RETURNING name, created_at`
+	var follow ArtistFollow
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID, name).Scan(&follow.Name, &follow.FollowedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &follow, nil
+}
+
+func (m FollowModel) UnfollowArtist(userID int64, name string) error {
+	query := `
+DELETE FROM artist_follows
+WHERE user_id = $1 AND artist = lower($2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetArtists() method returns a page of the artists followed by the user, sorted by
+// followed_at or name.
+func (m FollowModel) GetArtists(userID int64, filters Filters) ([]*ArtistFollow, Metadata, error) {
+	columns := map[string]string{
+		"followed_at": "created_at",
+		"name":        "artist",
+	}
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), name, created_at
+FROM artist_follows
+WHERE user_id = $1
+ORDER BY %s %s, artist ASC
+LIMIT $2 OFFSET $3`, columns[filters.sortColumn()], filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	follows := []*ArtistFollow{}
+	for rows.Next() {
+		var follow ArtistFollow
+		err := rows.Scan(&totalRecords, &follow.Name, &follow.FollowedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		follows = append(follows, &follow)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return follows, metadata, nil
+}
+
+// The FollowUser() method makes one user follow another, returning ErrRecordNotFound
+// if the followee doesn't exist or hasn't activated their account yet. Following a
+// user twice is harmless.
+func (m FollowModel) FollowUser(followerID, followeeID int64) error {
+	query := `
+WITH followee AS (
+    SELECT id FROM users WHERE id = $2 AND activated
+), inserted AS (
+    INSERT INTO user_follows (follower_id, followee_id)
+    SELECT $1, id FROM followee
+    ON CONFLICT (follower_id, followee_id) DO NOTHING
+)
+SELECT EXISTS (SELECT 1 FROM followee)`
+	var exists bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, followerID, followeeID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m FollowModel) UnfollowUser(followerID, followeeID int64) error {
+	query := `
+DELETE FROM user_follows
+WHERE follower_id = $1 AND followee_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetFollowing() method returns a page of the users followed by the user.
+func (m FollowModel) GetFollowing(userID int64, filters Filters) ([]*UserFollow, Metadata, error) {
+	return m.getUserFollows("follower_id", "followee_id", userID, filters)
+}
+
+// The GetFollowers() method returns a page of the users following the user.
+func (m FollowModel) GetFollowers(userID int64, filters Filters) ([]*UserFollow, Metadata, error) {
+	return m.getUserFollows("followee_id", "follower_id", userID, filters)
+}
+
+// The getUserFollows() method lists the follows where column matches the user, along
+// with the user at the other end, sorted by followed_at or name.
+func (m FollowModel) getUserFollows(column, other string, userID int64, filters Filters) ([]*UserFollow, Metadata, error) {
+	columns := map[string]string{
+		"followed_at": "user_follows.created_at",
+		"name":        "users.name",
+	}
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), user_follows.created_at, users.id, users.name
+FROM user_follows
+INNER JOIN users ON users.id = user_follows.%s
+WHERE user_follows.%s = $1
+ORDER BY %s %s, users.id ASC
+LIMIT $2 OFFSET $3`, other, column, columns[filters.sortColumn()], filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	follows := []*UserFollow{}
+	for rows.Next() {
+		var follow UserFollow
+		err := rows.Scan(&totalRecords, &follow.FollowedAt, &follow.User.ID, &follow.User.Name)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		follows = append(follows, &follow)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return follows, metadata, nil
+}
+
+// PrivacySettings control what other users can see. A user who hides their activity
+// still appears in following and followers lists, but their likes and saved songs are
+// left out of their followers' feeds.
+type PrivacySettings struct {
+	UserID       int64 `json:"-"`
+	HideActivity bool  `json:"hide_activity"`
+	Version      int32 `json:"version"`
+}
+
+// The GetPrivacySettings() method returns the stored settings for a user, falling back
+// to the defaults (with a version of 0) if the user has never saved any.
+func (m FollowModel) GetPrivacySettings(userID int64) (*PrivacySettings, error) {
+	query := `
+SELECT hide_activity, version
+FROM privacy_settings
+WHERE user_id = $1`
+	settings := PrivacySettings{UserID: userID}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&settings.HideActivity, &settings.Version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// The UpdatePrivacySettings() method saves a user's settings, using the version number
+// for optimistic locking in the same way as NotificationModel.UpdatePreferences().
+func (m FollowModel) UpdatePrivacySettings(settings *PrivacySettings) error {
+	query := `
+INSERT INTO privacy_settings AS ps (user_id, hide_activity)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET hide_activity = EXCLUDED.hide_activity, version = ps.version + 1
+WHERE ps.version = $3
+RETURNING version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, settings.UserID, settings.HideActivity, settings.Version).Scan(&settings.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
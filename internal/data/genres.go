@@ -0,0 +1,381 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"nurgazinovd_golang_lg/internal/validator"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrDuplicateGenreName = errors.New("duplicate genre name")
+	ErrInvalidGenreParent = errors.New("invalid genre parent")
+)
+
+// A Genre is an entry in the genre taxonomy. Songs store the canonical Name, and the
+// Aliases are other names which are turned into it when songs are written. A genre
+// with a ParentID is a subgenre of that genre.
+type Genre struct {
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	ParentID int64    `json:"parent_id,omitempty"`
+	Aliases  []string `json:"aliases"`
+	Version  int32    `json:"version"`
+}
+
+// The genreKey() function returns the form genre names are compared in, matching the
+// genre_key() function in the database: lower-cased, with anything other than
+// letters and digits removed.
+func genreKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(genre.Name == "" || genreKey(genre.Name) != "", "name", "must contain a letter or digit")
+	v.Check(genre.ParentID >= 0, "parent_id", "must not be negative")
+	v.Check(genre.ParentID == 0 || genre.ParentID != genre.ID, "parent_id", "must not be the genre itself")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+	keys := []string{genreKey(genre.Name)}
+	for _, alias := range genre.Aliases {
+		v.Check(genreKey(alias) != "", "aliases", "must each contain a letter or digit")
+		v.Check(len(alias) <= 50, "aliases", "must each not be more than 50 bytes long")
+		keys = append(keys, genreKey(alias))
+	}
+	v.Check(validator.Unique(keys), "aliases", "must be different from each other and from the name")
+}
+
+// genreColumns lists the columns read by the queries which return whole genres.
+const genreColumns = `genres.id, genres.name, COALESCE(genres.parent_id, 0),
+	ARRAY(SELECT genre_names.name FROM genre_names
+		WHERE genre_names.genre_id = genres.id AND genre_names.key <> genre_key(genres.name)
+		ORDER BY genre_names.name),
+	genres.version`
+
+func scanGenre(row rowScanner) (*Genre, error) {
+	var genre Genre
+	err := row.Scan(&genre.ID, &genre.Name, &genre.ParentID, pq.Array(&genre.Aliases), &genre.Version)
+	if err != nil {
+		return nil, err
+	}
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+	return &genre, nil
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// The GetAll() method returns the whole taxonomy in alphabetical order. It is small
+// enough to send in one go, and clients can build the tree from the parent IDs.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+SELECT ` + genreColumns + `
+FROM genres
+ORDER BY genres.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	genres := []*Genre{}
+	for rows.Next() {
+		genre, err := scanGenre(rows)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT ` + genreColumns + `
+FROM genres
+WHERE genres.id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	genre, err := scanGenre(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return genre, nil
+}
+
+// The Canonicalize() method maps each of the names to the canonical name of the genre
+// it spells or is an alias of, keeping their order and dropping duplicates. Names which
+// don't belong to any genre are returned separately.
+func (m GenreModel) Canonicalize(names []string) ([]string, []string, error) {
+	query := `
+SELECT given.name, COALESCE(genres.name, '')
+FROM unnest($1::text[]) WITH ORDINALITY AS given(name, i)
+LEFT JOIN genre_names ON genre_names.key = genre_key(given.name)
+LEFT JOIN genres ON genres.id = genre_names.genre_id
+ORDER BY given.i`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(names))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	canonical, unknown := []string{}, []string{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var given, name string
+		err := rows.Scan(&given, &name)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case name == "":
+			unknown = append(unknown, given)
+		case !seen[name]:
+			seen[name] = true
+			canonical = append(canonical, name)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return canonical, unknown, nil
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = checkGenreParent(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+	query := `
+INSERT INTO genres (name, parent_id)
+VALUES ($1, NULLIF($2, 0))
+RETURNING id, version`
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.ParentID).Scan(&genre.ID, &genre.Version)
+	if err != nil {
+		return err
+	}
+	err = insertGenreNames(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The Update() method saves changes to a genre. If it has been renamed from oldName,
+// the old name is kept as an alias, and the songs and followed genres which use it are
+// rewritten.
+func (m GenreModel) Update(genre *Genre, oldName string) error {
+	if genre.Name != oldName {
+		keys := map[string]bool{genreKey(genre.Name): true}
+		for _, alias := range genre.Aliases {
+			keys[genreKey(alias)] = true
+		}
+		if !keys[genreKey(oldName)] {
+			genre.Aliases = append(genre.Aliases, oldName)
+		}
+	}
+	// Rewriting the songs can take a while for a popular genre.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = checkGenreParent(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+	query := `
+UPDATE genres
+SET name = $1, parent_id = NULLIF($2, 0), version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.ParentID, genre.ID, genre.Version).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM genre_names WHERE genre_id = $1`, genre.ID)
+	if err != nil {
+		return err
+	}
+	err = insertGenreNames(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+	if genre.Name != oldName {
+		_, err = rewriteGenre(ctx, tx, oldName)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// The Merge() method folds the source genre into the target. The source's names and
+// aliases become aliases of the target, its subgenres move under the target, and the
+// songs and followed genres which use it are rewritten. It returns the number of songs
+// rewritten, and ErrInvalidGenreParent if the target is a subgenre of the source.
+func (m GenreModel) Merge(sourceID, targetID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// Lock both genres so that neither can be edited while the songs are rewritten.
+	var sourceName string
+	var found int
+	query := `
+SELECT count(*), COALESCE(min(name) FILTER (WHERE id = $1), '')
+FROM (SELECT id, name FROM genres WHERE id IN ($1, $2) FOR UPDATE) AS locked`
+	err = tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&found, &sourceName)
+	if err != nil {
+		return 0, err
+	}
+	if found != 2 {
+		return 0, ErrRecordNotFound
+	}
+	var cycle bool
+	query = `
+WITH RECURSIVE descendants AS (
+    SELECT id FROM genres WHERE id = $1
+    UNION
+    SELECT genres.id FROM genres JOIN descendants ON genres.parent_id = descendants.id
+)
+SELECT EXISTS (SELECT 1 FROM descendants WHERE id = $2)`
+	err = tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&cycle)
+	if err != nil {
+		return 0, err
+	}
+	if cycle {
+		return 0, ErrInvalidGenreParent
+	}
+	statements := []string{
+		`UPDATE genre_names SET genre_id = $2 WHERE genre_id = $1`,
+		`UPDATE genres SET parent_id = $2 WHERE parent_id = $1`,
+		`UPDATE genres SET version = version + 1 WHERE id = $2`,
+		`DELETE FROM genres WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement, sourceID, targetID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	rewritten, err := rewriteGenre(ctx, tx, sourceName)
+	if err != nil {
+		return 0, err
+	}
+	return rewritten, tx.Commit()
+}
+
+// The checkGenreParent() function returns ErrInvalidGenreParent if the genre's parent
+// doesn't exist, or if it is the genre itself or one of its subgenres.
+func checkGenreParent(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	if genre.ParentID == 0 {
+		return nil
+	}
+	query := `
+WITH RECURSIVE ancestors AS (
+    SELECT id, parent_id FROM genres WHERE id = $1
+    UNION
+    SELECT genres.id, genres.parent_id FROM genres JOIN ancestors ON genres.id = ancestors.parent_id
+)
+SELECT EXISTS (SELECT 1 FROM ancestors), EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+	var exists, cycle bool
+	err := tx.QueryRowContext(ctx, query, genre.ParentID, genre.ID).Scan(&exists, &cycle)
+	if err != nil {
+		return err
+	}
+	if !exists || cycle {
+		return ErrInvalidGenreParent
+	}
+	return nil
+}
+
+// The insertGenreNames() function records the genre's name and aliases, returning
+// ErrDuplicateGenreName if another genre already goes by one of them.
+func insertGenreNames(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	query := `
+INSERT INTO genre_names (key, name, genre_id)
+SELECT genre_key(name), name, $1
+FROM unnest($2::text[]) AS name`
+	names := append([]string{genre.Name}, genre.Aliases...)
+	_, err := tx.ExecContext(ctx, query, genre.ID, pq.Array(names))
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genre_names_pkey"`:
+			return ErrDuplicateGenreName
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The rewriteGenre() function brings the songs and followed genres which use a genre
+// name that is no longer canonical into line, returning the number of songs changed.
+// The songs get a new version, so that edits made against the old genres conflict,
+// which is recorded in their history as a change made by the system.
+func rewriteGenre(ctx context.Context, tx *sql.Tx, name string) (int64, error) {
+	query := `
+WITH changed AS (
+    UPDATE songs SET genres = canonical_genres(genres), version = version + 1
+    WHERE genres @> ARRAY[$1::text]
+    RETURNING id, version, song_snapshot(songs) AS snapshot
+), ` + recordSongRevisions("$2") + `
+SELECT count(*) FROM changed`
+	var rewritten int64
+	err := tx.QueryRowContext(ctx, query, name, 0).Scan(&rewritten)
+	if err != nil {
+		return 0, err
+	}
+	query = `
+UPDATE notification_preferences SET followed_genres = canonical_genres(followed_genres), version = version + 1
+WHERE followed_genres @> ARRAY[$1::text]`
+	_, err = tx.ExecContext(ctx, query, name)
+	if err != nil {
+		return 0, err
+	}
+	return rewritten, nil
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// The lists which make up a user's library: the songs they have saved, liked and
+// rated.
+const (
+	LibrarySongs   = "songs"
+	LibraryLikes   = "likes"
+	LibraryRatings = "ratings"
+)
+
+// libraryTables maps each library list to the table holding it.
+var libraryTables = map[string]string{
+	LibrarySongs:   "library_songs",
+	LibraryLikes:   "song_likes",
+	LibraryRatings: "song_ratings",
+}
+
+// A LibraryEntry is a song in one of a user's library lists. Rating is only set for
+// the ratings list.
+type LibraryEntry struct {
+	AddedAt time.Time `json:"added_at"`
+	Rating  int32     `json:"rating,omitempty"`
+	Song    *Song     `json:"song"`
+}
+
+func ValidateRating(v *validator.Validator, rating int32) {
+	v.Check(rating >= 1 && rating <= 5, "rating", "must be between 1 and 5")
+}
+
+type LibraryModel struct {
+	DB *sql.DB
+}
+
+// The Add() method adds a song to the user's saved songs or likes. Adding a song which
+// is already there does nothing.
+func (m LibraryModel) Add(list string, userID, songID int64) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (user_id, song_id)
+VALUES ($1, $2)
+ON CONFLICT (user_id, song_id) DO NOTHING`, libraryTables[list])
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, songID)
+	return err
+}
+
+// The Rate() method sets the user's rating of a song, replacing any earlier rating.
+func (m LibraryModel) Rate(userID, songID int64, rating int32) error {
+	query := `
+INSERT INTO song_ratings (user_id, song_id, rating)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, song_id) DO UPDATE
+SET rating = EXCLUDED.rating, created_at = NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, songID, rating)
+	return err
+}
+
+// The GetRating() method returns the user's rating of a song, or 0 if they haven't
+// rated it.
+func (m LibraryModel) GetRating(userID, songID int64) (int32, error) {
+	query := `
+SELECT rating
+FROM song_ratings
+WHERE user_id = $1 AND song_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var rating int32
+	err := m.DB.QueryRowContext(ctx, query, userID, songID).Scan(&rating)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	return rating, nil
+}
+
+// The Remove() method removes a song from one of the user's library lists.
+func (m LibraryModel) Remove(list string, userID, songID int64) error {
+	query := fmt.Sprintf(`
+DELETE FROM %s
+WHERE user_id = $1 AND song_id = $2`, libraryTables[list])
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, songID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAll() method returns a page of one of the user's library lists. It can be
+// sorted by added_at or title, and the ratings list by rating as well.
+func (m LibraryModel) GetAll(list string, userID int64, filters Filters) ([]*LibraryEntry, Metadata, error) {
+	rating := "0"
+	if list == LibraryRatings {
+		rating = "entries.rating"
+	}
+	columns := map[string]string{
+		"added_at": "entries.created_at",
+		"title":    "songs.title",
+		"rating":   rating,
+	}
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), entries.created_at, %s, `+songColumns+`
+FROM %s AS entries
+INNER JOIN songs ON songs.id = entries.song_id
+`+songStatsJoin+`
+WHERE entries.user_id = $1 AND songs.deleted_at IS NULL
+ORDER BY %s %s, songs.id ASC
+LIMIT $2 OFFSET $3`, rating, libraryTables[list], columns[filters.sortColumn()], filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	entries := []*LibraryEntry{}
+	for rows.Next() {
+		var entry LibraryEntry
+		entry.Song, err = scanSong(rows, &totalRecords, &entry.AddedAt, &entry.Rating)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.Song.UserRating = entry.Rating
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
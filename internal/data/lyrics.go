@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"nurgazinovd_golang_lg/internal/validator"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LanguageRX matches a BCP 47 language tag such as "en" or "pt-br". Tags are stored in
+// lower case.
+var LanguageRX = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// A LyricLine is one line of synced lyrics. Time is the offset from the start of the
+// song in milliseconds.
+type LyricLine struct {
+	Time int64  `json:"time_ms"`
+	Text string `json:"text"`
+}
+
+// Lyrics holds one language variant of a song's lyrics. Plain lyrics only have Text;
+// synced lyrics have Lines as well, and their Text is the lines joined together so
+// that it can be searched in the same way.
+type Lyrics struct {
+	ID        int64       `json:"-"`
+	SongID    int64       `json:"song_id"`
+	Language  string      `json:"language"`
+	Synced    bool        `json:"synced"`
+	Text      string      `json:"text"`
+	Lines     []LyricLine `json:"lines,omitempty"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Version   int32       `json:"version"`
+}
+
+// The SetLines() method replaces the synced lines, keeping Text in step with them.
+func (l *Lyrics) SetLines(lines []LyricLine) {
+	l.Synced = true
+	l.Lines = lines
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	l.Text = strings.Join(texts, "\n")
+}
+
+// ValidateLyrics checks the lyrics against the song they belong to. The timestamps of
+// synced lyrics must never go backwards and must fall within the song's duration.
+func ValidateLyrics(v *validator.Validator, lyrics *Lyrics, song *Song) {
+	v.Check(validator.Matches(lyrics.Language, LanguageRX), "language", "must be a valid language tag")
+	v.Check(strings.TrimSpace(lyrics.Text) != "", "text", "must be provided")
+	v.Check(len(lyrics.Text) <= 100_000, "text", "must not be more than 100000 bytes long")
+	if !lyrics.Synced {
+		return
+	}
+	v.Check(len(lyrics.Lines) <= 5000, "lines", "must not contain more than 5000 lines")
+	limit := int64(song.Duration) * 1000
+	for i, line := range lyrics.Lines {
+		if line.Time < 0 {
+			v.AddError("lines", "timestamps must not be negative")
+			return
+		}
+		if i > 0 && line.Time < lyrics.Lines[i-1].Time {
+			v.AddError("lines", "timestamps must be in ascending order")
+			return
+		}
+		if line.Time > limit {
+			v.AddError("lines", "timestamps must not exceed the song's duration")
+			return
+		}
+	}
+}
+
+type LyricsModel struct {
+	DB *sql.DB
+}
+
+func scanLyrics(row rowScanner) (*Lyrics, error) {
+	var lyrics Lyrics
+	var lines []byte
+	err := row.Scan(
+		&lyrics.ID,
+		&lyrics.SongID,
+		&lyrics.Language,
+		&lyrics.Synced,
+		&lyrics.Text,
+		&lines,
+		&lyrics.UpdatedAt,
+		&lyrics.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lyrics.Synced {
+		err = json.Unmarshal(lines, &lyrics.Lines)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &lyrics, nil
+}
+
+// The GetAllForSong() method returns every language variant of a song's lyrics,
+// ordered by language.
+func (m LyricsModel) GetAllForSong(songID int64) ([]*Lyrics, error) {
+	query := `
+SELECT id, song_id, language, synced, text, lines, updated_at, version
+FROM lyrics
+WHERE song_id = $1
+ORDER BY language`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []*Lyrics{}
+	for rows.Next() {
+		lyrics, err := scanLyrics(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, lyrics)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (m LyricsModel) Get(songID int64, language string) (*Lyrics, error) {
+	query := `
+SELECT id, song_id, language, synced, text, lines, updated_at, version
+FROM lyrics
+WHERE song_id = $1 AND language = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	lyrics, err := scanLyrics(m.DB.QueryRowContext(ctx, query, songID, language))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return lyrics, nil
+}
+
+// The Upsert() method creates or replaces the lyrics for a song in one language. When
+// replacing, the version number of the existing row must match, so a client uploading
+// lyrics it hasn't seen gets a version of 0 and only succeeds if there were none.
+func (m LyricsModel) Upsert(lyrics *Lyrics) error {
+	lines := []byte("[]")
+	if lyrics.Synced {
+		var err error
+		lines, err = json.Marshal(lyrics.Lines)
+		if err != nil {
+			return err
+		}
+	}
+	query := `
+INSERT INTO lyrics (song_id, language, synced, text, lines)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (song_id, language) DO UPDATE
+SET synced = EXCLUDED.synced, text = EXCLUDED.text, lines = EXCLUDED.lines,
+    updated_at = NOW(), version = lyrics.version + 1
+WHERE lyrics.version = $6
+RETURNING id, updated_at, version`
+	args := []interface{}{lyrics.SongID, lyrics.Language, lyrics.Synced, lyrics.Text, lines, lyrics.Version}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&lyrics.ID, &lyrics.UpdatedAt, &lyrics.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m LyricsModel) Delete(songID int64, language string) error {
+	query := `
+DELETE FROM lyrics
+WHERE song_id = $1 AND language = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, songID, language)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -11,17 +11,47 @@ var (
 )
 
 type Models struct {
-	Songs       SongModel
-	Permissions PermissionModel // Add a new Permissions field.
-	Tokens      TokenModel
-	Users       UserModel
+	Songs           SongModel
+	Albums          AlbumModel
+	AudioUploads    AudioUploadModel
+	Charts          ChartModel
+	Comments        CommentModel
+	Feed            FeedModel
+	Follows         FollowModel
+	Genres          GenreModel
+	Library         LibraryModel
+	Lyrics          LyricsModel
+	Notifications   NotificationModel
+	Permissions     PermissionModel // Add a new Permissions field.
+	Plays           PlayModel
+	Recommendations RecommendationModel
+	Revocations     RevocationModel
+	Scrobbles       ScrobbleModel
+	Search          SearchModel
+	Tokens          TokenModel
+	Users           UserModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Songs:       SongModel{DB: db},
-		Permissions: PermissionModel{DB: db}, // Initialize a new PermissionModel instance.
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Songs:           SongModel{DB: db},
+		Albums:          AlbumModel{DB: db},
+		AudioUploads:    AudioUploadModel{DB: db},
+		Charts:          ChartModel{DB: db},
+		Comments:        CommentModel{DB: db},
+		Feed:            FeedModel{DB: db},
+		Follows:         FollowModel{DB: db},
+		Genres:          GenreModel{DB: db},
+		Library:         LibraryModel{DB: db},
+		Lyrics:          LyricsModel{DB: db},
+		Notifications:   NotificationModel{DB: db},
+		Permissions:     PermissionModel{DB: db}, // Initialize a new PermissionModel instance.
+		Plays:           PlayModel{DB: db},
+		Recommendations: RecommendationModel{DB: db},
+		Revocations:     RevocationModel{DB: db},
+		Scrobbles:       ScrobbleModel{DB: db},
+		Search:          SearchModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Users:           UserModel{DB: db},
 	}
 }
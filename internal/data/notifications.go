@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// Define constants for the kinds of notification we send. Security alerts are always
+// delivered immediately and cannot be switched off by the user.
+const (
+	NotificationNewRelease     = "new_release"
+	NotificationPlaylistInvite = "playlist_invite"
+	NotificationSecurityAlert  = "security_alert"
+)
+
+// Define constants for the supported delivery modes.
+const (
+	DeliveryImmediate = "immediate"
+	DeliveryWeekly    = "weekly"
+)
+
+// DigestInterval is the minimum time between two digest emails for the same user.
+const DigestInterval = 7 * 24 * time.Hour
+
+type NotificationPreferences struct {
+	UserID          int64    `json:"-"`
+	NewReleases     bool     `json:"new_releases"`
+	PlaylistInvites bool     `json:"playlist_invites"`
+	SecurityAlerts  bool     `json:"security_alerts"`
+	FollowedGenres  []string `json:"followed_genres"`
+	Delivery        string   `json:"delivery"`
+	Version         int32    `json:"version"`
+}
+
+// The defaultNotificationPreferences() function returns the preferences used for a
+// user who has never saved any of their own.
+func defaultNotificationPreferences(userID int64) *NotificationPreferences {
+	return &NotificationPreferences{
+		UserID:          userID,
+		NewReleases:     true,
+		PlaylistInvites: true,
+		SecurityAlerts:  true,
+		FollowedGenres:  []string{},
+		Delivery:        DeliveryImmediate,
+	}
+}
+
+func ValidateNotificationPreferences(v *validator.Validator, prefs *NotificationPreferences) {
+	v.Check(prefs.SecurityAlerts, "security_alerts", "cannot be disabled")
+	v.Check(validator.In(prefs.Delivery, DeliveryImmediate, DeliveryWeekly), "delivery", "must be either immediate or weekly")
+	v.Check(prefs.FollowedGenres != nil, "followed_genres", "must be provided")
+	v.Check(len(prefs.FollowedGenres) <= 20, "followed_genres", "must not contain more than 20 genres")
+	v.Check(validator.Unique(prefs.FollowedGenres), "followed_genres", "must not contain duplicate values")
+}
+
+type Notification struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+}
+
+// A Delivery groups the pending notifications for a single recipient, along with the
+// details needed to address the email.
+type Delivery struct {
+	UserID        int64
+	Name          string
+	Email         string
+	Notifications []*Notification
+}
+
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// The GetPreferences() method returns the stored preferences for a user, falling back
+// to the defaults (with a version of 0) if the user has never saved any.
+func (m NotificationModel) GetPreferences(userID int64) (*NotificationPreferences, error) {
+	query := `
+SELECT user_id, new_releases, playlist_invites, followed_genres, delivery, version
+FROM notification_preferences
+WHERE user_id = $1`
+	prefs := NotificationPreferences{SecurityAlerts: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.UserID,
+		&prefs.NewReleases,
+		&prefs.PlaylistInvites,
+		pq.Array(&prefs.FollowedGenres),
+		&prefs.Delivery,
+		&prefs.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return defaultNotificationPreferences(userID), nil
+		default:
+			return nil, err
+		}
+	}
+	return &prefs, nil
+}
+
+// The UpdatePreferences() method creates the preferences row on first save and
+// otherwise updates it, using the version number for optimistic locking in the same
+// way as the other models.
+func (m NotificationModel) UpdatePreferences(prefs *NotificationPreferences) error {
+	query := `
+INSERT INTO notification_preferences AS np (user_id, new_releases, playlist_invites, followed_genres, delivery)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE
+SET new_releases = EXCLUDED.new_releases, playlist_invites = EXCLUDED.playlist_invites,
+    followed_genres = EXCLUDED.followed_genres, delivery = EXCLUDED.delivery, version = np.version + 1
+WHERE np.version = $6
+RETURNING version`
+	args := []interface{}{
+		prefs.UserID,
+		prefs.NewReleases,
+		prefs.PlaylistInvites,
+		pq.Array(prefs.FollowedGenres),
+		prefs.Delivery,
+		prefs.Version,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&prefs.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The Insert() method queues a single notification for a user. Notifications of an
+// optional kind are silently dropped if the user has switched that kind off.
+func (m NotificationModel) Insert(notification *Notification) error {
+	query := `
+INSERT INTO notifications (user_id, kind, message)
+SELECT $1::bigint, $2::text, $3::text
+WHERE $2 = $4
+OR NOT EXISTS (
+    SELECT 1 FROM notification_preferences
+    WHERE user_id = $1
+    AND (($2 = $5 AND NOT new_releases) OR ($2 = $6 AND NOT playlist_invites))
+)
+RETURNING id, created_at`
+	args := []interface{}{
+		notification.UserID,
+		notification.Kind,
+		notification.Message,
+		NotificationSecurityAlert,
+		NotificationNewRelease,
+		NotificationPlaylistInvite,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&notification.ID, &notification.CreatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// The InsertNewRelease() method queues a new release notification for every user who
+// follows at least one of the given genres and hasn't switched these notifications off.
+func (m NotificationModel) InsertNewRelease(message string, genres []string) error {
+	query := `
+INSERT INTO notifications (user_id, kind, message)
+SELECT user_id, $1::text, $2::text
+FROM notification_preferences
+WHERE new_releases AND followed_genres && $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, NotificationNewRelease, message, pq.Array(genres))
+	return err
+}
+
+// The GetPendingImmediate() method returns the unsent notifications that should go out
+// straight away, grouped by recipient. That is every security alert, plus everything
+// for users on immediate delivery.
+func (m NotificationModel) GetPendingImmediate() ([]*Delivery, error) {
+	query := `
+SELECT users.id, users.name, users.email, notifications.id, notifications.created_at, notifications.kind, notifications.message
+FROM notifications
+INNER JOIN users ON users.id = notifications.user_id
+LEFT JOIN notification_preferences ON notification_preferences.user_id = notifications.user_id
+WHERE notifications.sent_at IS NULL
+AND users.activated
+AND (notifications.kind = $1 OR COALESCE(notification_preferences.delivery, $2) = $2)
+ORDER BY users.id, notifications.id`
+	return m.getPending(query, NotificationSecurityAlert, DeliveryImmediate)
+}
+
+// The GetDueDigests() method returns the unsent notifications for users on weekly
+// delivery whose last digest went out at least DigestInterval ago.
+func (m NotificationModel) GetDueDigests() ([]*Delivery, error) {
+	query := `
+SELECT users.id, users.name, users.email, notifications.id, notifications.created_at, notifications.kind, notifications.message
+FROM notifications
+INNER JOIN users ON users.id = notifications.user_id
+INNER JOIN notification_preferences ON notification_preferences.user_id = notifications.user_id
+WHERE notifications.sent_at IS NULL
+AND users.activated
+AND notifications.kind <> $1
+AND notification_preferences.delivery = $2
+AND (notification_preferences.last_digest_at IS NULL OR notification_preferences.last_digest_at <= $3)
+ORDER BY users.id, notifications.id`
+	return m.getPending(query, NotificationSecurityAlert, DeliveryWeekly, time.Now().Add(-DigestInterval))
+}
+
+func (m NotificationModel) getPending(query string, args ...interface{}) ([]*Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*Delivery{}
+	var current *Delivery
+	for rows.Next() {
+		var d Delivery
+		var n Notification
+		err := rows.Scan(&d.UserID, &d.Name, &d.Email, &n.ID, &n.CreatedAt, &n.Kind, &n.Message)
+		if err != nil {
+			return nil, err
+		}
+		n.UserID = d.UserID
+		// The rows are ordered by user, so start a new delivery whenever the user
+		// changes.
+		if current == nil || current.UserID != d.UserID {
+			current = &d
+			deliveries = append(deliveries, current)
+		}
+		current.Notifications = append(current.Notifications, &n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// The MarkSent() method records that the given notifications have been delivered. If
+// digest is true, the user's last digest time is updated as well.
+func (m NotificationModel) MarkSent(delivery *Delivery, digest bool) error {
+	ids := make([]int64, len(delivery.Notifications))
+	for i, n := range delivery.Notifications {
+		ids[i] = n.ID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `UPDATE notifications SET sent_at = NOW() WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	if digest {
+		_, err = tx.ExecContext(ctx, `UPDATE notification_preferences SET last_digest_at = NOW() WHERE user_id = $1`, delivery.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"nurgazinovd_golang_lg/internal/validator"
+	"time"
+)
+
+// Clients may report plays which happened while they were offline, but only up to
+// PlayMaxAge ago. PlayClockSkew allows for clients whose clocks run a little fast.
+const (
+	PlayMaxAge    = 30 * 24 * time.Hour
+	PlayClockSkew = 5 * time.Minute
+)
+
+// MaxPlayBatch is the largest number of plays which can be reported in one request.
+const MaxPlayBatch = 500
+
+// PlayClient describes the app which reported a play.
+type PlayClient struct {
+	Name     string `json:"name,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Platform string `json:"platform,omitempty"`
+}
+
+// A Play records a single listen of a song by a user.
+type Play struct {
+	ID       int64      `json:"id"`
+	UserID   int64      `json:"-"`
+	SongID   int64      `json:"song_id"`
+	Title    string     `json:"title,omitempty"`
+	PlayedAt time.Time  `json:"played_at"`
+	MsPlayed int32      `json:"ms_played"`
+	Client   PlayClient `json:"client"`
+}
+
+// ValidatePlay checks a play report against the duration of the song it refers to,
+// which is zero if the song doesn't exist. Durations are stored in whole seconds, so we
+// allow up to a second's leeway when comparing the time listened with the song.
+func ValidatePlay(v *validator.Validator, play *Play, duration Duration, now time.Time) {
+	v.Check(duration > 0, "song_id", "must refer to an existing song")
+	ValidatePlayedAt(v, play.PlayedAt, now)
+	v.Check(play.MsPlayed > 0, "ms_played", "must be greater than zero")
+	if duration > 0 {
+		v.Check(int64(play.MsPlayed) <= (int64(duration)+1)*1000, "ms_played", "must not be longer than the song")
+	}
+	v.Check(len(play.Client.Name) <= 100, "client", "must not have a name more than 100 bytes long")
+	v.Check(len(play.Client.Version) <= 50, "client", "must not have a version more than 50 bytes long")
+	v.Check(len(play.Client.Platform) <= 50, "client", "must not have a platform more than 50 bytes long")
+}
+
+// ValidatePlayedAt checks that the time of a reported play is within the window which
+// clients are allowed to report plays for.
+func ValidatePlayedAt(v *validator.Validator, playedAt time.Time, now time.Time) {
+	v.Check(!playedAt.IsZero(), "played_at", "must be provided")
+	v.Check(!playedAt.After(now.Add(PlayClockSkew)), "played_at", "must not be in the future")
+	v.Check(playedAt.After(now.Add(-PlayMaxAge)), "played_at", "must not be more than 30 days ago")
+}
+
+type PlayModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method appends a batch of plays in a single statement. Reports which
+// have already been recorded, identified by the user, song and time, are skipped so
+// that clients can safely retry a batch. It returns the number of new plays.
+func (m PlayModel) Insert(plays []*Play) (int, error) {
+	if len(plays) == 0 {
+		return 0, nil
+	}
+	// Pass the plays as one array per column and let unnest() zip them back into rows.
+	n := len(plays)
+	userIDs, songIDs, msPlayed := make([]int64, n), make([]int64, n), make([]int64, n)
+	playedAt, clientNames := make([]string, n), make([]string, n)
+	clientVersions, platforms := make([]string, n), make([]string, n)
+	for i, play := range plays {
+		userIDs[i] = play.UserID
+		songIDs[i] = play.SongID
+		playedAt[i] = play.PlayedAt.Format(time.RFC3339Nano)
+		msPlayed[i] = int64(play.MsPlayed)
+		clientNames[i] = play.Client.Name
+		clientVersions[i] = play.Client.Version
+		platforms[i] = play.Client.Platform
+	}
+	query := `
+INSERT INTO plays (user_id, song_id, played_at, ms_played, client_name, client_version, platform)
+SELECT * FROM unnest($1::bigint[], $2::bigint[], $3::timestamptz[], $4::integer[], $5::text[], $6::text[], $7::text[])
+ON CONFLICT (user_id, song_id, played_at) DO NOTHING`
+	args := []interface{}{
+		pq.Array(userIDs),
+		pq.Array(songIDs),
+		pq.Array(playedAt),
+		pq.Array(msPlayed),
+		pq.Array(clientNames),
+		pq.Array(clientVersions),
+		pq.Array(platforms),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	inserted, err := result.RowsAffected()
+	return int(inserted), err
+}
+
+// The GetAllForUser() method returns a page of the user's listening history.
+func (m PlayModel) GetAllForUser(userID int64, filters Filters) ([]*Play, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), plays.id, plays.song_id, songs.title, plays.played_at, plays.ms_played,
+    plays.client_name, plays.client_version, plays.platform
+FROM plays
+INNER JOIN songs ON songs.id = plays.song_id
+WHERE plays.user_id = $1 AND songs.deleted_at IS NULL
+ORDER BY plays.%s %s, plays.id DESC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	plays := []*Play{}
+	for rows.Next() {
+		play := Play{UserID: userID}
+		err := rows.Scan(
+			&totalRecords,
+			&play.ID,
+			&play.SongID,
+			&play.Title,
+			&play.PlayedAt,
+			&play.MsPlayed,
+			&play.Client.Name,
+			&play.Client.Version,
+			&play.Client.Platform,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		plays = append(plays, &play)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return plays, metadata, nil
+}
+
+// The CreatePartitions() method makes sure that the monthly partitions of the plays
+// table exist for the months around the given time: the two previous months, which
+// PlayMaxAge can reach back into, and the next month so that it's ready before it
+// begins.
+func (m PlayModel) CreatePartitions(now time.Time) error {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := -2; i <= 1; i++ {
+		start := month.AddDate(0, i, 0)
+		end := start.AddDate(0, 1, 0)
+		// The table name and bounds are generated here rather than supplied by a
+		// client, and DDL can't take placeholder parameters anyway.
+		query := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS plays_%s PARTITION OF plays
+FOR VALUES FROM ('%s') TO ('%s')`, start.Format("2006_01"), start.Format(time.RFC3339), end.Format(time.RFC3339))
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_, err := m.DB.ExecContext(ctx, query)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
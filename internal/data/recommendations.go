@@ -0,0 +1,329 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Settings for the job which builds song similarities and recommendations.
+const (
+	// Only plays from the last RecommendationHistory are used as listening signals,
+	// and at most MaxUserSignals of each user's most recent songs, so that a handful
+	// of very heavy listeners can't make the job quadratic.
+	RecommendationHistory = 90 * 24 * time.Hour
+	MaxUserSignals        = 500
+	// Two songs need MinCoListeners listeners in common to count as similar.
+	MinCoListeners = 2
+	// Each song keeps its MaxSimilarSongs most similar songs.
+	MaxSimilarSongs = 50
+	// Songs with fewer than ColdStartThreshold co-listening neighbours are filled in
+	// with songs which share their genres. Genre similarity is weighted down, so that
+	// it never outranks a co-listening neighbour.
+	ColdStartThreshold    = 5
+	GenreSimilarityWeight = 0.5
+	// Each cold song is only compared with MaxGenreCandidates of the most recently
+	// added songs sharing one of its genres, so that a large genre can't make the
+	// fallback quadratic.
+	MaxGenreCandidates = 1000
+	// Each user keeps their MaxRecommendations best recommendations.
+	MaxRecommendations = 100
+)
+
+// The sources of a song similarity.
+const (
+	SimilarityCoListening = "co_listening"
+	SimilarityGenre       = "genre"
+)
+
+// A Recommendation is a song recommended to a user, with the reason it was picked.
+type Recommendation struct {
+	Score         float64 `json:"score"`
+	Reason        string  `json:"reason"`
+	BecauseSongID int64   `json:"because_song_id,omitempty"`
+	Song          *Song   `json:"song"`
+}
+
+// A SimilarSong is a song which is similar to another, with the reason it was picked.
+type SimilarSong struct {
+	Score  float64 `json:"score"`
+	Source string  `json:"source"`
+	Reason string  `json:"reason"`
+	Song   *Song   `json:"song"`
+}
+
+// userSignalsQuery selects each user's recent listening signals as (user_id, song_id,
+// weight) rows, where a like weighs twice as much as a play.
+const userSignalsQuery = `
+SELECT user_id, song_id, max(weight) AS weight
+FROM (
+    SELECT user_id, song_id, 1 AS weight
+    FROM (
+        SELECT user_id, song_id, row_number() OVER (PARTITION BY user_id ORDER BY max(played_at) DESC) AS n
+        FROM plays
+        WHERE played_at > $1
+        GROUP BY user_id, song_id
+    ) AS recent
+    WHERE n <= $2
+    UNION ALL
+    SELECT user_id, song_id, 2 AS weight
+    FROM song_likes
+) AS signals
+GROUP BY user_id, song_id`
+
+type RecommendationModel struct {
+	DB *sql.DB
+}
+
+// The Build() method rebuilds the song similarities and then every user's
+// recommendations, each in a transaction so that readers see either the old results or
+// the new ones.
+func (m RecommendationModel) Build() error {
+	err := m.buildSimilarities()
+	if err != nil {
+		return err
+	}
+	return m.buildRecommendations()
+}
+
+// The buildSimilarities() method computes item-to-item similarities. Two songs are
+// similar if the same users listen to them, scored by the cosine similarity of their
+// sets of listeners. Songs without enough listeners fall back to the overlap of their
+// genres, which the GIN index on the genres column can find. Each cold song only
+// compares itself with a bounded number of candidates and keeps the best of those.
+func (m RecommendationModel) buildSimilarities() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM song_similarities`)
+	if err != nil {
+		return err
+	}
+	query := `
+WITH signals AS (` + userSignalsQuery + `
+), listeners AS (
+    SELECT song_id, count(*) AS users
+    FROM signals
+    GROUP BY song_id
+), pairs AS (
+    SELECT a.song_id, b.song_id AS similar_song_id, count(*) AS together
+    FROM signals AS a
+    INNER JOIN signals AS b ON b.user_id = a.user_id AND b.song_id <> a.song_id
+    GROUP BY a.song_id, b.song_id
+    HAVING count(*) >= $3
+), scored AS (
+    SELECT pairs.song_id, pairs.similar_song_id, pairs.together / sqrt(la.users * lb.users) AS score
+    FROM pairs
+    INNER JOIN listeners AS la ON la.song_id = pairs.song_id
+    INNER JOIN listeners AS lb ON lb.song_id = pairs.similar_song_id
+), ranked AS (
+    SELECT *, row_number() OVER (PARTITION BY song_id ORDER BY score DESC, similar_song_id) AS rank
+    FROM scored
+)
+INSERT INTO song_similarities (song_id, similar_song_id, score, source)
+SELECT song_id, similar_song_id, score, 'co_listening'
+FROM ranked
+WHERE rank <= $4`
+	args := []interface{}{time.Now().Add(-RecommendationHistory), MaxUserSignals, MinCoListeners, MaxSimilarSongs}
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	query = `
+WITH cold AS (
+    SELECT songs.id, songs.genres
+    FROM songs
+    WHERE songs.deleted_at IS NULL
+    AND (SELECT count(*) FROM song_similarities WHERE song_similarities.song_id = songs.id) < $1
+), scored AS (
+    SELECT cold.id AS song_id, best.id AS similar_song_id, best.score
+    FROM cold
+    CROSS JOIN LATERAL (
+        SELECT candidates.id,
+            cardinality(ARRAY(SELECT unnest(cold.genres) INTERSECT SELECT unnest(candidates.genres)))::float8 /
+            cardinality(ARRAY(SELECT unnest(cold.genres) UNION SELECT unnest(candidates.genres))) AS score
+        FROM (
+            SELECT other.id, other.genres
+            FROM songs AS other
+            WHERE other.genres && cold.genres AND other.id <> cold.id AND other.deleted_at IS NULL
+            ORDER BY other.id DESC
+            LIMIT $4
+        ) AS candidates
+        ORDER BY score DESC, candidates.id
+        LIMIT $3
+    ) AS best
+)
+INSERT INTO song_similarities (song_id, similar_song_id, score, source)
+SELECT song_id, similar_song_id, score * $2, 'genre'
+FROM scored
+ON CONFLICT (song_id, similar_song_id) DO NOTHING`
+	_, err = tx.ExecContext(ctx, query, ColdStartThreshold, GenreSimilarityWeight, MaxSimilarSongs, MaxGenreCandidates)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The buildRecommendations() method recommends each user the songs most similar to
+// the ones they have recently played or liked, leaving out any song they already know.
+// A candidate's score is the sum of its similarity to each of the user's songs, and the
+// song contributing the most is recorded as the reason for the recommendation.
+func (m RecommendationModel) buildRecommendations() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_recommendations`)
+	if err != nil {
+		return err
+	}
+	query := `
+WITH signals AS (` + userSignalsQuery + `
+), candidates AS (
+    SELECT signals.user_id, sim.similar_song_id AS song_id, sim.score * signals.weight AS contribution,
+        signals.song_id AS because_song_id, CASE WHEN signals.weight > 1 THEN 'liked' ELSE 'played' END AS because
+    FROM signals
+    INNER JOIN song_similarities AS sim ON sim.song_id = signals.song_id
+    WHERE NOT ` + knownSongExists("signals.user_id", "sim.similar_song_id") + `
+), totals AS (
+    SELECT DISTINCT ON (user_id, song_id) user_id, song_id,
+        sum(contribution) OVER (PARTITION BY user_id, song_id) AS score, because_song_id, because
+    FROM candidates
+    ORDER BY user_id, song_id, contribution DESC, because_song_id
+), ranked AS (
+    SELECT *, row_number() OVER (PARTITION BY user_id ORDER BY score DESC, song_id) AS rank
+    FROM totals
+)
+INSERT INTO user_recommendations (user_id, song_id, score, because_song_id, because)
+SELECT user_id, song_id, score, because_song_id, because
+FROM ranked
+WHERE rank <= $3`
+	args := []interface{}{time.Now().Add(-RecommendationHistory), MaxUserSignals, MaxRecommendations}
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// knownSongExists returns an EXISTS expression which is true if the user has ever
+// played, liked, rated or saved the song.
+func knownSongExists(userID, songID string) string {
+	return fmt.Sprintf(`EXISTS (
+        SELECT 1 FROM plays WHERE plays.user_id = %[1]s AND plays.song_id = %[2]s
+        UNION ALL SELECT 1 FROM song_likes WHERE song_likes.user_id = %[1]s AND song_likes.song_id = %[2]s
+        UNION ALL SELECT 1 FROM song_ratings WHERE song_ratings.user_id = %[1]s AND song_ratings.song_id = %[2]s
+        UNION ALL SELECT 1 FROM library_songs WHERE library_songs.user_id = %[1]s AND library_songs.song_id = %[2]s)`, userID, songID)
+}
+
+// The GetAllForUser() method returns a page of the user's cached recommendations. Songs
+// the user has come to know since the recommendations were built are left out.
+func (m RecommendationModel) GetAllForUser(userID int64, filters Filters) ([]*Recommendation, Metadata, error) {
+	query := `
+SELECT count(*) OVER(), rec.score, rec.because, COALESCE(seed.id, 0), COALESCE(seed.title, ''), ` + songColumns + `
+FROM user_recommendations AS rec
+INNER JOIN songs ON songs.id = rec.song_id
+` + songStatsJoin + `
+LEFT JOIN songs AS seed ON seed.id = rec.because_song_id AND seed.deleted_at IS NULL
+WHERE rec.user_id = $1 AND songs.deleted_at IS NULL AND NOT ` + knownSongExists("rec.user_id", "rec.song_id") + `
+ORDER BY rec.score DESC, songs.id ASC
+LIMIT $2 OFFSET $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	recommendations := []*Recommendation{}
+	for rows.Next() {
+		var rec Recommendation
+		var because, becauseTitle string
+		rec.Song, err = scanSong(rows, &totalRecords, &rec.Score, &because, &rec.BecauseSongID, &becauseTitle)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		switch {
+		case becauseTitle == "":
+			rec.Reason = "because of your listening history"
+		case because == "liked":
+			rec.Reason = fmt.Sprintf("because you liked %s", becauseTitle)
+		default:
+			rec.Reason = fmt.Sprintf("because you listened to %s", becauseTitle)
+		}
+		recommendations = append(recommendations, &rec)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return recommendations, metadata, nil
+}
+
+// The GetSimilar() method returns a page of the songs most similar to the given song.
+func (m RecommendationModel) GetSimilar(song *Song, filters Filters) ([]*SimilarSong, Metadata, error) {
+	query := `
+SELECT count(*) OVER(), sim.score, sim.source, ` + songColumns + `
+FROM song_similarities AS sim
+INNER JOIN songs ON songs.id = sim.similar_song_id
+` + songStatsJoin + `
+WHERE sim.song_id = $1 AND songs.deleted_at IS NULL
+ORDER BY sim.score DESC, songs.id ASC
+LIMIT $2 OFFSET $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, song.ID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	similar := []*SimilarSong{}
+	for rows.Next() {
+		var s SimilarSong
+		s.Song, err = scanSong(rows, &totalRecords, &s.Score, &s.Source)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if s.Source == SimilarityGenre {
+			shared := sharedGenres(song.Genres, s.Song.Genres)
+			noun := "genres"
+			if len(shared) == 1 {
+				noun = "genre"
+			}
+			s.Reason = fmt.Sprintf("shares the %s %s with %s", noun, strings.Join(shared, ", "), song.Title)
+		} else {
+			s.Reason = fmt.Sprintf("people who listen to %s also listen to this", song.Title)
+		}
+		similar = append(similar, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return similar, metadata, nil
+}
+
+// sharedGenres returns the genres of a which are also in b.
+func sharedGenres(a, b []string) []string {
+	shared := []string{}
+	for _, genre := range a {
+		for _, other := range b {
+			if genre == other {
+				shared = append(shared, genre)
+				break
+			}
+		}
+	}
+	return shared
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"time"
+)
+
+// The recordSongRevisions() function returns a common table expression which saves a
+// revision for each song returned by a preceding "changed" expression, made by the
+// user whose ID is in the given placeholder. The changed expression must return the
+// id, version and song_snapshot() of each song it writes. A user ID of 0 records a
+// change made by the system.
+func recordSongRevisions(userID string) string {
+	return `revisions AS (
+    INSERT INTO song_revisions (song_id, version, user_id, snapshot)
+    SELECT id, version, NULLIF(` + userID + `::bigint, 0), snapshot FROM changed
+)`
+}
+
+// A SongSnapshot is the state of a song as of one of its versions.
+type SongSnapshot struct {
+	Title    string     `json:"title"`
+	Year     int32      `json:"year,omitempty"`
+	Duration Duration   `json:"duration,omitempty"`
+	Genres   []string   `json:"genres,omitempty"`
+	ISRC     string     `json:"isrc,omitempty"`
+	ISWC     string     `json:"iswc,omitempty"`
+	Credits  Credits    `json:"credits,omitempty"`
+	Audio    *AudioFile `json:"audio,omitempty"`
+	// ArtworkKey is the blob store prefix of the song's artwork, which is kept from
+	// clients. They get its last element as artwork_version instead, the same value
+	// as in the song's artwork URLs, so that a change of artwork still shows up.
+	ArtworkKey string `json:"-"`
+	Deleted    bool   `json:"deleted"`
+	// DurationFormat is the Duration* format the duration is written to JSON in.
+	DurationFormat string `json:"-"`
+}
+
+// The MarshalJSON() method writes the snapshot as usual, apart from writing the
+// duration in the snapshot's DurationFormat, in the same way as Song.MarshalJSON(), and
+// the artwork's version in place of its key.
+func (s SongSnapshot) MarshalJSON() ([]byte, error) {
+	type snapshot SongSnapshot
+	var duration json.RawMessage
+	if s.Duration != 0 {
+		var err error
+		duration, err = s.Duration.MarshalFormat(s.DurationFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var artworkVersion string
+	if s.ArtworkKey != "" {
+		artworkVersion = path.Base(s.ArtworkKey)
+	}
+	return json.Marshal(struct {
+		snapshot
+		Duration       json.RawMessage `json:"duration,omitempty"`
+		ArtworkVersion string          `json:"artwork_version,omitempty"`
+	}{snapshot(s), duration, artworkVersion})
+}
+
+// The UnmarshalJSON() method reads a snapshot as stored by song_snapshot(), including
+// the artwork key which isn't written back out.
+func (s *SongSnapshot) UnmarshalJSON(b []byte) error {
+	type snapshot SongSnapshot
+	var stored struct {
+		snapshot
+		ArtworkKey string `json:"artwork_key"`
+	}
+	err := json.Unmarshal(b, &stored)
+	if err != nil {
+		return err
+	}
+	*s = SongSnapshot(stored.snapshot)
+	s.ArtworkKey = stored.ArtworkKey
+	return nil
+}
+
+// The Apply() method copies the snapshot's details onto a song. The audio file and
+// artwork are left alone, since the files of earlier versions may no longer exist.
+func (s SongSnapshot) Apply(song *Song) {
+	song.Title = s.Title
+	song.Year = s.Year
+	song.Duration = s.Duration
+	song.Genres = s.Genres
+	song.ISRC = s.ISRC
+	song.ISWC = s.ISWC
+	song.Credits = s.Credits
+}
+
+// A SongRevision is one version of a song, along with who made it and when. Editor is
+// nil for changes made by the system, and for users who have since been deleted.
+type SongRevision struct {
+	Version   int32        `json:"version"`
+	Editor    *PublicUser  `json:"editor"`
+	CreatedAt time.Time    `json:"created_at"`
+	Song      SongSnapshot `json:"song"`
+}
+
+// A SongChange is a field which differs between two versions of a song. From and To
+// hold the field's JSON values, which are null if the field wasn't set.
+type SongChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+}
+
+// The DiffSnapshots() function lists the fields which differ between two snapshots,
+// in alphabetical order. The values are compared as they are written to JSON, so the
+// durations are shown in the format of the snapshots.
+func DiffSnapshots(from, to SongSnapshot) ([]SongChange, error) {
+	fromFields, err := snapshotFields(from)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := snapshotFields(to)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for field := range fromFields {
+		fields = append(fields, field)
+	}
+	for field := range toFields {
+		if _, ok := fromFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	changes := []SongChange{}
+	for _, field := range fields {
+		if !bytes.Equal(fromFields[field], toFields[field]) {
+			changes = append(changes, SongChange{Field: field, From: fromFields[field], To: toFields[field]})
+		}
+	}
+	return changes, nil
+}
+
+// The snapshotFields() function splits a snapshot's JSON into its fields.
+func snapshotFields(s SongSnapshot) (map[string]json.RawMessage, error) {
+	js, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(js, &fields)
+	return fields, err
+}
+
+// songRevisionColumns lists the columns read by scanSongRevision().
+const songRevisionColumns = `song_revisions.version, COALESCE(users.id, 0), COALESCE(users.name, ''),
+    song_revisions.created_at, song_revisions.snapshot`
+
+// The scanSongRevision() function reads a revision selected using songRevisionColumns,
+// after any extra destinations.
+func scanSongRevision(row rowScanner, extra ...interface{}) (*SongRevision, error) {
+	var revision SongRevision
+	var editor PublicUser
+	var snapshot []byte
+	dest := append(extra, &revision.Version, &editor.ID, &editor.Name, &revision.CreatedAt, &snapshot)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	if editor.ID != 0 {
+		revision.Editor = &editor
+	}
+	err = json.Unmarshal(snapshot, &revision.Song)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// The GetRevisions() method returns a page of the versions of a song, newest first.
+func (m SongModel) GetRevisions(songID int64, filters Filters) ([]*SongRevision, Metadata, error) {
+	query := `
+SELECT count(*) OVER(), ` + songRevisionColumns + `
+FROM song_revisions
+LEFT JOIN users ON users.id = song_revisions.user_id
+WHERE song_revisions.song_id = $1
+ORDER BY song_revisions.version DESC
+LIMIT $2 OFFSET $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, songID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	revisions := []*SongRevision{}
+	for rows.Next() {
+		revision, err := scanSongRevision(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+// The GetRevision() method returns one version of a song.
+func (m SongModel) GetRevision(songID int64, version int32) (*SongRevision, error) {
+	query := `
+SELECT ` + songRevisionColumns + `
+FROM song_revisions
+LEFT JOIN users ON users.id = song_revisions.user_id
+WHERE song_revisions.song_id = $1 AND song_revisions.version = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	revision, err := scanSongRevision(m.DB.QueryRowContext(ctx, query, songID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return revision, nil
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"time"
+)
+
+// RevocationModel manages the list of signed media URLs which have been killed before
+// their expiry. Only a hash of each URL's signature is stored, and entries can be
+// dropped once the URL would have expired anyway. Signatures must be given in the
+// canonical encoding returned in urlsign.Claims, rather than as the client sent them.
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+func (m RevocationModel) Insert(signature string, userID int64, expiry time.Time) error {
+	hash := sha256.Sum256([]byte(signature))
+	query := `
+INSERT INTO media_url_revocations (signature_hash, user_id, expiry)
+VALUES ($1, $2, $3)
+ON CONFLICT (signature_hash) DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash[:], userID, expiry)
+	return err
+}
+
+// The IsRevoked() method reports whether the URL with the given signature has been
+// revoked.
+func (m RevocationModel) IsRevoked(signature string) (bool, error) {
+	hash := sha256.Sum256([]byte(signature))
+	query := `
+SELECT EXISTS (SELECT 1 FROM media_url_revocations WHERE signature_hash = $1)`
+	var revoked bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&revoked)
+	return revoked, err
+}
+
+// The DeleteExpired() method removes revocations for URLs which have expired.
+func (m RevocationModel) DeleteExpired() error {
+	query := `
+DELETE FROM media_url_revocations
+WHERE expiry < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
@@ -0,0 +1,344 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A scrobble is only matched to a song automatically if its score reaches
+// ScrobbleMatchThreshold and beats the next best candidate by ScrobbleMatchMargin.
+// Anything less certain is queued for review.
+const (
+	ScrobbleMatchThreshold = 0.8
+	ScrobbleMatchMargin    = 0.1
+)
+
+// NowPlayingTTL is how long a now playing track is shown for when the client didn't
+// send its duration.
+const NowPlayingTTL = 10 * time.Minute
+
+var (
+	// Bracketed qualifiers such as "(Remastered 2011)" or "[Live]".
+	bracketRX = regexp.MustCompile(`\s*[\(\[][^\)\]]*[\)\]]`)
+	// Trailing qualifiers such as " - Remastered 2011" or " - Radio Edit".
+	qualifierRX = regexp.MustCompile(`(?i)\s+-\s+.*\b(remaster(ed)?|live|version|edit|mix|mono|stereo|demo|acoustic|instrumental)\b.*$`)
+	// Featured artists added to a title, such as "Song feat. Someone".
+	featRX = regexp.MustCompile(`(?i)\s+(feat\.?|ft\.?|featuring)\s+.*$`)
+	// The separators used between several artists in one string.
+	artistSeparatorRX = regexp.MustCompile(`(?i)\s*(,|&|\+|/|;|\band\b|\bx\b|\bfeat\.?|\bft\.?|\bfeaturing\b|\bwith\b|\bvs\.?)\s*`)
+)
+
+// normalizeName reduces a track or artist name to lower case words, dropping the
+// qualifiers which players and stores add to titles. If nothing would be left, only
+// the punctuation is dropped.
+func normalizeName(s string) string {
+	stripped := bracketRX.ReplaceAllString(s, "")
+	stripped = qualifierRX.ReplaceAllString(stripped, "")
+	stripped = featRX.ReplaceAllString(stripped, "")
+	if words := nameWords(stripped); words != "" {
+		return words
+	}
+	return nameWords(s)
+}
+
+// apostropheReplacer drops apostrophes, so that "Don't" becomes "dont" rather than
+// two words.
+var apostropheReplacer = strings.NewReplacer("'", "", "\u2019", "")
+
+func nameWords(s string) string {
+	s = apostropheReplacer.Replace(strings.ToLower(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// artistMatches reports whether the artist string of a scrobble names one of the
+// credits, either as a whole or as one of several artists listed together.
+func artistMatches(artist string, credits Credits) bool {
+	names := map[string]bool{normalizeName(artist): true}
+	for _, part := range artistSeparatorRX.Split(artist, -1) {
+		if part = normalizeName(part); part != "" {
+			names[part] = true
+		}
+	}
+	for _, credit := range credits {
+		if names[normalizeName(credit.Name)] {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreMatch rates how well a candidate song matches a scrobble, starting from the
+// trigram similarity of the titles. The artist is checked against the song's artist
+// credits where there are any. A duration more than ten
+// seconds out counts against the song, as it's likely to be a different recording.
+func scoreMatch(song *Song, similarity float64, track, artist string, duration Duration) float64 {
+	score := similarity
+	if normalizeName(song.Title) == normalizeName(track) {
+		score = 1
+	}
+	if artists := song.Credits.Artists(); len(artists) > 0 {
+		if artistMatches(artist, artists) {
+			score += 0.2
+		} else {
+			score -= 0.3
+		}
+	}
+	if duration > 0 && (song.Duration-duration > 10 || duration-song.Duration > 10) {
+		score -= 0.2
+	}
+	return score
+}
+
+// ScrobbleMsPlayed estimates the time listened for a scrobble, which only says that
+// the track was played. The whole song is counted, unless the client reported a
+// shorter duration for the track.
+func ScrobbleMsPlayed(reported, song Duration) int32 {
+	if reported > 0 && reported < song {
+		return int32(reported) * 1000
+	}
+	return int32(song) * 1000
+}
+
+// The MatchScrobble() method finds the song a scrobbled artist and track refer to. The
+// duration is zero if the client didn't send it. It returns ErrRecordNotFound unless
+// a single song matches with confidence.
+func (m SongModel) MatchScrobble(artist, track string, duration Duration) (*Song, error) {
+	query := `
+SELECT similarity(lower(songs.title), $1), ` + songColumns + `
+FROM songs
+` + songStatsJoin + `
+WHERE lower(songs.title) % $1 AND songs.deleted_at IS NULL
+ORDER BY 1 DESC, songs.id
+LIMIT 10`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, normalizeName(track))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var best *Song
+	bestScore, runnerUp := 0.0, 0.0
+	for rows.Next() {
+		var similarity float64
+		song, err := scanSong(rows, &similarity)
+		if err != nil {
+			return nil, err
+		}
+		score := scoreMatch(song, similarity, track, artist, duration)
+		switch {
+		case best == nil || score > bestScore:
+			best, bestScore, runnerUp = song, score, bestScore
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if best == nil || bestScore < ScrobbleMatchThreshold || bestScore-runnerUp < ScrobbleMatchMargin {
+		return nil, ErrRecordNotFound
+	}
+	return best, nil
+}
+
+// An UnmatchedScrobble is a scrobble which couldn't be matched to a song, waiting to
+// be reviewed.
+type UnmatchedScrobble struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Artist     string    `json:"artist"`
+	Track      string    `json:"track"`
+	Album      string    `json:"album,omitempty"`
+	PlayedAt   time.Time `json:"played_at"`
+	Duration   Duration  `json:"duration,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// NowPlaying is the track a user's player last reported as playing. SongID is zero if
+// it couldn't be matched to a song.
+type NowPlaying struct {
+	SongID    int64     `json:"song_id,omitempty"`
+	Artist    string    `json:"artist"`
+	Track     string    `json:"track"`
+	Album     string    `json:"album,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ScrobbleModel struct {
+	DB *sql.DB
+}
+
+// The QueueUnmatched() method adds a scrobble to the review queue. A scrobble which is
+// already queued is ignored, so that clients can safely resend it.
+func (m ScrobbleModel) QueueUnmatched(scrobble *UnmatchedScrobble) error {
+	query := `
+INSERT INTO unmatched_scrobbles (user_id, artist, track, album, played_at, duration)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, artist, track, played_at) DO NOTHING`
+	args := []interface{}{scrobble.UserID, scrobble.Artist, scrobble.Track, scrobble.Album, scrobble.PlayedAt, scrobble.Duration}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// The GetAllUnmatched() method returns a page of the review queue.
+func (m ScrobbleModel) GetAllUnmatched(filters Filters) ([]*UnmatchedScrobble, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, user_id, artist, track, album, played_at, duration, received_at
+FROM unmatched_scrobbles
+ORDER BY %s %s, id ASC
+LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	scrobbles := []*UnmatchedScrobble{}
+	for rows.Next() {
+		var scrobble UnmatchedScrobble
+		err := rows.Scan(
+			&totalRecords,
+			&scrobble.ID,
+			&scrobble.UserID,
+			&scrobble.Artist,
+			&scrobble.Track,
+			&scrobble.Album,
+			&scrobble.PlayedAt,
+			&scrobble.Duration,
+			&scrobble.ReceivedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		scrobbles = append(scrobbles, &scrobble)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return scrobbles, metadata, nil
+}
+
+// The Resolve() method takes a scrobble off the review queue and records it as a play
+// of the given song, in a single transaction. Resolved scrobbles may be older than
+// clients are allowed to report, so the play isn't subject to ValidatePlayedAt().
+func (m ScrobbleModel) Resolve(id int64, song *Song, client PlayClient) (*Play, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	play := Play{SongID: song.ID, Title: song.Title, Client: client}
+	var duration Duration
+	query := `
+DELETE FROM unmatched_scrobbles
+WHERE id = $1
+RETURNING user_id, played_at, duration`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&play.UserID, &play.PlayedAt, &duration)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	play.MsPlayed = ScrobbleMsPlayed(duration, song.Duration)
+	query = `
+INSERT INTO plays (user_id, song_id, played_at, ms_played, client_name, client_version, platform)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id, song_id, played_at) DO NOTHING
+RETURNING id`
+	args := []interface{}{play.UserID, play.SongID, play.PlayedAt, play.MsPlayed, client.Name, client.Version, client.Platform}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&play.ID)
+	// If the user already has a play of the song at that time, the scrobble was a
+	// duplicate and there's nothing more to record.
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &play, tx.Commit()
+}
+
+// The DeleteUnmatched() method discards a scrobble from the review queue.
+func (m ScrobbleModel) DeleteUnmatched(id int64) error {
+	query := `
+DELETE FROM unmatched_scrobbles
+WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The SetNowPlaying() method replaces the user's now playing track.
+func (m ScrobbleModel) SetNowPlaying(userID int64, np *NowPlaying) error {
+	query := `
+INSERT INTO now_playing (user_id, song_id, artist, track, album, started_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id) DO UPDATE
+SET song_id = EXCLUDED.song_id, artist = EXCLUDED.artist, track = EXCLUDED.track,
+    album = EXCLUDED.album, started_at = EXCLUDED.started_at, expires_at = EXCLUDED.expires_at`
+	songID := sql.NullInt64{Int64: np.SongID, Valid: np.SongID != 0}
+	args := []interface{}{userID, songID, np.Artist, np.Track, np.Album, np.StartedAt, np.ExpiresAt}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// The GetNowPlaying() method returns the user's now playing track, or
+// ErrRecordNotFound if nothing has been reported or the track has finished.
+func (m ScrobbleModel) GetNowPlaying(userID int64) (*NowPlaying, error) {
+	query := `
+SELECT song_id, artist, track, album, started_at, expires_at
+FROM now_playing
+WHERE user_id = $1 AND expires_at > NOW()`
+	var np NowPlaying
+	var songID sql.NullInt64
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&songID,
+		&np.Artist,
+		&np.Track,
+		&np.Album,
+		&np.StartedAt,
+		&np.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	np.SongID = songID.Int64
+	return &np, nil
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"nurgazinovd_golang_lg/internal/suggest"
+	"time"
+)
+
+// An ArtistMatch is an artist found by a search, along with the number of songs they
+// are credited as the artist of and their name with the matching words marked up.
+type ArtistMatch struct {
+	Name      string `json:"name"`
+	Songs     int64  `json:"songs"`
+	Highlight string `json:"highlight"`
+}
+
+// The SearchModel looks up the things which aren't stored in a table of their own.
+// Songs are searched through SongModel.GetAll().
+type SearchModel struct {
+	DB *sql.DB
+}
+
+// The Artists() method returns the artists whose names best match the query, in the
+// same way as a song title search. Artists are only known from the artist credits on
+// songs, so the songs whose artists match the query are found first using the indexes on
+// song_artist_names(), and only their credits are grouped by artist. An artist credited
+// under differently-cased names is listed under the first of them alphabetically.
+func (m SearchModel) Artists(query string, limit int) ([]*ArtistMatch, error) {
+	var b filterBuilder
+	name := b.search("artists.name", query)
+	stmt := fmt.Sprintf(`
+WITH artists AS (
+    SELECT min(credit->>'name') AS name, count(DISTINCT songs.id) AS songs
+    FROM songs, jsonb_array_elements(songs.credits) AS credit
+    WHERE songs.deleted_at IS NULL AND credit->>'role' = 'artist' AND %s
+    GROUP BY lower(credit->>'name')
+)
+SELECT artists.name, artists.songs, %s
+FROM artists
+WHERE %s
+ORDER BY %s DESC, artists.songs DESC, artists.name
+LIMIT %s`, name.matches("song_artist_names(songs.credits)"), name.headline(), b.where(), name.rank(), b.arg(limit))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, stmt, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	artists := []*ArtistMatch{}
+	for rows.Next() {
+		var artist ArtistMatch
+		err := rows.Scan(&artist.Name, &artist.Songs, &artist.Highlight)
+		if err != nil {
+			return nil, err
+		}
+		artists = append(artists, &artist)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return artists, nil
+}
+
+// The Suggestions() method returns every song title, artist and genre in the catalogue
+// for the autocomplete index, weighted by popularity: a song by its plays and likes,
+// and an artist or genre by the total for its songs.
+func (m SearchModel) Suggestions() ([]suggest.Suggestion, error) {
+	query := `
+WITH popularity AS (
+    SELECT songs.id, songs.title, songs.genres, songs.credits,
+        ` + songPlays + ` + COALESCE(song_stats.likes, 0) AS weight
+    FROM songs
+    ` + songStatsJoin + `
+    WHERE songs.deleted_at IS NULL
+)
+SELECT $1::text, id, title, weight FROM popularity
+UNION ALL
+SELECT $2::text, 0, min(credit->>'name'), sum(weight)
+FROM popularity, jsonb_array_elements(popularity.credits) AS credit
+WHERE credit->>'role' = 'artist'
+GROUP BY lower(credit->>'name')
+UNION ALL
+SELECT $3::text, 0, genre, sum(weight)
+FROM popularity, unnest(popularity.genres) AS genre
+GROUP BY genre`
+	// This reads the whole catalogue, so it gets longer than the usual timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, suggest.KindSong, suggest.KindArtist, suggest.KindGenre)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var suggestions []suggest.Suggestion
+	for rows.Next() {
+		var s suggest.Suggestion
+		err := rows.Scan(&s.Kind, &s.SongID, &s.Text, &s.Weight)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
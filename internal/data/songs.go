@@ -3,21 +3,72 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
+	"math"
 	"nurgazinovd_golang_lg/internal/validator"
+	"strings"
 	"time"
 )
 
 type Song struct {
-	ID       int64     `json:"id"`
-	AddedAt  time.Time `json:"-"`
-	Title    string    `json:"title"`
-	Year     int32     `json:"year,omitempty"`
-	Duration Duration  `json:"duration,omitempty,string"`
-	Genres   []string  `json:"genres,omitempty"`
-	Version  int32     `json:"version"`
+	ID       int64      `json:"id"`
+	AddedAt  time.Time  `json:"-"`
+	Title    string     `json:"title"`
+	Year     int32      `json:"year,omitempty"`
+	Duration Duration   `json:"duration,omitempty,string"`
+	Genres   []string   `json:"genres,omitempty"`
+	ISRC     string     `json:"isrc,omitempty"`
+	ISWC     string     `json:"iswc,omitempty"`
+	Credits  Credits    `json:"credits,omitempty"`
+	Audio    *AudioFile `json:"audio,omitempty"`
+	// ArtworkKey is the blob store prefix of the song's cover art thumbnails. The
+	// handlers fill in Artwork with a URL for each thumbnail size.
+	ArtworkKey string            `json:"-"`
+	Artwork    map[string]string `json:"artwork,omitempty"`
+	// WaveformStatus tracks the background job which computes the waveform of the
+	// song's audio file. It is empty if there is no audio.
+	WaveformStatus string `json:"waveform_status,omitempty"`
+	// Plays is the song's total play count, as of the last rollup.
+	Plays int64 `json:"plays"`
+	// Likes and the rating totals are kept up to date by the database. UserRating is
+	// the current user's own rating, which is only filled in for song listings.
+	Likes         int64   `json:"likes"`
+	RatingAverage float64 `json:"rating_average"`
+	RatingCount   int64   `json:"rating_count"`
+	UserRating    int32   `json:"user_rating,omitempty"`
+	// Highlight is the title with the words matching a title search marked up, and is
+	// only filled in for searches.
+	Highlight string `json:"highlight,omitempty"`
+	// DeletedAt is when the song was moved to the trash, and is only filled in for
+	// songs listed from the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int32      `json:"version"`
+	// DurationFormat is the Duration* format the duration is written to JSON in.
+	DurationFormat string `json:"-"`
+}
+
+// The MarshalJSON() method writes the song as usual, apart from writing the duration
+// in the song's DurationFormat.
+func (s Song) MarshalJSON() ([]byte, error) {
+	// The song type has the same fields as Song but none of its methods, so that
+	// marshalling it doesn't call this method again. The Duration field below is
+	// shallower, so it takes the place of the one in song.
+	type song Song
+	var duration json.RawMessage
+	if s.Duration != 0 {
+		var err error
+		duration, err = s.Duration.MarshalFormat(s.DurationFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(struct {
+		song
+		Duration json.RawMessage `json:"duration,omitempty"`
+	}{song(s), duration})
 }
 
 func ValidateSong(v *validator.Validator, song *Song) {
@@ -35,22 +86,124 @@ func ValidateSong(v *validator.Validator, song *Song) {
 	v.Check(len(song.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(song.Genres) <= 3, "genres", "must not contain more than 3 genres")
 	v.Check(validator.Unique(song.Genres), "genres", "must not contain duplicate values")
+
+	v.Check(song.ISRC == "" || validator.IsISRC(song.ISRC), "isrc", "must be a valid ISRC")
+	v.Check(song.ISWC == "" || validator.IsISWC(song.ISWC), "iswc", "must be a valid ISWC")
+	ValidateCredits(v, song.Credits)
+}
+
+// songPlays is the expression for a song's total play count.
+const songPlays = `COALESCE((SELECT song_play_counts.plays FROM song_play_counts WHERE song_play_counts.song_id = songs.id), 0)`
+
+// songColumns lists the columns read by every query which returns whole songs, in the
+// order expected by scanSong().
+const songColumns = `songs.id, songs.added_at, songs.title, songs.year, songs.duration, songs.genres,
+    songs.isrc, songs.iswc, songs.credits, songs.version, songs.audio_key, songs.audio_size,
+    songs.audio_checksum, songs.audio_mime, songs.artwork_key, songs.waveform_status,
+    ` + songPlays + ` AS plays,
+    COALESCE(song_stats.likes, 0), COALESCE(song_stats.rating_count, 0), COALESCE(song_stats.rating_sum, 0)`
+
+// songStatsJoin must follow songs in the FROM clause of any query using songColumns.
+const songStatsJoin = `LEFT JOIN song_stats ON song_stats.song_id = songs.id`
+
+// The queryer interface is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// The rowScanner interface is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// The scanSong() function reads a song selected using songColumns. Any extra
+// destinations are scanned first, for columns which come before songColumns in the
+// query.
+func scanSong(row rowScanner, extra ...interface{}) (*Song, error) {
+	var song Song
+	var audio nullAudio
+	var isrc, iswc, artworkKey, waveformStatus sql.NullString
+	var ratingSum int64
+	dest := append(extra,
+		&song.ID,
+		&song.AddedAt,
+		&song.Title,
+		&song.Year,
+		&song.Duration,
+		pq.Array(&song.Genres),
+		&isrc,
+		&iswc,
+		&song.Credits,
+		&song.Version,
+		&audio.key,
+		&audio.size,
+		&audio.checksum,
+		&audio.mime,
+		&artworkKey,
+		&waveformStatus,
+		&song.Plays,
+		&song.Likes,
+		&song.RatingCount,
+		&ratingSum,
+	)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	if song.RatingCount > 0 {
+		song.RatingAverage = math.Round(float64(ratingSum)/float64(song.RatingCount)*100) / 100
+	}
+	song.ISRC = isrc.String
+	song.ISWC = iswc.String
+	song.Audio = audio.audio()
+	song.ArtworkKey = artworkKey.String
+	song.WaveformStatus = waveformStatus.String
+	return &song, nil
+}
+
+// The nullString() function converts an empty string to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 type SongModel struct {
 	DB *sql.DB
 }
 
-func (m SongModel) Insert(song *Song) error {
+// The Insert() method adds a song, recording its first version as having been made by
+// the given user.
+func (m SongModel) Insert(song *Song, userID int64) error {
 	query := `
-INSERT INTO songs (title, year, duration, genres)
-VALUES ($1, $2, $3, $4)
-RETURNING id, added_at, version`
-	args := []interface{}{song.Title, song.Year, song.Duration, pq.Array(song.Genres)}
+WITH changed AS (
+    INSERT INTO songs (title, year, duration, genres, isrc, iswc, credits)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING id, added_at, version, song_snapshot(songs) AS snapshot
+), ` + recordSongRevisions("$8") + `
+SELECT id, added_at, version FROM changed`
+	args := []interface{}{
+		song.Title,
+		song.Year,
+		song.Duration,
+		pq.Array(song.Genres),
+		nullString(song.ISRC),
+		nullString(song.ISWC),
+		song.Credits,
+		userID,
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&song.ID, &song.AddedAt, &song.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&song.ID, &song.AddedAt, &song.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "songs_isrc_key"`:
+			return ErrDuplicateISRC
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
 func (m SongModel) Get(id int64) (*Song, error) {
@@ -58,24 +211,16 @@ func (m SongModel) Get(id int64) (*Song, error) {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-SELECT id, added_at, title, year, duration, genres, version
+SELECT ` + songColumns + `
 FROM songs
-WHERE id = $1`
-	var song Song
+` + songStatsJoin + `
+WHERE id = $1 AND songs.deleted_at IS NULL`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Importantly, use defer to make sure that we cancel the context before the Get()
 	// method returns.
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&song.ID,
-		&song.AddedAt,
-		&song.Title,
-		&song.Year,
-		&song.Duration,
-		pq.Array(&song.Genres),
-		&song.Version,
-	)
+	song, err := scanSong(m.DB.QueryRowContext(ctx, query, id))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -84,24 +229,42 @@ WHERE id = $1`
 			return nil, err
 		}
 	}
-	return &song, nil
+	return song, nil
 }
-func (m SongModel) Update(song *Song) error {
+
+// The Update() method saves the song as a new version, recording the revision as
+// having been made by the given user.
+func (m SongModel) Update(song *Song, userID int64) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number.
 	query := `
-UPDATE songs
-SET title = $1, year = $2, duration = $3, genres = $4, version = version + 1
-WHERE id = $5 AND version = $6
-RETURNING version`
+WITH changed AS (
+    UPDATE songs
+    SET title = $1, year = $2, duration = $3, genres = $4, isrc = $5, iswc = $6, credits = $7,
+        audio_key = $8, audio_size = $9, audio_checksum = $10, audio_mime = $11, artwork_key = $12,
+        version = version + 1
+    WHERE id = $13 AND version = $14
+    RETURNING id, version, song_snapshot(songs) AS snapshot
+), ` + recordSongRevisions("$15") + `
+SELECT version FROM changed`
+	audio := toNullAudio(song.Audio)
 	// Create an args slice containing the values for the placeholder parameters.
 	args := []interface{}{
 		song.Title,
 		song.Year,
 		song.Duration,
 		pq.Array(song.Genres),
+		nullString(song.ISRC),
+		nullString(song.ISWC),
+		song.Credits,
+		audio.key,
+		audio.size,
+		audio.checksum,
+		audio.mime,
+		nullString(song.ArtworkKey),
 		song.ID,
 		song.Version,
+		userID,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -110,6 +273,8 @@ RETURNING version`
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "songs_isrc_key"`:
+			return ErrDuplicateISRC
 		default:
 			return err
 		}
@@ -117,73 +282,365 @@ RETURNING version`
 	return nil
 }
 
-func (m SongModel) Delete(id int64) error {
-	// Return an ErrRecordNotFound error if the song ID is less than 1.
-	if id < 1 {
-		return ErrRecordNotFound
-	}
-	// Construct the SQL query to delete the record.
+// The UpdateWaveformStatus() method records the progress of the waveform job for a
+// song's audio file. The status is derived data rather than something clients edit, so
+// the version number is left alone. Instead the update only applies if the song still
+// has the same audio file, so a job for a file which has since been replaced can't
+// overwrite the status of its successor.
+func (m SongModel) UpdateWaveformStatus(id int64, audioKey, status string) error {
 	query := `
-DELETE FROM songs
-WHERE id = $1`
+UPDATE songs
+SET waveform_status = $1
+WHERE id = $2 AND audio_key = $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, status, id, audioKey)
+	return err
+}
+
+// The GetDurations() method looks up the durations of several songs at once. Songs
+// which don't exist or are in the trash are missing from the map.
+func (m SongModel) GetDurations(ids []int64) (map[int64]Duration, error) {
+	query := `
+SELECT id, duration
+FROM songs
+WHERE id = ANY($1) AND deleted_at IS NULL`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	// Use ExecContext() and pass the context as the first argument.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+	durations := make(map[int64]Duration, len(ids))
+	for rows.Next() {
+		var id int64
+		var duration Duration
+		err := rows.Scan(&id, &duration)
+		if err != nil {
+			return nil, err
+		}
+		durations[id] = duration
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
-	rowsAffected, err := result.RowsAffected()
+	return durations, nil
+}
+
+// The GetAllForWork() method returns every recording of the composition with the given
+// ISWC, oldest first.
+func (m SongModel) GetAllForWork(iswc string) ([]*Song, error) {
+	query := `
+SELECT ` + songColumns + `
+FROM songs
+` + songStatsJoin + `
+WHERE iswc = $1 AND songs.deleted_at IS NULL
+ORDER BY year, id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, iswc)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+	songs := []*Song{}
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
-	if rowsAffected == 0 {
+	return songs, nil
+}
+
+// The Delete() method moves a song to the trash on behalf of the given user. The song
+// and everything which refers to it are kept, but hidden, until the song is restored or
+// purged. The version number is bumped so that edits based on the song as it was
+// before fail.
+func (m SongModel) Delete(id int64, userID int64) error {
+	// Return an ErrRecordNotFound error if the song ID is less than 1.
+	if id < 1 {
 		return ErrRecordNotFound
 	}
+	// Construct the SQL query to move the record to the trash.
+	query := `
+WITH changed AS (
+    UPDATE songs
+    SET deleted_at = NOW(), version = version + 1
+    WHERE id = $1 AND deleted_at IS NULL
+    RETURNING id, version, song_snapshot(songs) AS snapshot
+), ` + recordSongRevisions("$2") + `
+SELECT version FROM changed`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var version int32
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
 	return nil
 }
 
-func (m SongModel) GetAll(title string, genres []string, filters Filters) ([]*Song, Metadata, error) {
-	// Update the SQL query to include the filter conditions.
-	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, added_at, title, year, duration, genres, version
-		FROM songs
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+// songSortColumns maps the sort columns of a song listing to their SQL expressions.
+var songSortColumns = map[string]string{
+	"id":       "songs.id",
+	"title":    "songs.title",
+	"year":     "songs.year",
+	"duration": "songs.duration",
+	"plays":    songPlays,
+}
+
+// songRelevanceSort is the sort column which orders songs by how well they match the
+// title search, best first.
+const songRelevanceSort = "relevance"
+
+// Values for SongFilters.GenresMode.
+const (
+	GenresAll = "all"
+	GenresAny = "any"
+)
 
+// SongFilters holds the conditions a song listing can be narrowed down by. Fields left
+// at their zero value don't filter anything.
+type SongFilters struct {
+	Title      string
+	Lyrics     string
+	ISRC       string
+	Genres     []string
+	GenresMode string
+	// IncludeSubgenres widens the genres filter to the subgenres of each genre.
+	IncludeSubgenres bool
+	YearMin          int32
+	YearMax          int32
+	DurationMin      Duration
+	DurationMax      Duration
+	AddedSince       time.Time
+}
+
+func ValidateSongFilters(v *validator.Validator, f SongFilters) {
+	v.Check(validator.In(f.GenresMode, GenresAll, GenresAny), "genres_mode", "must be all or any")
+	v.Check(f.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(f.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(f.YearMax == 0 || f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	v.Check(f.DurationMin >= 0, "duration_min", "must not be negative")
+	v.Check(f.DurationMax >= 0, "duration_max", "must not be negative")
+	v.Check(f.DurationMax == 0 || f.DurationMin <= f.DurationMax, "duration_min", "must not be greater than duration_max")
+	v.Check(!f.AddedSince.After(time.Now()), "added_since", "must not be in the future")
+}
+
+// The apply() method adds the conditions for the filters to b, and returns the title
+// search so that the songs can be ranked by it.
+func (f SongFilters) apply(b *filterBuilder) *textQuery {
+	b.add("songs.deleted_at IS NULL")
+	title := b.search("songs.title", f.Title)
+	switch {
+	case len(f.Genres) == 0:
+	case f.IncludeSubgenres && f.GenresMode == GenresAny:
+		b.add("songs.genres && ARRAY(SELECT genre_descendants(genre) FROM unnest(%s::text[]) AS genre)", pq.Array(f.Genres))
+	case f.IncludeSubgenres:
+		for _, genre := range f.Genres {
+			b.add("songs.genres && ARRAY(SELECT genre_descendants(%s))", genre)
+		}
+	default:
+		b.arrayMatch("songs.genres", f.Genres, f.GenresMode != GenresAny)
+	}
+	if f.Lyrics != "" {
+		b.add(`EXISTS (
+			SELECT 1 FROM lyrics
+			WHERE lyrics.song_id = songs.id
+			AND to_tsvector('simple', lyrics.text) @@ plainto_tsquery('simple', %s))`, f.Lyrics)
+	}
+	b.equal("songs.isrc", f.ISRC)
+	b.between("songs.year", int64(f.YearMin), int64(f.YearMax))
+	b.between("songs.duration", int64(f.DurationMin), int64(f.DurationMax))
+	b.since("songs.added_at", f.AddedSince)
+	return title
+}
+
+// The GetAll() method returns a page of songs matching the filters. The lyrics filter
+// is opt-in and matches songs with lyrics in any language containing the given words,
+// while the isrc filter is an exact match. Each song includes the rating given by the
+// user, who is 0 for anonymous requests.
+//
+// Songs can be paged either by page number or with a cursor, and both kinds of page
+// return cursors. A cursor query sorted by a single column other than plays seeks
+// straight to its place using the (column, id) indexes, so it stays fast however deep
+// into the catalogue it is. Sorting by plays, by relevance or by columns in mixed
+// directions still has to compute the order of the songs before the cursor.
+//
+// When there is a title search, songs can also be sorted by relevance, and each song
+// comes with its title highlighted. The facets asked for are counted over every song
+// matching the filters, not just the page.
+func (m SongModel) GetAll(songFilters SongFilters, facets []string, userID int64, filters Filters) ([]*Song, Metadata, Facets, error) {
+	var b filterBuilder
+	title := songFilters.apply(&b)
+	// The relevance is negated so that it sorts best first in ascending order, like
+	// the other sort columns without a hyphen.
+	columns := map[string]string{songRelevanceSort: "-" + title.rank()}
+	for column, expr := range songSortColumns {
+		columns[column] = expr
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	// A window count would only see the rows after the cursor, so cursor queries count
+	// the total separately.
+	totalRecords := 0
+	count := "count(*) OVER()"
+	if filters.SkipTotal || filters.Cursor != nil {
+		count = "0"
+	}
+	countSeparately := !filters.SkipTotal && filters.Cursor != nil
+	// When there is more than one query, they are run in a single read-only snapshot so
+	// that the total and the facets agree with the page.
+	var q queryer = m.DB
+	if countSeparately || len(facets) > 0 {
+		tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			return nil, Metadata{}, nil, err
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+	if countSeparately {
+		err := q.QueryRowContext(ctx, `SELECT count(*) FROM songs WHERE `+b.where(), b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, nil, err
+		}
+	}
+	var songFacets Facets
+	if len(facets) > 0 {
+		var err error
+		songFacets, err = countFacets(ctx, q, facets, b.where(), b.args)
+		if err != nil {
+			return nil, Metadata{}, nil, err
+		}
+	}
+	keys := filters.sortKeys(columns, "songs.id")
+	b.keyset(keys, filters.Cursor)
+	offset := filters.offset()
+	if filters.Cursor != nil {
+		offset = 0
+	}
+	// Fetch one extra row to find out whether there's another page.
+	query := fmt.Sprintf(`
+		SELECT %s, %s, %s,
+			COALESCE((SELECT rating FROM song_ratings WHERE song_ratings.user_id = %s AND song_ratings.song_id = songs.id), 0),
+			`+songColumns+`
+		FROM songs
+		`+songStatsJoin+`
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`, count, sortKeyColumn(keys), title.headline(), b.arg(userID), b.where(), orderBy(keys), b.arg(filters.limit()+1), b.arg(offset))
+	rows, err := q.QueryContext(ctx, query, b.args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, nil, err
 	}
 	defer rows.Close()
 
-	totalRecords := 0
+	windowTotal := 0
 	songs := []*Song{}
+	var sortKeys [][]string
 	for rows.Next() {
-		var song Song
-		err := rows.Scan(
-			&totalRecords,
-			&song.ID,
-			&song.AddedAt,
-			&song.Title,
-			&song.Year,
-			&song.Duration,
-			pq.Array(&song.Genres),
-			&song.Version,
+		var sortKey []string
+		var userRating int32
+		var highlight string
+		song, err := scanSong(rows, &windowTotal, pq.Array(&sortKey), &highlight, &userRating)
+		if err != nil {
+			return nil, Metadata{}, nil, err
+		}
+		song.UserRating = userRating
+		song.Highlight = highlight
+		songs = append(songs, song)
+		sortKeys = append(sortKeys, sortKey)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, nil, err
+	}
+	if filters.Cursor == nil {
+		totalRecords = windowTotal
+	}
+	metadata, n := pageMetadata(filters, sortKeys, totalRecords)
+	songs = songs[:n]
+	if filters.Cursor != nil && filters.Cursor.Before {
+		for i, j := 0, len(songs)-1; i < j; i, j = i+1, j-1 {
+			songs[i], songs[j] = songs[j], songs[i]
+		}
+	}
+	return songs, metadata, songFacets, nil
+}
+
+// Define constants for the facets a song listing can be counted by.
+const (
+	FacetGenres = "genres"
+	FacetDecade = "decade"
+)
+
+// A FacetCount is the number of songs with one value of a facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Facets holds the counts for each facet asked for, keyed by the facet name. Genres
+// are listed most common first, and decades in order.
+type Facets map[string][]FacetCount
+
+// facetQueries holds the query which counts each facet over the matching songs, along
+// with the position of each count in the facet's order.
+var facetQueries = map[string]string{
+	FacetGenres: `SELECT genre, count(*), row_number() OVER (ORDER BY count(*) DESC, genre)
+		FROM matches, unnest(matches.genres) AS genre
+		GROUP BY genre`,
+	FacetDecade: `SELECT (year / 10 * 10)::text || 's', count(*), row_number() OVER (ORDER BY year / 10)
+		FROM matches
+		GROUP BY year / 10`,
+}
+
+// The countFacets() function counts the songs matching the where clause by each of
+// the facets. The matching songs are found once and then counted by all of the facets,
+// so that the indexes used by the filters are only searched once.
+func countFacets(ctx context.Context, q queryer, facets []string, where string, args []interface{}) (Facets, error) {
+	selects := make([]string, len(facets))
+	for i, facet := range facets {
+		selects[i] = fmt.Sprintf("SELECT %d, * FROM (%s) AS facet_%d", i, facetQueries[facet], i)
+	}
+	query := `
+		WITH matches AS (
+			SELECT songs.genres, songs.year FROM songs WHERE ` + where + `
 		)
+		` + strings.Join(selects, "\n\t\tUNION ALL\n\t\t") + `
+		ORDER BY 1, 4`
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(Facets, len(facets))
+	for _, facet := range facets {
+		result[facet] = []FacetCount{}
+	}
+	for rows.Next() {
+		var i int
+		var position int64
+		var count FacetCount
+		err := rows.Scan(&i, &count.Value, &count.Count, &position)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, err
 		}
-		songs = append(songs, &song)
+		result[facets[i]] = append(result[facets[i]], count)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, err
 	}
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	return songs, metadata, nil
+	return result, nil
 }
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func TestSortKeyColumnRelevance(t *testing.T) {
+	var b filterBuilder
+	title := b.search("songs.title", "hey jude")
+	f := Filters{Sort: songRelevanceSort, SortSafelist: []string{songRelevanceSort}}
+	keys := f.sortKeys(map[string]string{songRelevanceSort: "-" + title.rank()}, "songs.id")
+	want := "ARRAY[(-" + title.rank() + ")::text, (songs.id)::text]"
+	if got := sortKeyColumn(keys); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// TestGetAllRelevanceCursor runs a relevance-sorted title search and then fetches the
+// next page with its cursor. It needs a migrated database, given by SONGS_TEST_DB_DSN.
+func TestGetAllRelevanceCursor(t *testing.T) {
+	dsn := os.Getenv("SONGS_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("SONGS_TEST_DB_DSN not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m := SongModel{DB: db}
+	songFilters := SongFilters{Title: "a", GenresMode: GenresAll}
+	filters := Filters{Page: 1, PageSize: 1, Sort: songRelevanceSort, SortSafelist: []string{songRelevanceSort}}
+	_, metadata, _, err := m.GetAll(songFilters, nil, 0, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.NextCursor == "" {
+		t.Skip("not enough songs matching the search for a second page")
+	}
+	filters.Cursor, err = ParseCursor(metadata.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = m.GetAll(songFilters, nil, 0, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,8 +15,13 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication" // Include a new authentication scope.
+	ScopeScrobble       = "scrobble"
 )
 
+// Scrobbling clients expect their session keys to last indefinitely, so they are
+// issued with a very long lifetime instead.
+const ScrobbleSessionTTL = 10 * 365 * 24 * time.Hour
+
 // Define a Token struct to hold the data for an individual token. This includes the
 // plaintext and hashed versions of the token, associated user ID, expiry time and
 // scope.
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The GetDeleted() method fetches a song from the trash. Songs which haven't been
+// deleted, or have already been purged, are not found.
+func (m SongModel) GetDeleted(id int64) (*Song, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT songs.deleted_at, ` + songColumns + `
+FROM songs
+` + songStatsJoin + `
+WHERE id = $1 AND songs.deleted_at IS NOT NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var deletedAt time.Time
+	song, err := scanSong(m.DB.QueryRowContext(ctx, query, id), &deletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	song.DeletedAt = &deletedAt
+	return song, nil
+}
+
+// The GetTrash() method returns a page of the songs in the trash, sorted by the
+// filters.
+func (m SongModel) GetTrash(filters Filters) ([]*Song, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), songs.deleted_at, `+songColumns+`
+FROM songs
+`+songStatsJoin+`
+WHERE songs.deleted_at IS NOT NULL
+ORDER BY songs.%s %s, songs.id ASC
+LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	songs := []*Song{}
+	for rows.Next() {
+		var deletedAt time.Time
+		song, err := scanSong(rows, &totalRecords, &deletedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		song.DeletedAt = &deletedAt
+		songs = append(songs, song)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return songs, metadata, nil
+}
+
+// The Restore() method takes a song out of the trash on behalf of the given user,
+// provided that it still has the version the caller expects, and bumps the version
+// number. Everything which refers to the song reappears along with it. It returns
+// ErrDuplicateISRC if another song has taken the song's ISRC in the meantime.
+func (m SongModel) Restore(song *Song, userID int64) error {
+	query := `
+WITH changed AS (
+    UPDATE songs
+    SET deleted_at = NULL, version = version + 1
+    WHERE id = $1 AND version = $2 AND deleted_at IS NOT NULL
+    RETURNING id, version, song_snapshot(songs) AS snapshot
+), ` + recordSongRevisions("$3") + `
+SELECT version FROM changed`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, song.ID, song.Version, userID).Scan(&song.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "songs_isrc_key"`:
+			return ErrDuplicateISRC
+		default:
+			return err
+		}
+	}
+	song.DeletedAt = nil
+	return nil
+}
+
+// The Purge() method permanently deletes the songs which were moved to the trash
+// before the given time, along with everything which refers to them. It returns the
+// purged songs with their audio file and artwork key filled in, so that the caller can
+// remove the media from the blob store.
+func (m SongModel) Purge(before time.Time) ([]*Song, error) {
+	query := `
+DELETE FROM songs
+WHERE deleted_at < $1
+RETURNING id, audio_key, audio_size, audio_checksum, audio_mime, artwork_key`
+	// Purging a song deletes its plays, comments and library entries too, so allow
+	// longer than usual.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	songs := []*Song{}
+	for rows.Next() {
+		var song Song
+		var audio nullAudio
+		var artworkKey sql.NullString
+		err := rows.Scan(&song.ID, &audio.key, &audio.size, &audio.checksum, &audio.mime, &artworkKey)
+		if err != nil {
+			return nil, err
+		}
+		song.Audio = audio.audio()
+		song.ArtworkKey = artworkKey.String
+		songs = append(songs, &song)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
@@ -0,0 +1,302 @@
+// Package lastfm implements the parts of the Last.fm 2.0 web service protocol used by
+// scrobbling clients: parameter signatures, scrobble parameters and the XML and JSON
+// response formats.
+package lastfm
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxScrobbles is the largest number of scrobbles a client may send in one request.
+const MaxScrobbles = 50
+
+// Error codes defined by the Last.fm API.
+const (
+	CodeInvalidMethod     = 3
+	CodeAuthFailed        = 4
+	CodeInvalidParameters = 6
+	CodeInvalidSession    = 9
+	CodeInvalidAPIKey     = 10
+	CodeInvalidSignature  = 13
+	CodeTemporaryError    = 16
+)
+
+// Codes sent in the ignoredMessage of a scrobble which wasn't recorded.
+const (
+	IgnoredNone         = 0
+	IgnoredTimestampOld = 3
+	IgnoredTimestampNew = 4
+)
+
+// An Error is a failure reported to the client in the Last.fm format.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("lastfm error %d: %s", e.Code, e.Message)
+}
+
+// Status returns the HTTP status code sent along with the error.
+func (e *Error) Status() int {
+	switch e.Code {
+	case CodeAuthFailed, CodeInvalidSession, CodeInvalidAPIKey, CodeInvalidSignature:
+		return http.StatusForbidden
+	case CodeTemporaryError:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// ParseKeys parses a space separated list of API keys in the form "key:secret",
+// returning the secret of each key.
+func ParseKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, field := range strings.Fields(s) {
+		key, secret, found := strings.Cut(field, ":")
+		if !found || key == "" || secret == "" {
+			return nil, fmt.Errorf("invalid API key %q: must be key:secret", key)
+		}
+		keys[key] = secret
+	}
+	return keys, nil
+}
+
+// Sign computes the api_sig of a request: the MD5 hash of every parameter name and
+// value, ordered by name, followed by the shared secret. The format and callback
+// parameters aren't signed.
+func Sign(params url.Values, secret string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		switch name {
+		case "api_sig", "format", "callback":
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := md5.New()
+	for _, name := range names {
+		io.WriteString(h, name)
+		io.WriteString(h, params.Get(name))
+	}
+	io.WriteString(h, secret)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify reports whether the api_sig parameter of a request is valid for the secret.
+func Verify(params url.Values, secret string) bool {
+	sig := strings.ToLower(params.Get("api_sig"))
+	return subtle.ConstantTimeCompare([]byte(sig), []byte(Sign(params, secret))) == 1
+}
+
+// A Scrobble is a single track reported by a client, either as played or as now
+// playing. Duration is zero if the client didn't send it.
+type Scrobble struct {
+	Artist      string
+	Track       string
+	Album       string
+	AlbumArtist string
+	Timestamp   time.Time
+	Duration    time.Duration
+}
+
+// ParseScrobbles reads the scrobbles from the parameters of a track.scrobble request,
+// which are sent as artist[0], track[0], timestamp[0] and so on.
+func ParseScrobbles(params url.Values) ([]Scrobble, error) {
+	var scrobbles []Scrobble
+	for i := 0; i < MaxScrobbles; i++ {
+		suffix := fmt.Sprintf("[%d]", i)
+		if !params.Has("track"+suffix) && !params.Has("artist"+suffix) {
+			break
+		}
+		s, err := parseScrobble(params, suffix, true)
+		if err != nil {
+			return nil, err
+		}
+		scrobbles = append(scrobbles, s)
+	}
+	// A single scrobble may also be sent without the array indexes.
+	if len(scrobbles) == 0 && (params.Has("track") || params.Has("artist")) {
+		s, err := parseScrobble(params, "", true)
+		if err != nil {
+			return nil, err
+		}
+		scrobbles = append(scrobbles, s)
+	}
+	if len(scrobbles) == 0 {
+		return nil, &Error{CodeInvalidParameters, "Invalid parameters - no scrobbles sent"}
+	}
+	if params.Has(fmt.Sprintf("track[%d]", MaxScrobbles)) {
+		return nil, &Error{CodeInvalidParameters, fmt.Sprintf("Invalid parameters - no more than %d scrobbles may be sent", MaxScrobbles)}
+	}
+	return scrobbles, nil
+}
+
+// ParseNowPlaying reads the track from the parameters of a track.updateNowPlaying
+// request.
+func ParseNowPlaying(params url.Values) (Scrobble, error) {
+	return parseScrobble(params, "", false)
+}
+
+func parseScrobble(params url.Values, suffix string, timestamp bool) (Scrobble, error) {
+	s := Scrobble{
+		Artist:      strings.TrimSpace(params.Get("artist" + suffix)),
+		Track:       strings.TrimSpace(params.Get("track" + suffix)),
+		Album:       strings.TrimSpace(params.Get("album" + suffix)),
+		AlbumArtist: strings.TrimSpace(params.Get("albumArtist" + suffix)),
+	}
+	if s.Artist == "" || s.Track == "" {
+		return Scrobble{}, &Error{CodeInvalidParameters, "Invalid parameters - artist and track are required"}
+	}
+	if timestamp {
+		ts, err := strconv.ParseInt(params.Get("timestamp"+suffix), 10, 64)
+		if err != nil {
+			return Scrobble{}, &Error{CodeInvalidParameters, "Invalid parameters - timestamp must be a UNIX timestamp"}
+		}
+		s.Timestamp = time.Unix(ts, 0)
+	}
+	if d := params.Get("duration" + suffix); d != "" {
+		seconds, err := strconv.Atoi(d)
+		if err != nil || seconds < 0 {
+			return Scrobble{}, &Error{CodeInvalidParameters, "Invalid parameters - duration must be a number of seconds"}
+		}
+		s.Duration = time.Duration(seconds) * time.Second
+	}
+	return s, nil
+}
+
+// Text is a track, artist or album name in a response, flagged if it was corrected
+// from what the client sent.
+type Text struct {
+	Corrected string `xml:"corrected,attr" json:"corrected"`
+	Text      string `xml:",chardata" json:"#text"`
+}
+
+// NewText returns the text to send back for a name sent by the client.
+func NewText(sent, corrected string) Text {
+	if corrected == "" || corrected == sent {
+		return Text{Corrected: "0", Text: sent}
+	}
+	return Text{Corrected: "1", Text: corrected}
+}
+
+// IgnoredMessage explains why a scrobble wasn't recorded. Its code is IgnoredNone for
+// scrobbles which were.
+type IgnoredMessage struct {
+	Code string `xml:"code,attr" json:"code"`
+	Text string `xml:",chardata" json:"#text"`
+}
+
+// NewIgnoredMessage returns an IgnoredMessage with the given code.
+func NewIgnoredMessage(code int, text string) IgnoredMessage {
+	return IgnoredMessage{Code: strconv.Itoa(code), Text: text}
+}
+
+// A Track is the response for a single scrobble, or for track.updateNowPlaying.
+type Track struct {
+	Track          Text           `xml:"track" json:"track"`
+	Artist         Text           `xml:"artist" json:"artist"`
+	Album          Text           `xml:"album" json:"album"`
+	AlbumArtist    Text           `xml:"albumArtist" json:"albumArtist"`
+	Timestamp      string         `xml:"timestamp,omitempty" json:"timestamp,omitempty"`
+	IgnoredMessage IgnoredMessage `xml:"ignoredMessage" json:"ignoredMessage"`
+}
+
+// Scrobbles is the response to track.scrobble.
+type Scrobbles struct {
+	Accepted  int     `xml:"accepted,attr"`
+	Ignored   int     `xml:"ignored,attr"`
+	Scrobbles []Track `xml:"scrobble"`
+}
+
+// MarshalJSON follows the quirks of the Last.fm JSON format, which puts attributes in
+// an "@attr" object and sends a lone scrobble as an object rather than an array.
+func (s Scrobbles) MarshalJSON() ([]byte, error) {
+	var scrobble interface{} = s.Scrobbles
+	if len(s.Scrobbles) == 1 {
+		scrobble = s.Scrobbles[0]
+	}
+	return json.Marshal(map[string]interface{}{
+		"@attr":    map[string]int{"accepted": s.Accepted, "ignored": s.Ignored},
+		"scrobble": scrobble,
+	})
+}
+
+// Session is the response to auth.getMobileSession.
+type Session struct {
+	Name       string `xml:"name" json:"name"`
+	Key        string `xml:"key" json:"key"`
+	Subscriber int    `xml:"subscriber" json:"subscriber"`
+}
+
+// WriteResponse sends a successful response, with v as the element called name. The
+// response is XML unless format is "json".
+func WriteResponse(w http.ResponseWriter, format, name string, v interface{}) error {
+	if format == "json" {
+		return writeJSON(w, http.StatusOK, map[string]interface{}{name: v})
+	}
+	return writeXML(w, http.StatusOK, "ok", func(enc *xml.Encoder) error {
+		return enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+	})
+}
+
+// WriteError sends an error response in the requested format.
+func WriteError(w http.ResponseWriter, format string, e *Error) error {
+	if format == "json" {
+		return writeJSON(w, e.Status(), map[string]interface{}{"error": e.Code, "message": e.Message})
+	}
+	return writeXML(w, e.Status(), "failed", func(enc *xml.Encoder) error {
+		return enc.EncodeElement(e.Message, xml.StartElement{
+			Name: xml.Name{Local: "error"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: strconv.Itoa(e.Code)}},
+		})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(js)
+	return err
+}
+
+func writeXML(w http.ResponseWriter, status int, lfmStatus string, body func(*xml.Encoder) error) error {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	start := xml.StartElement{
+		Name: xml.Name{Local: "lfm"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "status"}, Value: lfmStatus}},
+	}
+	err := enc.EncodeToken(start)
+	if err == nil {
+		err = body(enc)
+	}
+	if err == nil {
+		err = enc.EncodeToken(start.End())
+	}
+	if err == nil {
+		err = enc.Flush()
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+// Package lrc reads and writes lyrics in the LRC format, where each line of text is
+// preceded by one or more [mm:ss.xx] timestamps and the file may start with ID tags
+// such as [ar:Artist] and [offset:+250].
+package lrc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrMalformed = errors.New("malformed lrc file")
+
+// A Line is a single line of lyrics, starting at Time from the beginning of the song.
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+// A File holds the contents of an LRC file. Synced is false for plain lyrics without
+// any timestamps, in which case every Line has a zero Time.
+type File struct {
+	Tags   map[string]string
+	Lines  []Line
+	Synced bool
+}
+
+var (
+	timestampRX = regexp.MustCompile(`^\[(\d{1,3}):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	tagRX       = regexp.MustCompile(`^\[([a-zA-Z#]+):(.*)\]$`)
+	// Enhanced LRC adds word-level <mm:ss.xx> timestamps within the text, which we
+	// don't keep.
+	wordTimestampRX = regexp.MustCompile(`<\d{1,3}:\d{1,2}(?:[.:]\d{1,3})?>`)
+)
+
+// parseTimestamp converts the groups matched by timestampRX into a duration. The
+// fractional part may be given in tenths, hundredths or thousandths of a second.
+func parseTimestamp(m []string) time.Duration {
+	minutes, _ := strconv.Atoi(m[1])
+	seconds, _ := strconv.Atoi(m[2])
+	d := time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	if m[3] != "" {
+		fraction, _ := strconv.Atoi(m[3])
+		for i := len(m[3]); i < 3; i++ {
+			fraction *= 10
+		}
+		d += time.Duration(fraction) * time.Millisecond
+	}
+	return d
+}
+
+// Parse reads an LRC file. Lines with several timestamps, which are commonly used for
+// repeated choruses, are expanded into one line per timestamp, and the lines are then
+// sorted by time. Any [offset:] tag is applied to the timestamps. A file without any
+// timestamps is treated as plain lyrics, one line of text per line of the file.
+func Parse(r io.Reader) (*File, error) {
+	f := &File{Tags: make(map[string]string)}
+	var plain []string
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+		var stamps []time.Duration
+		for {
+			m := timestampRX.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			stamps = append(stamps, parseTimestamp(m))
+			line = line[len(m[0]):]
+		}
+		if len(stamps) > 0 {
+			f.Synced = true
+			text := strings.TrimSpace(wordTimestampRX.ReplaceAllString(line, ""))
+			for _, stamp := range stamps {
+				f.Lines = append(f.Lines, Line{Time: stamp, Text: text})
+			}
+			continue
+		}
+		if m := tagRX.FindStringSubmatch(line); m != nil {
+			f.Tags[strings.ToLower(m[1])] = strings.TrimSpace(m[2])
+			continue
+		}
+		plain = append(plain, line)
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, ErrMalformed
+		}
+		return nil, err
+	}
+	if !f.Synced {
+		// Trim the blank lines from either end of plain lyrics, but keep the ones
+		// between verses.
+		for len(plain) > 0 && plain[0] == "" {
+			plain = plain[1:]
+		}
+		for len(plain) > 0 && plain[len(plain)-1] == "" {
+			plain = plain[:len(plain)-1]
+		}
+		for _, text := range plain {
+			f.Lines = append(f.Lines, Line{Text: text})
+		}
+		return f, nil
+	}
+	if s, ok := f.Tags["offset"]; ok {
+		offset, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		// A positive offset makes the lyrics appear sooner.
+		for i := range f.Lines {
+			f.Lines[i].Time = max(f.Lines[i].Time-time.Duration(offset)*time.Millisecond, 0)
+		}
+		delete(f.Tags, "offset")
+	}
+	sort.SliceStable(f.Lines, func(i, j int) bool {
+		return f.Lines[i].Time < f.Lines[j].Time
+	})
+	return f, nil
+}
+
+// FormatTimestamp formats a duration as an LRC timestamp. We write milliseconds as the
+// [mm:ss.xxx] form which most players accept, so that nothing is lost in a round trip.
+func FormatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("[%02d:%02d.%03d]", ms/60000, ms/1000%60, ms%1000)
+}
+
+// Write writes an LRC file. The tags are written first, in alphabetical order.
+func Write(w io.Writer, f *File) error {
+	bw := bufio.NewWriter(w)
+	keys := make([]string, 0, len(f.Tags))
+	for key := range f.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(bw, "[%s:%s]\n", key, f.Tags[key])
+	}
+	for _, line := range f.Lines {
+		if f.Synced {
+			bw.WriteString(FormatTimestamp(line.Time))
+		}
+		bw.WriteString(line.Text)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
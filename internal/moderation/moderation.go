@@ -0,0 +1,110 @@
+// Package moderation screens user-written text before it is published. It is
+// deliberately simple: a local list of blocked words and phrases, and a list of hosts
+// which may be linked to. Anything else is held for a moderator rather than rejected.
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// linkRX finds links, which must either have a scheme or start with www.
+var linkRX = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'()]+`)
+
+// A Filter checks text against a word list and a set of allowed link hosts. The zero
+// value holds nothing back, apart from links, since there are no allowed hosts.
+type Filter struct {
+	phrases      []string
+	allowedHosts []string
+}
+
+// New returns a Filter for the given blocked words and phrases, and the hosts which
+// may be linked to. Links to subdomains of an allowed host are allowed too.
+func New(words []string, allowedHosts []string) *Filter {
+	f := &Filter{}
+	for _, word := range words {
+		if phrase := normalize(word); phrase != "" {
+			f.phrases = append(f.phrases, phrase)
+		}
+	}
+	for _, host := range allowedHosts {
+		f.allowedHosts = append(f.allowedHosts, strings.ToLower(strings.TrimPrefix(host, "www.")))
+	}
+	return f
+}
+
+// LoadWords reads a word list file, with one word or phrase per line. Blank lines and
+// lines starting with # are ignored.
+func LoadWords(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// Check returns the reason the text should be held for review, or an empty string if
+// it can be published straight away.
+func (f *Filter) Check(texts ...string) string {
+	for _, text := range texts {
+		normalized := " " + normalize(text) + " "
+		for _, phrase := range f.phrases {
+			if strings.Contains(normalized, " "+phrase+" ") {
+				return "contains a blocked word"
+			}
+		}
+		for _, link := range linkRX.FindAllString(text, -1) {
+			if host := linkHost(link); !f.allowed(host) {
+				return fmt.Sprintf("contains a link to %s", host)
+			}
+		}
+	}
+	return ""
+}
+
+func (f *Filter) allowed(host string) bool {
+	for _, allowed := range f.allowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// The linkHost() function returns the lower-cased host of a link found by linkRX,
+// without any leading "www.".
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Hostname() == "" {
+		return strings.ToLower(link)
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// The normalize() function lower-cases the text and reduces it to its words separated
+// by single spaces, so that punctuation and spacing can't be used to get around the
+// word list.
+func normalize(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
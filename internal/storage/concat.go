@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// Concat returns a reader which streams the given blobs one after the other, as if
+// they were a single blob. Each blob is only opened once the previous one has been
+// fully read.
+func Concat(ctx context.Context, store BlobStore, keys ...string) io.ReadCloser {
+	return &concatReader{ctx: ctx, store: store, keys: keys}
+}
+
+type concatReader struct {
+	ctx   context.Context
+	store BlobStore
+	keys  []string
+	cur   io.ReadCloser
+}
+
+func (c *concatReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.keys) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := c.store.Get(c.ctx, c.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			c.cur = rc
+			c.keys = c.keys[1:]
+		}
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *concatReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Objects smaller than this are uploaded with a single PUT request. Anything larger is
+// sent as an S3 multipart upload, buffering at most one part in memory at a time. S3
+// requires every part except the last to be at least 5MB.
+const s3PartSize = 5 << 20
+
+// S3Config holds the settings for an S3-compatible object store. Requests use
+// path-style addressing (endpoint/bucket/key), which works with AWS as well as local
+// stand-ins such as MinIO.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3Store keeps blobs in a bucket on an S3-compatible object store. Requests are
+// signed with AWS Signature Version 4.
+type S3Store struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket must be provided")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &S3Store{cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, contentType string) (int64, error) {
+	if key == "" {
+		return 0, ErrInvalidKey
+	}
+	// Read the first part. If the whole blob fits in it we can use a plain PUT.
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return int64(n), s.putObject(ctx, key, buf[:n], contentType)
+	case err != nil:
+		return 0, err
+	}
+	return s.putMultipart(ctx, key, buf, r, contentType)
+}
+
+func (s *S3Store) putObject(ctx context.Context, key string, body []byte, contentType string) error {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) putMultipart(ctx context.Context, key string, first []byte, r io.Reader, contentType string) (int64, error) {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return 0, err
+	}
+	var initiate struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiate)
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var (
+		parts   []part
+		written int64
+		buf     = first
+	)
+	// Make sure that a failed upload doesn't leave orphaned parts in the bucket.
+	abort := func() {
+		resp, err := s.do(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {initiate.UploadID}}, nil, nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	for number := 1; len(buf) > 0; number++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {initiate.UploadID},
+		}
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, buf)
+		if err != nil {
+			abort()
+			return written, err
+		}
+		resp.Body.Close()
+		parts = append(parts, part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		written += int64(len(buf))
+		// Read the next part, reusing the same buffer.
+		buf = first[:cap(first)]
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return written, err
+		}
+		buf = buf[:n]
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		abort()
+		return written, err
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {initiate.UploadID}}, nil, body)
+	if err != nil {
+		abort()
+		return written, err
+	}
+	resp.Body.Close()
+	return written, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	header := make(http.Header)
+	if length < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// The do() method sends a signed request for the given key, returning ErrNotFound for
+// a 404 response and an error containing the S3 error code for any other failure.
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	u, err := url.Parse(s.cfg.Endpoint + "/" + s.cfg.Bucket + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3Err)
+		return nil, fmt.Errorf("s3: %s %s: %s %s (status %d)", method, key, s3Err.Code, s3Err.Message, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// The sign() method adds the AWS Signature Version 4 headers to a request. We don't
+// sign the payload itself (UNSIGNED-PAYLOAD) so that request bodies never need to be
+// hashed up front.
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	// Build the sorted list of headers to sign.
+	var names []string
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "host" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// The canonicalQuery() function encodes query parameters the way SigV4 expects: sorted
+// by key, with spaces as %20 rather than +.
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// NewReadSeeker returns an io.ReadSeekCloser over a blob of a known size, suitable for
+// passing to http.ServeContent(). Nothing is fetched until the first Read() call, and
+// seeking simply drops the current stream so that the next Read() opens a ranged read
+// at the new offset. This means that serving a byte range never downloads more of the
+// blob than is needed.
+func NewReadSeeker(ctx context.Context, store BlobStore, key string, size int64) io.ReadSeekCloser {
+	return &blobReadSeeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+// BlobReaderAt is an io.ReaderAt over a blob which must be closed after use.
+type BlobReaderAt interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// NewReaderAt returns a BlobReaderAt over a blob of a known size, for parsers which
+// need random access to a few small parts of a large file.
+func NewReaderAt(ctx context.Context, store BlobStore, key string, size int64) BlobReaderAt {
+	return &blobReadSeeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+type blobReadSeeker struct {
+	ctx    context.Context
+	store  BlobStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (b *blobReadSeeker) Read(p []byte) (int, error) {
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+	if b.body == nil {
+		body, err := b.store.GetRange(b.ctx, b.key, b.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		b.body = body
+	}
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	return n, err
+}
+
+func (b *blobReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to a negative position")
+	}
+	if offset != b.offset && b.body != nil {
+		b.body.Close()
+		b.body = nil
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt on top of Seek() and Read(), so reads at consecutive
+// offsets share a single underlying stream. Unlike most ReaderAt implementations it
+// is not safe for concurrent use.
+func (b *blobReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	_, err := b.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(b, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *blobReadSeeker) Close() error {
+	if b.body != nil {
+		return b.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore is implemented by every backend that we can keep media files in. Keys are
+// slash-separated paths such as "songs/1/audio/3f2a...". Implementations must stream
+// data rather than buffer whole objects in memory.
+type BlobStore interface {
+	// Put stores everything read from r under the given key, replacing any existing
+	// blob, and returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (int64, error)
+	// Get opens the blob stored under the given key for reading. The caller must close
+	// the returned reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange opens part of a blob for reading, starting at the given byte offset.
+	// A negative length reads through to the end of the blob.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Delete removes the blob stored under the given key. Deleting a key which doesn't
+	// exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
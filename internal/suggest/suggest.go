@@ -0,0 +1,155 @@
+// Package suggest completes what a client has typed into a search box from an
+// in-memory prefix index, so that suggestions can be offered on every keystroke
+// without a trip to the database. The index is built from a snapshot of the catalogue
+// and replaced wholesale when the catalogue changes.
+package suggest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Define constants for the kinds of suggestion.
+const (
+	KindSong   = "song"
+	KindArtist = "artist"
+	KindGenre  = "genre"
+)
+
+// Lookup returns at most MaxLimit suggestions. The best MaxLimit suggestions for each
+// prefix of up to shortPrefix letters are worked out when the index is built, since
+// such prefixes match a large part of the catalogue and are looked up on the first
+// keystrokes of every search.
+const (
+	MaxLimit    = 20
+	shortPrefix = 3
+)
+
+// A Suggestion is something a search could be completed to. SongID is only set for
+// songs. Weight ranks suggestions matching the same prefix, higher being better.
+type Suggestion struct {
+	Kind   string  `json:"kind"`
+	Text   string  `json:"text"`
+	SongID int64   `json:"song_id,omitempty"`
+	Weight float64 `json:"-"`
+}
+
+// A key is a normalized form of a suggestion's text, starting at one of its words.
+type key struct {
+	text string
+	n    int
+}
+
+// An Index finds the suggestions which have a word starting with a prefix. It is safe
+// for concurrent use, and the zero value is an empty index.
+type Index struct {
+	mu          sync.RWMutex
+	keys        []key
+	suggestions []Suggestion
+	short       map[string][]int
+}
+
+// Replace swaps the contents of the index for the given suggestions. The new index is
+// built before the lock is taken, so lookups carry on against the old one meanwhile.
+func (idx *Index) Replace(suggestions []Suggestion) {
+	var keys []key
+	for n, s := range suggestions {
+		words := strings.Fields(normalize(s.Text))
+		for i := range words {
+			keys = append(keys, key{text: strings.Join(words[i:], " "), n: n})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].text < keys[j].text
+	})
+	matches := make(map[string]map[int]bool)
+	for _, k := range keys {
+		runes := []rune(k.text)
+		for i := 1; i <= shortPrefix && i <= len(runes); i++ {
+			prefix := string(runes[:i])
+			if matches[prefix] == nil {
+				matches[prefix] = make(map[int]bool)
+			}
+			matches[prefix][k.n] = true
+		}
+	}
+	short := make(map[string][]int, len(matches))
+	for prefix, set := range matches {
+		short[prefix] = best(suggestions, set, MaxLimit)
+	}
+	idx.mu.Lock()
+	idx.keys, idx.suggestions, idx.short = keys, suggestions, short
+	idx.mu.Unlock()
+}
+
+// Lookup returns up to limit suggestions with a word starting with the prefix, heaviest
+// first. A prefix of several words matches them in order, with the last treated as a
+// prefix, so "hey ju" finds "Hey Jude" but "jude hey" doesn't. The limit is capped at
+// MaxLimit.
+func (idx *Index) Lookup(prefix string, limit int) []Suggestion {
+	prefix = normalize(prefix)
+	if prefix == "" || limit < 1 {
+		return []Suggestion{}
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ns, ok := idx.short[prefix]
+	if !ok && len([]rune(prefix)) > shortPrefix {
+		// The keys starting with the prefix sit together in the sorted slice. A
+		// suggestion can match at more than one of its words, so matches are
+		// collected in a set.
+		start := sort.Search(len(idx.keys), func(i int) bool {
+			return idx.keys[i].text >= prefix
+		})
+		matches := make(map[int]bool)
+		for _, k := range idx.keys[start:] {
+			if !strings.HasPrefix(k.text, prefix) {
+				break
+			}
+			matches[k.n] = true
+		}
+		ns = best(idx.suggestions, matches, limit)
+	}
+	if len(ns) > limit {
+		ns = ns[:limit]
+	}
+	results := make([]Suggestion, len(ns))
+	for i, n := range ns {
+		results[i] = idx.suggestions[n]
+	}
+	return results
+}
+
+// The best() function returns the indexes of up to limit of the given suggestions,
+// heaviest first and then in alphabetical order.
+func best(suggestions []Suggestion, set map[int]bool, limit int) []int {
+	ns := make([]int, 0, len(set))
+	for n := range set {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool {
+		a, b := suggestions[ns[i]], suggestions[ns[j]]
+		if a.Weight != b.Weight {
+			return a.Weight > b.Weight
+		}
+		return a.Text < b.Text
+	})
+	if len(ns) > limit {
+		ns = ns[:limit]
+	}
+	return ns
+}
+
+// The normalize() function lower-cases the text and reduces it to its words separated
+// by single spaces, so that punctuation and spacing don't get in the way of a match.
+func normalize(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
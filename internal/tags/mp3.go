@@ -0,0 +1,270 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// ID3v2 frame IDs (v2.3/v2.4 and v2.2) mapped to our field names.
+var id3Frames = map[string]string{
+	"TIT2": "title", "TT2": "title",
+	"TPE1": "artist", "TP1": "artist",
+	"TALB": "album", "TAL": "album",
+	"TYER": "year", "TYE": "year", "TDRC": "year", "TDOR": "year",
+	"TCON": "genre", "TCO": "genre",
+}
+
+func readMP3(r io.ReaderAt, size int64, t *Tags) error {
+	audioStart := int64(0)
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err == nil && hasPrefix(header, "ID3") {
+		tagSize := int64(syncsafe(header[6:10]))
+		audioStart = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			audioStart += 10 // footer
+		}
+		body, err := readBlock(r, 10, min(tagSize, maxBlockSize))
+		if err != nil {
+			return err
+		}
+		readID3v2(header[3], header[5], body, t)
+	}
+	audioEnd := size
+	trailer := make([]byte, 128)
+	if size >= 128+audioStart {
+		if _, err := r.ReadAt(trailer, size-128); err == nil && hasPrefix(trailer, "TAG") {
+			audioEnd -= 128
+			readID3v1(trailer, t)
+		}
+	}
+	if t.Duration == 0 {
+		t.Duration = mp3Duration(r, audioStart, audioEnd)
+	}
+	return nil
+}
+
+// syncsafe decodes a 28-bit ID3v2 "synchsafe" integer, where the top bit of each byte
+// is always zero.
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// unsynchronise reverses the ID3v2 unsynchronisation scheme, which inserts a zero byte
+// after every 0xFF.
+func unsynchronise(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+func readID3v2(version, flags byte, body []byte, t *Tags) {
+	if version < 2 || version > 4 {
+		return
+	}
+	if version < 4 && flags&0x80 != 0 {
+		body = unsynchronise(body)
+	}
+	// Skip the extended header, if present.
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		extSize := int(binary.BigEndian.Uint32(body))
+		if version == 4 {
+			extSize = int(syncsafe(body))
+		} else {
+			extSize += 4
+		}
+		if extSize > len(body) {
+			return
+		}
+		body = body[extSize:]
+	}
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var frameSize int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		case 4:
+			frameSize = int(syncsafe(body[4:8]))
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		}
+		if frameSize < 0 || headerLen+frameSize > len(body) {
+			return
+		}
+		frame := body[headerLen : headerLen+frameSize]
+		body = body[headerLen+frameSize:]
+		if version == 3 && frameFlags&0x00C0 != 0 {
+			continue // compressed or encrypted
+		}
+		if version == 4 {
+			if frameFlags&0x000C != 0 {
+				continue // compressed or encrypted
+			}
+			if frameFlags&0x0001 != 0 && len(frame) >= 4 {
+				frame = frame[4:] // data length indicator
+			}
+			if frameFlags&0x0002 != 0 {
+				frame = unsynchronise(frame)
+			}
+		}
+		switch id {
+		case "TLEN", "TLE":
+			ms, err := strconv.ParseInt(decodeID3Text(frame), 10, 64)
+			if err == nil && ms > 0 {
+				t.Duration = time.Duration(ms) * time.Millisecond
+			}
+		default:
+			if field, ok := id3Frames[id]; ok {
+				t.set(field, decodeID3Text(frame))
+			}
+		}
+	}
+}
+
+// decodeID3Text decodes the contents of an ID3v2 text frame, whose first byte gives
+// the character encoding. Multiple values are separated by NUL characters.
+func decodeID3Text(frame []byte) string {
+	if len(frame) == 0 {
+		return ""
+	}
+	encoding, text := frame[0], frame[1:]
+	switch encoding {
+	case 0:
+		return latin1(text)
+	case 1, 2:
+		return decodeUTF16(text, encoding == 2)
+	default:
+		return string(text)
+	}
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// decodeUTF16 decodes UTF-16 text, using the byte order mark if there is one. Each
+// value in a multi-valued frame may carry its own BOM.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	var units []uint16
+	for len(b) >= 2 {
+		switch {
+		case b[0] == 0xFE && b[1] == 0xFF:
+			bigEndian = true
+		case b[0] == 0xFF && b[1] == 0xFE:
+			bigEndian = false
+		case bigEndian:
+			units = append(units, binary.BigEndian.Uint16(b))
+		default:
+			units = append(units, binary.LittleEndian.Uint16(b))
+		}
+		b = b[2:]
+	}
+	return string(utf16.Decode(units))
+}
+
+func readID3v1(tag []byte, t *Tags) {
+	t.set("title", latin1(bytes.TrimRight(tag[3:33], "\x00 ")))
+	t.set("artist", latin1(bytes.TrimRight(tag[33:63], "\x00 ")))
+	t.set("album", latin1(bytes.TrimRight(tag[63:93], "\x00 ")))
+	t.set("year", string(tag[93:97]))
+	if name := id3v1Genre(strconv.Itoa(int(tag[127]))); name != "" && len(t.Genres) == 0 {
+		t.Genres = []string{name}
+	}
+}
+
+// Bitrates in kbit/s, indexed by [MPEG-1 or not][layer-1][bitrate index].
+var mp3Bitrates = [2][3][16]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+// Sample rates in Hz, indexed by [version bits][sample rate index].
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG 2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG 2
+	{44100, 48000, 32000}, // MPEG 1
+}
+
+// mp3Duration works out the playing time of an MP3 stream from its first frame. VBR
+// files carry the total frame count in a Xing/Info or VBRI header; otherwise we assume
+// a constant bitrate and divide the stream size by it.
+func mp3Duration(r io.ReaderAt, start, end int64) time.Duration {
+	buf := make([]byte, 4096)
+	n, _ := r.ReadAt(buf, start)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		h := buf[i:]
+		versionBits := (h[1] >> 3) & 0x03
+		layerBits := (h[1] >> 1) & 0x03
+		bitrateIndex := h[2] >> 4
+		rateIndex := (h[2] >> 2) & 0x03
+		if versionBits == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+		mpeg1 := versionBits == 3
+		layer := 4 - int(layerBits)
+		table := 1
+		if mpeg1 {
+			table = 0
+		}
+		bitrate := mp3Bitrates[table][layer-1][bitrateIndex] * 1000
+		sampleRate := mp3SampleRates[versionBits][rateIndex]
+		samplesPerFrame := 1152
+		switch {
+		case layer == 1:
+			samplesPerFrame = 384
+		case layer == 3 && !mpeg1:
+			samplesPerFrame = 576
+		}
+		mono := h[3]>>6 == 3
+		// Look for a Xing/Info header just after the side information.
+		sideInfo := 32
+		switch {
+		case mpeg1 && mono:
+			sideInfo = 17
+		case !mpeg1 && !mono:
+			sideInfo = 17
+		case !mpeg1 && mono:
+			sideInfo = 9
+		}
+		if x := 4 + sideInfo; len(h) >= x+12 {
+			tag := string(h[x : x+4])
+			if (tag == "Xing" || tag == "Info") && h[x+7]&0x01 != 0 {
+				frames := binary.BigEndian.Uint32(h[x+8 : x+12])
+				return time.Duration(float64(frames) * float64(samplesPerFrame) / float64(sampleRate) * float64(time.Second))
+			}
+		}
+		if len(h) >= 36+18 && string(h[36:40]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(h[36+14 : 36+18])
+			return time.Duration(float64(frames) * float64(samplesPerFrame) / float64(sampleRate) * float64(time.Second))
+		}
+		audioBytes := end - start - int64(i)
+		return time.Duration(float64(audioBytes) * 8 / float64(bitrate) * float64(time.Second))
+	}
+	return 0
+}
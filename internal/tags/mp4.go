@@ -0,0 +1,146 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+)
+
+// iTunes-style metadata atoms mapped to our field names. The © character is stored as
+// the single byte 0xA9.
+var mp4Fields = map[string]string{
+	"\xa9nam": "title",
+	"\xa9ART": "artist",
+	"\xa9alb": "album",
+	"\xa9day": "year",
+	"\xa9gen": "genre",
+}
+
+// walkAtoms calls fn for each atom between start and end, passing the atom type and
+// the offset and size of its contents. Only atom headers are read.
+func walkAtoms(r io.ReaderAt, start, end int64, fn func(typ string, off, size int64) error) error {
+	header := make([]byte, 16)
+	for off := start; off+8 <= end; {
+		_, err := r.ReadAt(header[:8], off)
+		if err != nil {
+			return ErrMalformed
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			size = end - off
+		case 1:
+			_, err = r.ReadAt(header[8:16], off+8)
+			if err != nil {
+				return ErrMalformed
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		// Compare against the space left rather than adding to off, which a 64-bit size
+		// could overflow.
+		if size < headerLen || size > end-off {
+			return ErrMalformed
+		}
+		err = fn(typ, off+headerLen, size-headerLen)
+		if err != nil {
+			return err
+		}
+		off += size
+	}
+	return nil
+}
+
+func readMP4(r io.ReaderAt, size int64, t *Tags) error {
+	return walkAtoms(r, 0, size, func(typ string, off, n int64) error {
+		if typ != "moov" {
+			return nil
+		}
+		return walkAtoms(r, off, off+n, func(typ string, off, n int64) error {
+			switch typ {
+			case "mvhd":
+				return readMVHD(r, off, n, t)
+			case "udta":
+				return walkAtoms(r, off, off+n, func(typ string, off, n int64) error {
+					if typ != "meta" || n < 4 {
+						return nil
+					}
+					// The meta atom is usually a "full" atom with four bytes of version
+					// and flags before its children, but QuickTime files omit them.
+					peek := make([]byte, 8)
+					_, err := r.ReadAt(peek, off)
+					if err != nil {
+						return ErrMalformed
+					}
+					if string(peek[4:8]) != "hdlr" {
+						off, n = off+4, n-4
+					}
+					return walkAtoms(r, off, off+n, func(typ string, off, n int64) error {
+						if typ != "ilst" {
+							return nil
+						}
+						return readIlst(r, off, n, t)
+					})
+				})
+			}
+			return nil
+		})
+	})
+}
+
+func readMVHD(r io.ReaderAt, off, n int64, t *Tags) error {
+	b, err := readBlock(r, off, min(n, 32))
+	if err != nil {
+		return err
+	}
+	// Version 1 has 64-bit times and needs 32 bytes, and version 0 needs 20. A header
+	// too short for its version is ignored.
+	var timescale, duration uint64
+	switch {
+	case len(b) == 0:
+	case b[0] == 1:
+		if len(b) >= 32 {
+			timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+			duration = binary.BigEndian.Uint64(b[24:32])
+		}
+	case len(b) >= 20:
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+	if timescale > 0 {
+		t.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+	return nil
+}
+
+func readIlst(r io.ReaderAt, off, n int64, t *Tags) error {
+	return walkAtoms(r, off, off+n, func(item string, off, n int64) error {
+		field, ok := mp4Fields[item]
+		if !ok && item != "gnre" {
+			return nil
+		}
+		return walkAtoms(r, off, off+n, func(typ string, off, n int64) error {
+			// Skip anything large, such as cover art in a "covr" item.
+			if typ != "data" || n < 8 || n > maxBlockSize {
+				return nil
+			}
+			b, err := readBlock(r, off, n)
+			if err != nil {
+				return err
+			}
+			value := b[8:] // skip the type indicator and locale
+			if item == "gnre" {
+				// The legacy genre atom holds an ID3v1 genre index plus one.
+				if len(value) >= 2 {
+					t.set("genre", strconv.Itoa(int(binary.BigEndian.Uint16(value))-1))
+				}
+				return nil
+			}
+			t.set(field, string(value))
+			return nil
+		})
+	})
+}
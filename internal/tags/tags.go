@@ -0,0 +1,207 @@
+// Package tags reads descriptive metadata and the playing time from audio files. It
+// understands ID3v1 and ID3v2 (MP3), FLAC and Ogg Vorbis/Opus comments, MP4 atoms
+// (M4A/AAC) and RIFF INFO chunks (WAV), and is written in pure Go.
+package tags
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported audio format")
+	ErrMalformed         = errors.New("malformed audio metadata")
+)
+
+// Limit how much of any single metadata block we are prepared to read into memory.
+// Anything larger is almost certainly embedded artwork, which we don't need.
+const maxBlockSize = 1 << 20
+
+// Tags holds the metadata read from an audio file. Fields which were not present in
+// the file are left at their zero value.
+type Tags struct {
+	Format   string
+	Title    string
+	Artist   string
+	Album    string
+	Year     int32
+	Genres   []string
+	Duration time.Duration
+}
+
+// Read parses the metadata from an audio file of the given size. The reader is only
+// accessed through ReadAt() calls for the parts of the file which are needed.
+func Read(r io.ReaderAt, size int64) (*Tags, error) {
+	header := make([]byte, 12)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+	t := &Tags{}
+	switch {
+	case hasPrefix(header, "ID3") || (len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0):
+		t.Format = "mp3"
+		err = readMP3(r, size, t)
+	case hasPrefix(header, "fLaC"):
+		t.Format = "flac"
+		err = readFLAC(r, size, t)
+	case hasPrefix(header, "OggS"):
+		t.Format = "ogg"
+		err = readOgg(r, size, t)
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		t.Format = "wav"
+		err = readWAV(r, size, t)
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		t.Format = "mp4"
+		err = readMP4(r, size, t)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func hasPrefix(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && string(b[:len(prefix)]) == prefix
+}
+
+// readBlock reads exactly n bytes at the given offset, refusing unreasonably large
+// blocks.
+func readBlock(r io.ReaderAt, off, n int64) ([]byte, error) {
+	if n < 0 || n > maxBlockSize {
+		return nil, ErrMalformed
+	}
+	buf := make([]byte, n)
+	_, err := r.ReadAt(buf, off)
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrMalformed
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// The set() method assigns a named field from a textual tag value. The same names are
+// used for every format once they have been mapped from the format's own keys.
+func (t *Tags) set(field, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return
+	}
+	switch field {
+	case "title":
+		if t.Title == "" {
+			t.Title = value
+		}
+	case "artist":
+		if t.Artist == "" {
+			t.Artist = value
+		}
+	case "album":
+		if t.Album == "" {
+			t.Album = value
+		}
+	case "year":
+		if t.Year == 0 {
+			t.Year = parseYear(value)
+		}
+	case "genre":
+		for _, genre := range splitGenres(value) {
+			if !contains(t.Genres, genre) {
+				t.Genres = append(t.Genres, genre)
+			}
+		}
+	}
+}
+
+// parseYear extracts the year from values such as "1999", "1999-05-01" or
+// "1999-05-01T12:00:00Z".
+func parseYear(value string) int32 {
+	if len(value) < 4 {
+		return 0
+	}
+	year, err := strconv.ParseInt(value[:4], 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(year)
+}
+
+// splitGenres splits a genre value into its parts. Multiple genres are separated by
+// semicolons, slashes or NUL bytes depending on the tagging software, and ID3 allows
+// numeric references to the ID3v1 genre list such as "(17)" or "17".
+func splitGenres(value string) []string {
+	var genres []string
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ';' || r == '/' || r == 0
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if strings.HasPrefix(field, "(") {
+			if end := strings.Index(field, ")"); end > 0 {
+				if name := id3v1Genre(field[1:end]); name != "" {
+					field = name
+				} else {
+					field = strings.TrimSpace(field[end+1:])
+				}
+			}
+		} else if name := id3v1Genre(field); name != "" {
+			field = name
+		}
+		if field != "" {
+			genres = append(genres, field)
+		}
+	}
+	return genres
+}
+
+func id3v1Genre(value string) string {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 || i >= len(id3v1Genres) {
+		return ""
+	}
+	return id3v1Genres[i]
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// The standard ID3v1 genre list, plus the Winamp extensions which are just as widely
+// used.
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock",
+	"Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack",
+	"Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop",
+	"Instrumental Rock", "Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic",
+	"Pop-Folk", "Eurodance", "Dream", "Southern Rock", "Comedy", "Cult", "Gangsta",
+	"Top 40", "Christian Rap", "Pop/Funk", "Jungle", "Native American", "Cabaret",
+	"New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin",
+	"Revival", "Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock",
+	"Psychedelic Rock", "Symphonic Rock", "Slow Rock", "Big Band", "Chorus",
+	"Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera", "Chamber Music",
+	"Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul",
+	"Freestyle", "Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House",
+	"Dance Hall", "Goa", "Drum & Bass", "Club-House", "Hardcore Techno", "Terror", "Indie",
+	"BritPop", "Punk", "Polsk Punk", "Beat", "Christian Gangsta Rap", "Heavy Metal",
+	"Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue",
+	"Salsa", "Thrash Metal", "Anime", "Jpop", "Synthpop",
+}
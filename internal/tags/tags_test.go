@@ -0,0 +1,179 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// The helpers below build minimal files of each format, in the layout the parsers
+// expect, so that the tests can mangle them.
+
+func le32(n int) []byte {
+	return binary.LittleEndian.AppendUint32(nil, uint32(n))
+}
+
+func be32(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func riffChunk(id string, body []byte) []byte {
+	chunk := join([]byte(id), le32(len(body)), body)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func wavFile(chunks ...[]byte) []byte {
+	body := join(append([][]byte{[]byte("WAVE")}, chunks...)...)
+	return join([]byte("RIFF"), le32(len(body)), body)
+}
+
+func atom(typ string, body ...[]byte) []byte {
+	contents := join(body...)
+	return join(be32(8+len(contents)), []byte(typ), contents)
+}
+
+func mp4File(atoms ...[]byte) []byte {
+	return join(append([][]byte{atom("ftyp", []byte("M4A \x00\x00\x00\x00"))}, atoms...)...)
+}
+
+func mvhd(timescale, duration int) []byte {
+	return atom("mvhd", make([]byte, 12), be32(timescale), be32(duration))
+}
+
+func ilstItem(typ, value string) []byte {
+	return atom(typ, atom("data", make([]byte, 8), []byte(value)))
+}
+
+func vorbisComment(comments ...string) []byte {
+	b := join(le32(4), []byte("test"), le32(len(comments)))
+	for _, comment := range comments {
+		b = join(b, le32(len(comment)), []byte(comment))
+	}
+	return b
+}
+
+func flacFile() []byte {
+	streamInfo := make([]byte, 34)
+	// 44100Hz and 441000 samples, for ten seconds.
+	streamInfo[10], streamInfo[11], streamInfo[12] = 0x0A, 0xC4, 0x40
+	binary.BigEndian.PutUint32(streamInfo[14:18], 441000)
+	comment := vorbisComment("TITLE=Flac Song", "GENRE=Jazz")
+	return join([]byte("fLaC"),
+		[]byte{0, 0, 0, byte(len(streamInfo))}, streamInfo,
+		[]byte{0x84, 0, 0, byte(len(comment))}, comment)
+}
+
+func mp3File() []byte {
+	frame := []byte("TIT2\x00\x00\x00\x09\x00\x00\x00Mp3 Song")
+	header := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(frame))}
+	// A 128kbit/s MPEG-1 Layer III frame header, followed by silence.
+	audio := append([]byte{0xFF, 0xFB, 0x90, 0x00}, make([]byte, 412)...)
+	return join(header, frame, audio)
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     []byte
+		title    string
+		duration time.Duration
+	}{
+		{
+			name: "wav",
+			file: wavFile(
+				riffChunk("fmt ", join(make([]byte, 8), le32(1000), make([]byte, 4))),
+				riffChunk("LIST", join([]byte("INFO"), riffChunk("INAM", []byte("Wav Song\x00")))),
+				riffChunk("data", make([]byte, 2000)),
+			),
+			title:    "Wav Song",
+			duration: 2 * time.Second,
+		},
+		{
+			// The last INFO subchunk has an odd length and no pad byte.
+			name:  "wav unpadded info",
+			file:  wavFile(riffChunk("LIST", join([]byte("INFO"), []byte("INAM"), le32(3), []byte("Odd")))),
+			title: "Odd",
+		},
+		{
+			name: "mp4",
+			file: mp4File(atom("moov",
+				mvhd(1000, 3000),
+				atom("udta", atom("meta", make([]byte, 4), atom("ilst", ilstItem("\xa9nam", "Mp4 Song")))),
+			)),
+			title:    "Mp4 Song",
+			duration: 3 * time.Second,
+		},
+		{
+			name: "mp4 empty mvhd",
+			file: mp4File(atom("moov", atom("mvhd")), atom("free")),
+		},
+		{
+			name: "mp4 short mvhd version 1",
+			file: mp4File(atom("moov", atom("mvhd", []byte{1}, make([]byte, 23)))),
+		},
+		{
+			name:     "flac",
+			file:     flacFile(),
+			title:    "Flac Song",
+			duration: 10 * time.Second,
+		},
+		{
+			name:  "mp3",
+			file:  mp3File(),
+			title: "Mp3 Song",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := Read(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tags.Title != tt.title {
+				t.Errorf("got title %q; want %q", tags.Title, tt.title)
+			}
+			if tt.duration != 0 && tags.Duration != tt.duration {
+				t.Errorf("got duration %v; want %v", tags.Duration, tt.duration)
+			}
+		})
+	}
+}
+
+// TestReadTruncated reads every prefix of each sample file, as well as each prefix
+// with the file's full size claimed, which must fail cleanly rather than panic.
+func TestReadTruncated(t *testing.T) {
+	for _, file := range seedFiles() {
+		for n := 0; n <= len(file); n++ {
+			r := bytes.NewReader(file[:n])
+			Read(r, int64(n))
+			Read(r, int64(len(file)))
+		}
+	}
+}
+
+func seedFiles() [][]byte {
+	return [][]byte{
+		wavFile(riffChunk("LIST", join([]byte("INFO"), []byte("INAM"), le32(3), []byte("Odd")))),
+		mp4File(atom("moov", mvhd(1000, 3000), atom("udta", atom("meta", make([]byte, 4), atom("ilst", ilstItem("gnre", "\x00\x08")))))),
+		mp4File(atom("moov", atom("mvhd")), atom("free")),
+		flacFile(),
+		mp3File(),
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	for _, file := range seedFiles() {
+		f.Add(file)
+	}
+	f.Fuzz(func(t *testing.T, file []byte) {
+		Read(bytes.NewReader(file), int64(len(file)))
+	})
+}
@@ -0,0 +1,177 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// Vorbis comment keys mapped to our field names. Keys are case-insensitive.
+var vorbisFields = map[string]string{
+	"TITLE":  "title",
+	"ARTIST": "artist",
+	"ALBUM":  "album",
+	"DATE":   "year",
+	"YEAR":   "year",
+	"GENRE":  "genre",
+}
+
+// readVorbisComment parses a Vorbis comment block, as used by FLAC, Ogg Vorbis and
+// Opus. It tolerates blocks which have been truncated by maxBlockSize.
+func readVorbisComment(b []byte, t *Tags) {
+	if len(b) < 4 {
+		return
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(b))
+	if 4+vendorLen+4 > len(b) {
+		return
+	}
+	b = b[4+vendorLen:]
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count && len(b) >= 4; i++ {
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || 4+n > len(b) {
+			return
+		}
+		key, value, found := strings.Cut(string(b[4:4+n]), "=")
+		b = b[4+n:]
+		if !found {
+			continue
+		}
+		if field, ok := vorbisFields[strings.ToUpper(key)]; ok {
+			t.set(field, value)
+		}
+	}
+}
+
+func readFLAC(r io.ReaderAt, size int64, t *Tags) error {
+	off := int64(4)
+	header := make([]byte, 4)
+	for {
+		_, err := r.ReadAt(header, off)
+		if err != nil {
+			return ErrMalformed
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		off += 4
+		switch blockType {
+		case 0: // STREAMINFO
+			block, err := readBlock(r, off, length)
+			if err != nil {
+				return err
+			}
+			if len(block) >= 18 {
+				sampleRate := uint64(block[10])<<12 | uint64(block[11])<<4 | uint64(block[12])>>4
+				samples := uint64(block[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(block[14:18]))
+				if sampleRate > 0 {
+					t.Duration = time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second))
+				}
+			}
+		case 4: // VORBIS_COMMENT
+			block, err := readBlock(r, off, min(length, maxBlockSize))
+			if err != nil {
+				return err
+			}
+			readVorbisComment(block, t)
+		}
+		off += length
+		if last || off >= size {
+			return nil
+		}
+	}
+}
+
+// oggPackets reads the first n packets of the first logical stream in an Ogg file,
+// reassembling packets which span several pages.
+func oggPackets(r io.ReaderAt, n int) ([][]byte, error) {
+	var (
+		packets [][]byte
+		current []byte
+		off     int64
+		header  = make([]byte, 27)
+	)
+	for len(packets) < n {
+		_, err := r.ReadAt(header, off)
+		if err != nil || !hasPrefix(header, "OggS") {
+			return packets, ErrMalformed
+		}
+		segments := make([]byte, header[26])
+		_, err = r.ReadAt(segments, off+27)
+		if err != nil {
+			return packets, ErrMalformed
+		}
+		off += 27 + int64(len(segments))
+		for _, lacing := range segments {
+			if len(current)+int(lacing) <= maxBlockSize {
+				chunk := make([]byte, lacing)
+				_, err = r.ReadAt(chunk, off)
+				if err != nil {
+					return packets, ErrMalformed
+				}
+				current = append(current, chunk...)
+			}
+			off += int64(lacing)
+			// A lacing value of less than 255 ends the packet.
+			if lacing < 255 {
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == n {
+					break
+				}
+			}
+		}
+	}
+	return packets, nil
+}
+
+// oggLastGranule returns the granule position of the last page in an Ogg file, which
+// gives the total number of samples in the stream.
+func oggLastGranule(r io.ReaderAt, size int64) uint64 {
+	start := max(size-65536, 0)
+	tail := make([]byte, size-start)
+	n, _ := r.ReadAt(tail, start)
+	tail = tail[:n]
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(tail[i+6 : i+14])
+}
+
+func readOgg(r io.ReaderAt, size int64, t *Tags) error {
+	packets, err := oggPackets(r, 2)
+	if err != nil {
+		return err
+	}
+	id, comment := packets[0], packets[1]
+	granule := oggLastGranule(r, size)
+	switch {
+	case hasPrefix(id, "\x01vorbis") && len(id) >= 16:
+		t.Format = "vorbis"
+		sampleRate := binary.LittleEndian.Uint32(id[12:16])
+		if sampleRate > 0 {
+			t.Duration = time.Duration(float64(granule) / float64(sampleRate) * float64(time.Second))
+		}
+		if hasPrefix(comment, "\x03vorbis") {
+			readVorbisComment(comment[7:], t)
+		}
+	case hasPrefix(id, "OpusHead") && len(id) >= 12:
+		t.Format = "opus"
+		// Opus granule positions are always at 48kHz and include the pre-skip.
+		preSkip := uint64(binary.LittleEndian.Uint16(id[10:12]))
+		if granule > preSkip {
+			t.Duration = time.Duration(float64(granule-preSkip) / 48000 * float64(time.Second))
+		}
+		if hasPrefix(comment, "OpusTags") {
+			readVorbisComment(comment[8:], t)
+		}
+	default:
+		return ErrUnsupportedFormat
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// RIFF INFO chunk IDs mapped to our field names.
+var riffFields = map[string]string{
+	"INAM": "title",
+	"IART": "artist",
+	"IPRD": "album",
+	"ICRD": "year",
+	"IGNR": "genre",
+}
+
+func readWAV(r io.ReaderAt, size int64, t *Tags) error {
+	var byteRate uint32
+	var dataSize int64
+	header := make([]byte, 8)
+	for off := int64(12); off+8 <= size; {
+		_, err := r.ReadAt(header, off)
+		if err != nil {
+			return ErrMalformed
+		}
+		id := string(header[:4])
+		n := int64(binary.LittleEndian.Uint32(header[4:8]))
+		off += 8
+		switch id {
+		case "fmt ":
+			b, err := readBlock(r, off, min(n, 16))
+			if err != nil {
+				return err
+			}
+			if len(b) >= 12 {
+				byteRate = binary.LittleEndian.Uint32(b[8:12])
+			}
+		case "data":
+			dataSize = min(n, size-off)
+		case "LIST":
+			b, err := readBlock(r, off, min(n, maxBlockSize))
+			if err != nil {
+				return err
+			}
+			if hasPrefix(b, "INFO") {
+				readRIFFInfo(b[4:], t)
+			}
+		}
+		// Chunks are padded to an even number of bytes.
+		off += n + n%2
+	}
+	if byteRate > 0 {
+		t.Duration = time.Duration(float64(dataSize) / float64(byteRate) * float64(time.Second))
+	}
+	return nil
+}
+
+func readRIFFInfo(b []byte, t *Tags) {
+	for len(b) >= 8 {
+		id := string(b[:4])
+		n := int(binary.LittleEndian.Uint32(b[4:8]))
+		if n < 0 || 8+n > len(b) {
+			return
+		}
+		if field, ok := riffFields[id]; ok {
+			t.set(field, string(b[8:8+n]))
+		}
+		// The last subchunk may be missing its pad byte.
+		b = b[min(8+n+n%2, len(b)):]
+	}
+}
@@ -0,0 +1,190 @@
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("signature expired")
+)
+
+// A Key is a named HMAC secret. The ID is embedded in every signed URL so that the
+// matching key can be found again when the URL is verified.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses a space separated list of keys in the form "id:secret". The first
+// key in the list is the one used for signing new URLs.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, field := range strings.Fields(s) {
+		id, secret, found := strings.Cut(field, ":")
+		if !found || id == "" || len(secret) < 32 {
+			return nil, fmt.Errorf("invalid signing key %q: must be id:secret with a secret of at least 32 bytes", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// RandomKey generates a key with a random secret. URLs signed with it stop working
+// when the process restarts, so it's only suitable for development.
+func RandomKey() (Key, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{ID: "dev", Secret: []byte(hex.EncodeToString(secret))}, nil
+}
+
+// Claims holds the values a signed URL is bound to. If IP is not empty the URL is only
+// valid for requests from that client address. Signature is filled in by Verify() with
+// the canonical encoding of the URL's signature, which is the same however the client
+// spelled it, so it is safe to use as the key of a revocation list.
+type Claims struct {
+	SongID    int64
+	UserID    int64
+	Expiry    time.Time
+	IP        string
+	Signature string
+}
+
+// A Signer mints and verifies signed URL query strings. Keys can be rotated by adding
+// a new key to the front of the list and keeping the old one around until every URL
+// signed with it has expired.
+type Signer struct {
+	keys []Key
+}
+
+func New(keys ...Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key must be provided")
+	}
+	return &Signer{keys: keys}, nil
+}
+
+// The payload() function returns the canonical string which is signed for a set of
+// claims.
+func payload(kid string, c Claims) string {
+	return strings.Join([]string{
+		kid,
+		strconv.FormatInt(c.SongID, 10),
+		strconv.FormatInt(c.UserID, 10),
+		strconv.FormatInt(c.Expiry.Unix(), 10),
+		c.IP,
+	}, "\n")
+}
+
+func mac(key Key, c Claims) []byte {
+	h := hmac.New(sha256.New, key.Secret)
+	h.Write([]byte(payload(key.ID, c)))
+	return h.Sum(nil)
+}
+
+// Sign returns the query parameters to append to a media URL for the given claims.
+func (s *Signer) Sign(c Claims) url.Values {
+	key := s.keys[0]
+	q := url.Values{}
+	q.Set("uid", strconv.FormatInt(c.UserID, 10))
+	q.Set("exp", strconv.FormatInt(c.Expiry.Unix(), 10))
+	q.Set("kid", key.ID)
+	if c.IP != "" {
+		q.Set("ip", c.IP)
+	}
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(mac(key, c)))
+	return q
+}
+
+// Verify checks the signed query parameters for the given song, as requested from the
+// given client IP, and returns the claims they carry.
+func (s *Signer) Verify(songID int64, q url.Values, clientIP string, now time.Time) (*Claims, error) {
+	userID, err := strconv.ParseInt(q.Get("uid"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	// Decode strictly, since the lenient decoder accepts several spellings of the last
+	// character for the same signature.
+	sig, err := base64.RawURLEncoding.Strict().DecodeString(q.Get("sig"))
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	c := &Claims{
+		SongID:    songID,
+		UserID:    userID,
+		Expiry:    time.Unix(exp, 0),
+		IP:        q.Get("ip"),
+		Signature: base64.RawURLEncoding.EncodeToString(sig),
+	}
+	if c.IP != "" && c.IP != clientIP {
+		return nil, ErrInvalidSignature
+	}
+	for _, key := range s.keys {
+		if key.ID != q.Get("kid") {
+			continue
+		}
+		if !hmac.Equal(sig, mac(key, *c)) {
+			return nil, ErrInvalidSignature
+		}
+		// Only check the expiry once we know the values haven't been tampered with.
+		if !now.Before(c.Expiry) {
+			return nil, ErrExpired
+		}
+		return c, nil
+	}
+	return nil, ErrInvalidSignature
+}
+
+// SignToken returns an opaque token carrying the value, which VerifyToken will only
+// accept back unchanged. It is used for values which clients hold on to but mustn't
+// edit, such as pagination cursors. The value must not contain a dot, so URL-safe
+// base64 is a good choice of encoding.
+func (s *Signer) SignToken(value string) string {
+	key := s.keys[0]
+	return value + "." + key.ID + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(key, value))
+}
+
+// VerifyToken checks a token made by SignToken and returns the value it carries.
+func (s *Signer) VerifyToken(token string) (string, error) {
+	value, rest, found := strings.Cut(token, ".")
+	i := strings.LastIndex(rest, ".")
+	if !found || i < 0 {
+		return "", ErrInvalidSignature
+	}
+	kid := rest[:i]
+	sig, err := base64.RawURLEncoding.Strict().DecodeString(rest[i+1:])
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	for _, key := range s.keys {
+		if key.ID == kid && hmac.Equal(sig, tokenMAC(key, value)) {
+			return value, nil
+		}
+	}
+	return "", ErrInvalidSignature
+}
+
+// The tokenMAC() function signs a token value. The value is prefixed so that a token
+// signature can never be mistaken for the signature of a media URL.
+func tokenMAC(key Key, value string) []byte {
+	h := hmac.New(sha256.New, key.Secret)
+	h.Write([]byte("token\n" + key.ID + "\n" + value))
+	return h.Sum(nil)
+}
@@ -2,9 +2,17 @@ package validator
 
 import (
 	"regexp"
+	"strings"
 )
 
 var (
+	// ISRCRX matches an International Standard Recording Code in its compact form, such
+	// as "USRC17607839": a country code, a registrant code, two digits for the year and
+	// a five digit designation code.
+	ISRCRX = regexp.MustCompile("^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$")
+	// ISWCRX matches an International Standard Musical Work Code in its compact form,
+	// such as "T0345246801": a "T" followed by nine digits and a check digit.
+	ISWCRX  = regexp.MustCompile("^T[0-9]{10}$")
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
@@ -60,3 +68,30 @@ func Unique(values []string) bool {
 	}
 	return len(values) == len(uniqueValues)
 }
+
+// NormalizeCode converts an ISRC or ISWC to its compact form by removing the hyphens,
+// dots and spaces used when printing them and converting it to upper case.
+func NormalizeCode(value string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", ".", "", " ", "").Replace(value))
+}
+
+// IsISRC returns true if a value is a validly formatted ISRC in compact form. Unlike
+// ISWCs, ISRCs don't have a check digit, so this is as far as we can check one without
+// asking the registrant.
+func IsISRC(value string) bool {
+	return ISRCRX.MatchString(value)
+}
+
+// IsISWC returns true if a value is an ISWC in compact form with a correct check
+// digit. The check digit is chosen so that 1 plus the sum of each of the nine work
+// digits multiplied by its position, plus the check digit, is divisible by 10.
+func IsISWC(value string) bool {
+	if !ISWCRX.MatchString(value) {
+		return false
+	}
+	sum := 1
+	for i := 1; i <= 9; i++ {
+		sum += i * int(value[i]-'0')
+	}
+	return (sum+int(value[10]-'0'))%10 == 0
+}
@@ -0,0 +1,139 @@
+package waveform
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/mewkiz/flac"
+	"io"
+	"math"
+)
+
+// WAV format tags.
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xFFFE
+)
+
+// wavPeaks decodes an uncompressed WAV file. Integer PCM of 8 to 32 bits and 32 or 64
+// bit floating point samples are supported.
+func wavPeaks(r io.Reader) ([]Peak, error) {
+	header := make([]byte, 12)
+	_, err := io.ReadFull(r, header)
+	if err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, ErrMalformed
+	}
+	var format, channels, bits int
+	for {
+		_, err := io.ReadFull(r, header[:8])
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		id := string(header[:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return nil, ErrMalformed
+			}
+			b := make([]byte, size+size%2)
+			_, err = io.ReadFull(r, b)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			format = int(binary.LittleEndian.Uint16(b[0:2]))
+			channels = int(binary.LittleEndian.Uint16(b[2:4]))
+			bits = int(binary.LittleEndian.Uint16(b[14:16]))
+			// The real format tag of an extensible file is at the start of its
+			// sub-format GUID.
+			if format == wavExtensible && size >= 26 {
+				format = int(binary.LittleEndian.Uint16(b[24:26]))
+			}
+		case "data":
+			if channels == 0 {
+				return nil, ErrMalformed
+			}
+			return wavData(io.LimitReader(r, size), size, format, channels, bits)
+		default:
+			_, err = io.CopyN(io.Discard, r, size+size%2)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+		}
+	}
+}
+
+func wavData(r io.Reader, size int64, format, channels, bits int) ([]Peak, error) {
+	width := bits / 8
+	switch {
+	case format == wavPCM && bits%8 == 0 && width >= 1 && width <= 4:
+	case format == wavFloat && (bits == 32 || bits == 64):
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	b, err := newBuilder(size / int64(width*channels))
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, width*channels)
+	for {
+		_, err := io.ReadFull(r, frame)
+		if err != nil {
+			// Ignore a trailing partial frame, which some encoders leave behind.
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return b.peaks, nil
+			}
+			return nil, err
+		}
+		for ch := 0; ch < channels; ch++ {
+			s := frame[ch*width : (ch+1)*width]
+			switch {
+			case format == wavFloat && bits == 32:
+				b.add(scaleFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(s)))))
+			case format == wavFloat:
+				b.add(scaleFloat(math.Float64frombits(binary.LittleEndian.Uint64(s))))
+			case width == 1:
+				// 8-bit WAV samples are unsigned.
+				b.add(scale(int32(s[0])-128, 8))
+			default:
+				// Sign-extend the little-endian sample from its top byte.
+				v := int32(int8(s[width-1]))
+				for i := width - 2; i >= 0; i-- {
+					v = v<<8 | int32(s[i])
+				}
+				b.add(scale(v, bits))
+			}
+		}
+		b.next()
+	}
+}
+
+func flacPeaks(r io.Reader) ([]Peak, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	defer stream.Close()
+	// Streams of unknown length are allowed by the format, but we need to know the
+	// length up front.
+	b, err := newBuilder(int64(stream.Info.NSamples))
+	if err != nil {
+		return nil, err
+	}
+	bits := int(stream.Info.BitsPerSample)
+	for {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return b.peaks, nil
+			}
+			return nil, ErrMalformed
+		}
+		for i := 0; i < int(frame.BlockSize); i++ {
+			for _, sub := range frame.Subframes {
+				b.add(scale(sub.Samples[i], bits))
+			}
+			b.next()
+		}
+	}
+}
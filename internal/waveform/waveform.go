@@ -0,0 +1,178 @@
+// Package waveform decodes WAV and FLAC audio and reduces it to min/max peak pairs,
+// which players can use to draw a waveform without downloading the audio itself.
+package waveform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported audio format")
+	ErrMalformed         = errors.New("malformed audio data")
+)
+
+// Levels lists the resolutions, in points, that waveforms are computed at. Requests for
+// other resolutions are served by resampling the next level up.
+var Levels = []int{128, 512, 2048, 8192}
+
+// MaxPoints is the finest resolution we compute.
+const MaxPoints = 8192
+
+// A Peak holds the lowest and highest sample values within one point of a waveform,
+// scaled to 16 bits. All channels are mixed together.
+type Peak struct {
+	Min int16
+	Max int16
+}
+
+// A Waveform holds the peaks of an audio file at each of the resolutions in Levels,
+// coarsest first. Very short files may have fewer points than the level asks for.
+type Waveform struct {
+	Levels [][]Peak
+}
+
+// Supported reports whether we can compute a waveform for the given MIME type.
+func Supported(mimeType string) bool {
+	return mimeType == "audio/wav" || mimeType == "audio/flac"
+}
+
+// Compute decodes an audio file of the given MIME type and works out its peaks. The
+// file is read from start to finish exactly once.
+func Compute(r io.Reader, mimeType string) (*Waveform, error) {
+	var peaks []Peak
+	var err error
+	switch mimeType {
+	case "audio/wav":
+		peaks, err = wavPeaks(bufio.NewReaderSize(r, 64<<10))
+	case "audio/flac":
+		peaks, err = flacPeaks(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	w := &Waveform{}
+	for _, level := range Levels {
+		w.Levels = append(w.Levels, Resample(peaks, level))
+	}
+	return w, nil
+}
+
+// Points returns the peaks at the requested resolution, resampled from the smallest
+// level which is at least as detailed. The result may have fewer points than asked for
+// if the audio is very short.
+func (w *Waveform) Points(n int) []Peak {
+	for _, level := range w.Levels {
+		if len(level) >= n {
+			return Resample(level, n)
+		}
+	}
+	return w.Levels[len(w.Levels)-1]
+}
+
+// Resample reduces peaks to n points by merging neighbouring points. It never
+// increases the number of points.
+func Resample(peaks []Peak, n int) []Peak {
+	if n >= len(peaks) {
+		return peaks
+	}
+	out := make([]Peak, n)
+	for i := range out {
+		start, end := i*len(peaks)/n, (i+1)*len(peaks)/n
+		for _, p := range peaks[start:end] {
+			out[i].Min = min(out[i].Min, p.Min)
+			out[i].Max = max(out[i].Max, p.Max)
+		}
+	}
+	return out
+}
+
+// builder accumulates samples into a fixed number of peaks. The number of sample frames
+// must be known in advance, which both WAV and FLAC tell us in their headers.
+type builder struct {
+	frames int64
+	frame  int64
+	peaks  []Peak
+	peak   *Peak
+}
+
+func newBuilder(frames int64) (*builder, error) {
+	if frames <= 0 {
+		return nil, ErrMalformed
+	}
+	b := &builder{frames: frames, peaks: make([]Peak, min(frames, MaxPoints))}
+	b.peak = &b.peaks[0]
+	return b, nil
+}
+
+// add records one sample from the current frame.
+func (b *builder) add(v int16) {
+	b.peak.Min = min(b.peak.Min, v)
+	b.peak.Max = max(b.peak.Max, v)
+}
+
+// next moves on to the next sample frame, ignoring any frames beyond the declared
+// length.
+func (b *builder) next() {
+	b.frame++
+	i := min(b.frame*int64(len(b.peaks))/b.frames, int64(len(b.peaks)-1))
+	b.peak = &b.peaks[i]
+}
+
+// MarshalBinary encodes the waveform as the magic "WFP1", a level count byte, and then
+// for each level a 32-bit point count followed by 16-bit min/max pairs, all
+// little-endian.
+func (w *Waveform) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("WFP1")
+	buf.WriteByte(byte(len(w.Levels)))
+	for _, level := range w.Levels {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(level)))
+		binary.Write(&buf, binary.LittleEndian, level)
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *Waveform) UnmarshalBinary(b []byte) error {
+	if len(b) < 5 || string(b[:4]) != "WFP1" {
+		return ErrMalformed
+	}
+	r := bytes.NewReader(b[5:])
+	levels := make([][]Peak, b[4])
+	for i := range levels {
+		var n uint32
+		err := binary.Read(r, binary.LittleEndian, &n)
+		if err != nil || int64(n)*4 > int64(r.Len()) {
+			return ErrMalformed
+		}
+		levels[i] = make([]Peak, n)
+		err = binary.Read(r, binary.LittleEndian, levels[i])
+		if err != nil {
+			return ErrMalformed
+		}
+	}
+	if len(levels) == 0 {
+		return ErrMalformed
+	}
+	w.Levels = levels
+	return nil
+}
+
+// scale converts a signed sample of the given bit depth to 16 bits.
+func scale(v int32, bits int) int16 {
+	if bits > 16 {
+		return int16(v >> (bits - 16))
+	}
+	return int16(v << (16 - bits))
+}
+
+// scaleFloat converts a floating point sample in the range [-1, 1] to 16 bits.
+func scaleFloat(f float64) int16 {
+	return int16(math.Max(-1, math.Min(1, f)) * math.MaxInt16)
+}
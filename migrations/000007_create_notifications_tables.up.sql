@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    new_releases bool NOT NULL DEFAULT true,
+    playlist_invites bool NOT NULL DEFAULT true,
+    followed_genres text[] NOT NULL DEFAULT '{}',
+    delivery text NOT NULL DEFAULT 'immediate',
+    last_digest_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+ALTER TABLE notification_preferences ADD CONSTRAINT notification_preferences_delivery_check CHECK (delivery IN ('immediate', 'weekly'));
+CREATE INDEX IF NOT EXISTS notification_preferences_followed_genres_idx ON notification_preferences USING GIN (followed_genres);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    message text NOT NULL,
+    sent_at timestamp(0) with time zone
+);
+CREATE INDEX IF NOT EXISTS notifications_pending_idx ON notifications (user_id) WHERE sent_at IS NULL;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS audio_uploads;
+ALTER TABLE songs DROP COLUMN IF EXISTS audio_mime;
+ALTER TABLE songs DROP COLUMN IF EXISTS audio_checksum;
+ALTER TABLE songs DROP COLUMN IF EXISTS audio_size;
+ALTER TABLE songs DROP COLUMN IF EXISTS audio_key;
@@ -0,0 +1,16 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS audio_key text;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS audio_size bigint;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS audio_checksum text;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS audio_mime text;
+
+CREATE TABLE IF NOT EXISTS audio_uploads (
+    id bigserial PRIMARY KEY,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    size bigint NOT NULL,
+    received bigint NOT NULL DEFAULT 0,
+    parts integer NOT NULL DEFAULT 0
+);
+ALTER TABLE audio_uploads ADD CONSTRAINT audio_uploads_received_check CHECK (received BETWEEN 0 AND size);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS media_url_revocations (
+    signature_hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    revoked_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL
+);
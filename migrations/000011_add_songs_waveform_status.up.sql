@@ -0,0 +1,3 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS waveform_status text;
+ALTER TABLE songs ADD CONSTRAINT songs_waveform_status_check
+    CHECK (waveform_status IN ('pending', 'processing', 'ready', 'failed', 'unsupported'));
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS lyrics (
+    id bigserial PRIMARY KEY,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    language text NOT NULL,
+    synced bool NOT NULL DEFAULT false,
+    text text NOT NULL,
+    lines jsonb NOT NULL DEFAULT '[]',
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (song_id, language)
+);
+CREATE INDEX IF NOT EXISTS lyrics_text_idx ON lyrics USING GIN (to_tsvector('simple', text));
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS songs_iswc_idx;
+ALTER TABLE songs DROP COLUMN IF EXISTS credits;
+ALTER TABLE songs DROP COLUMN IF EXISTS iswc;
+ALTER TABLE songs DROP COLUMN IF EXISTS isrc;
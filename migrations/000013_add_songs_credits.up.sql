@@ -0,0 +1,7 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS isrc text;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS iswc text;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS credits jsonb NOT NULL DEFAULT '[]';
+ALTER TABLE songs ADD CONSTRAINT songs_isrc_key UNIQUE (isrc);
+ALTER TABLE songs ADD CONSTRAINT songs_isrc_check CHECK (isrc ~ '^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$');
+ALTER TABLE songs ADD CONSTRAINT songs_iswc_check CHECK (iswc ~ '^T[0-9]{10}$');
+CREATE INDEX IF NOT EXISTS songs_iswc_idx ON songs (iswc);
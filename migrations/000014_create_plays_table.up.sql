@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS plays (
+    id bigserial,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    played_at timestamp(3) with time zone NOT NULL,
+    received_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ms_played integer NOT NULL,
+    client_name text NOT NULL DEFAULT '',
+    client_version text NOT NULL DEFAULT '',
+    platform text NOT NULL DEFAULT '',
+    PRIMARY KEY (id, played_at),
+    UNIQUE (user_id, song_id, played_at)
+) PARTITION BY RANGE (played_at);
+ALTER TABLE plays ADD CONSTRAINT plays_ms_played_check CHECK (ms_played > 0);
+
+-- Monthly partitions are created by the application. Anything which falls outside them
+-- ends up here.
+CREATE TABLE IF NOT EXISTS plays_default PARTITION OF plays DEFAULT;
+
+CREATE INDEX IF NOT EXISTS plays_user_played_at_idx ON plays (user_id, played_at);
+
+-- Play events are an append-only log, so refuse any attempt to edit them. Deletes are
+-- still allowed so that cascades and retention work.
+CREATE OR REPLACE FUNCTION plays_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'plays are append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER plays_append_only BEFORE UPDATE ON plays
+    FOR EACH STATEMENT EXECUTE FUNCTION plays_append_only();
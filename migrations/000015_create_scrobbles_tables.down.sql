@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS now_playing;
+DROP TABLE IF EXISTS unmatched_scrobbles;
+DROP INDEX IF EXISTS songs_title_trgm_idx;
@@ -0,0 +1,27 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Scrobbled track names are matched against song titles by trigram similarity.
+CREATE INDEX IF NOT EXISTS songs_title_trgm_idx ON songs USING GIN (lower(title) gin_trgm_ops);
+
+-- Scrobbles which couldn't be matched to a song wait here until they are reviewed.
+CREATE TABLE IF NOT EXISTS unmatched_scrobbles (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    artist text NOT NULL,
+    track text NOT NULL,
+    album text NOT NULL DEFAULT '',
+    played_at timestamp(0) with time zone NOT NULL,
+    duration integer NOT NULL DEFAULT 0,
+    received_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, artist, track, played_at)
+);
+
+CREATE TABLE IF NOT EXISTS now_playing (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    song_id bigint REFERENCES songs ON DELETE SET NULL,
+    artist text NOT NULL,
+    track text NOT NULL,
+    album text NOT NULL DEFAULT '',
+    started_at timestamp(0) with time zone NOT NULL,
+    expires_at timestamp(0) with time zone NOT NULL
+);
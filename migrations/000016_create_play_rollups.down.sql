@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS plays_received_at_idx;
+DROP TABLE IF EXISTS play_rollup_state;
+DROP TABLE IF EXISTS song_play_counts;
+DROP TABLE IF EXISTS song_plays_daily;
+DROP TABLE IF EXISTS song_plays_hourly;
@@ -0,0 +1,35 @@
+-- Play counts are rolled up from the plays table by the application. Every rollup can
+-- be rebuilt from the raw events, so none of them are a source of truth.
+CREATE TABLE IF NOT EXISTS song_plays_hourly (
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    hour timestamp(0) with time zone NOT NULL,
+    plays bigint NOT NULL,
+    ms_played bigint NOT NULL,
+    PRIMARY KEY (song_id, hour)
+);
+CREATE INDEX IF NOT EXISTS song_plays_hourly_hour_idx ON song_plays_hourly (hour);
+
+CREATE TABLE IF NOT EXISTS song_plays_daily (
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    day date NOT NULL,
+    plays bigint NOT NULL,
+    ms_played bigint NOT NULL,
+    PRIMARY KEY (song_id, day)
+);
+CREATE INDEX IF NOT EXISTS song_plays_daily_day_idx ON song_plays_daily (day);
+
+CREATE TABLE IF NOT EXISTS song_play_counts (
+    song_id bigint PRIMARY KEY REFERENCES songs ON DELETE CASCADE,
+    plays bigint NOT NULL,
+    ms_played bigint NOT NULL
+);
+
+-- Plays are rolled up in order of arrival rather than of when they were played, since
+-- clients may report plays from days ago. A single row records how far we've got.
+CREATE TABLE IF NOT EXISTS play_rollup_state (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    rolled_up_to timestamp(0) with time zone NOT NULL
+);
+INSERT INTO play_rollup_state (rolled_up_to) VALUES ('1970-01-01 00:00:00+00') ON CONFLICT DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS plays_received_at_idx ON plays (received_at);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS song_stats;
+DROP TABLE IF EXISTS song_ratings;
+DROP TABLE IF EXISTS song_likes;
+DROP TABLE IF EXISTS library_songs;
+DROP FUNCTION IF EXISTS song_ratings_stats();
+DROP FUNCTION IF EXISTS song_likes_stats();
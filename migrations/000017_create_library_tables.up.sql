@@ -0,0 +1,65 @@
+CREATE TABLE IF NOT EXISTS library_songs (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, song_id)
+);
+
+CREATE TABLE IF NOT EXISTS song_likes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, song_id)
+);
+CREATE INDEX IF NOT EXISTS song_likes_song_id_idx ON song_likes (song_id);
+
+CREATE TABLE IF NOT EXISTS song_ratings (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, song_id)
+);
+CREATE INDEX IF NOT EXISTS song_ratings_song_id_idx ON song_ratings (song_id);
+
+-- The like count and rating totals of each song are kept up to date by triggers, so
+-- that reading a song never has to count its likes and ratings. Triggers also catch
+-- the rows removed when a user is deleted.
+CREATE TABLE IF NOT EXISTS song_stats (
+    song_id bigint PRIMARY KEY REFERENCES songs ON DELETE CASCADE,
+    likes bigint NOT NULL DEFAULT 0,
+    rating_count bigint NOT NULL DEFAULT 0,
+    rating_sum bigint NOT NULL DEFAULT 0
+);
+
+CREATE OR REPLACE FUNCTION song_likes_stats() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO song_stats (song_id, likes) VALUES (NEW.song_id, 1)
+        ON CONFLICT (song_id) DO UPDATE SET likes = song_stats.likes + 1;
+    ELSE
+        UPDATE song_stats SET likes = likes - 1 WHERE song_id = OLD.song_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER song_likes_stats AFTER INSERT OR DELETE ON song_likes
+    FOR EACH ROW EXECUTE FUNCTION song_likes_stats();
+
+CREATE OR REPLACE FUNCTION song_ratings_stats() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO song_stats (song_id, rating_count, rating_sum) VALUES (NEW.song_id, 1, NEW.rating)
+        ON CONFLICT (song_id) DO UPDATE
+        SET rating_count = song_stats.rating_count + 1, rating_sum = song_stats.rating_sum + NEW.rating;
+    ELSIF TG_OP = 'UPDATE' THEN
+        UPDATE song_stats SET rating_sum = rating_sum - OLD.rating + NEW.rating WHERE song_id = NEW.song_id;
+    ELSE
+        UPDATE song_stats SET rating_count = rating_count - 1, rating_sum = rating_sum - OLD.rating
+        WHERE song_id = OLD.song_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER song_ratings_stats AFTER INSERT OR UPDATE OF rating OR DELETE ON song_ratings
+    FOR EACH ROW EXECUTE FUNCTION song_ratings_stats();
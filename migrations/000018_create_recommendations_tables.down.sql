@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_recommendations;
+DROP TABLE IF EXISTS song_similarities;
@@ -0,0 +1,18 @@
+-- Both tables are rebuilt from scratch by a periodic job, from the plays, likes and
+-- library tables.
+CREATE TABLE IF NOT EXISTS song_similarities (
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    similar_song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    score double precision NOT NULL,
+    source text NOT NULL CHECK (source IN ('co_listening', 'genre')),
+    PRIMARY KEY (song_id, similar_song_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_recommendations (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    score double precision NOT NULL,
+    because_song_id bigint REFERENCES songs ON DELETE SET NULL,
+    because text NOT NULL CHECK (because IN ('played', 'liked')),
+    PRIMARY KEY (user_id, song_id)
+);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS song_likes_user_id_created_at_idx;
+DROP INDEX IF EXISTS library_songs_user_id_created_at_idx;
+DROP TABLE IF EXISTS privacy_settings;
+DROP TABLE IF EXISTS user_follows;
+DROP TABLE IF EXISTS artist_follows;
+DROP INDEX IF EXISTS songs_added_at_idx;
+DROP INDEX IF EXISTS songs_artists_idx;
+DROP FUNCTION IF EXISTS song_artists(jsonb);
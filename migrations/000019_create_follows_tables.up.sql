@@ -0,0 +1,36 @@
+-- Artists aren't stored separately, so an artist is anyone credited on a song, matched
+-- without regard to case. The song_artists() function lists the artists of a song so
+-- that they can be indexed.
+CREATE OR REPLACE FUNCTION song_artists(credits jsonb) RETURNS text[] AS $$
+    SELECT COALESCE(array_agg(DISTINCT lower(credit->>'name')), '{}')
+    FROM jsonb_array_elements(credits) AS credit
+$$ LANGUAGE sql IMMUTABLE;
+CREATE INDEX IF NOT EXISTS songs_artists_idx ON songs USING GIN (song_artists(credits));
+CREATE INDEX IF NOT EXISTS songs_added_at_idx ON songs (added_at);
+
+CREATE TABLE IF NOT EXISTS artist_follows (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    artist text NOT NULL,
+    name text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, artist)
+);
+
+CREATE TABLE IF NOT EXISTS user_follows (
+    follower_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    followee_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+CREATE INDEX IF NOT EXISTS user_follows_followee_id_idx ON user_follows (followee_id);
+
+CREATE TABLE IF NOT EXISTS privacy_settings (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    hide_activity bool NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- The feed reads each followed user's most recent activity.
+CREATE INDEX IF NOT EXISTS library_songs_user_id_created_at_idx ON library_songs (user_id, created_at);
+CREATE INDEX IF NOT EXISTS song_likes_user_id_created_at_idx ON song_likes (user_id, created_at);
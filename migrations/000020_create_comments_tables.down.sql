@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS comment_bans;
+DROP TABLE IF EXISTS comment_reports;
+DROP TABLE IF EXISTS comment_revisions;
+DROP TABLE IF EXISTS comments;
+DELETE FROM permissions WHERE code = 'comments:moderate';
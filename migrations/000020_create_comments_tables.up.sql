@@ -0,0 +1,51 @@
+INSERT INTO permissions (code)
+VALUES ('comments:moderate');
+
+-- Comments and reviews share a table. Replies are comments with a parent, and a
+-- deleted comment keeps its row so that the replies under it stay in place.
+CREATE TABLE IF NOT EXISTS comments (
+    id bigserial PRIMARY KEY,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    parent_id bigint REFERENCES comments ON DELETE CASCADE,
+    kind text NOT NULL CHECK (kind IN ('comment', 'review')),
+    title text NOT NULL DEFAULT '',
+    body text NOT NULL,
+    status text NOT NULL CHECK (status IN ('published', 'pending', 'hidden')),
+    held_reason text,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    edited_at timestamp(0) with time zone,
+    deleted_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1,
+    CHECK (kind = 'comment' OR parent_id IS NULL)
+);
+CREATE INDEX IF NOT EXISTS comments_song_id_idx ON comments (song_id, created_at) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_pending_idx ON comments (created_at) WHERE status = 'pending';
+CREATE UNIQUE INDEX IF NOT EXISTS comments_review_key ON comments (song_id, user_id) WHERE kind = 'review' AND deleted_at IS NULL;
+
+-- Each edit copies the version being replaced into comment_revisions.
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    version integer NOT NULL,
+    title text NOT NULL,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (comment_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS comment_reports (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    resolved_at timestamp(0) with time zone,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS comment_bans (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    banned_by bigint REFERENCES users ON DELETE SET NULL,
+    reason text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,6 @@
+DROP FUNCTION IF EXISTS genre_descendants(text);
+DROP FUNCTION IF EXISTS canonical_genres(text[]);
+DROP TABLE IF EXISTS genre_names;
+DROP TABLE IF EXISTS genres;
+DROP FUNCTION IF EXISTS genre_key(text);
+DELETE FROM permissions WHERE code = 'genres:write';
@@ -0,0 +1,72 @@
+INSERT INTO permissions (code)
+VALUES ('genres:write');
+
+-- Genre names are compared by their key, which ignores case, spaces and punctuation,
+-- so that "Hip-Hop", "hip hop" and "hiphop" are the same genre.
+CREATE OR REPLACE FUNCTION genre_key(name text) RETURNS text AS $$
+    SELECT lower(regexp_replace(name, '[^[:alnum:]]+', '', 'g'))
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    parent_id bigint REFERENCES genres ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS genres_parent_id_idx ON genres (parent_id);
+
+-- Every name a genre can be written as, its canonical name included, so that no two
+-- genres can claim the same name or alias.
+CREATE TABLE IF NOT EXISTS genre_names (
+    key text PRIMARY KEY,
+    name text NOT NULL,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS genre_names_genre_id_idx ON genre_names (genre_id);
+
+-- The canonical_genres() function maps genre names to their canonical names, keeping
+-- the order of the first appearance of each genre. Unknown names are dropped.
+CREATE OR REPLACE FUNCTION canonical_genres(names text[]) RETURNS text[] AS $$
+    SELECT COALESCE(array_agg(canonical.name ORDER BY canonical.i), '{}')
+    FROM (
+        SELECT genres.name, min(given.i) AS i
+        FROM unnest(names) WITH ORDINALITY AS given(name, i)
+        JOIN genre_names ON genre_names.key = genre_key(given.name)
+        JOIN genres ON genres.id = genre_names.genre_id
+        GROUP BY genres.name
+    ) AS canonical
+$$ LANGUAGE sql STABLE;
+
+-- The genre_descendants() function lists a genre along with all of its subgenres.
+CREATE OR REPLACE FUNCTION genre_descendants(name text) RETURNS SETOF text AS $$
+    WITH RECURSIVE tree AS (
+        SELECT genres.id, genres.name FROM genres WHERE genres.name = $1
+        UNION
+        SELECT genres.id, genres.name FROM genres JOIN tree ON genres.parent_id = tree.id
+    )
+    SELECT $1
+    UNION
+    SELECT tree.name FROM tree
+$$ LANGUAGE sql STABLE;
+
+-- Seed the table with the genres already in use, taking the most common spelling of
+-- each as its canonical name, and then bring the songs and followed genres into line.
+INSERT INTO genres (name)
+SELECT DISTINCT ON (genre_key(genre)) genre
+FROM (
+    SELECT genre, count(*) AS songs
+    FROM songs, unnest(songs.genres) AS genre
+    GROUP BY genre
+) AS used
+WHERE genre_key(genre) <> ''
+ORDER BY genre_key(genre), songs DESC, genre;
+
+INSERT INTO genre_names (key, name, genre_id)
+SELECT genre_key(name), name, id FROM genres;
+
+UPDATE songs SET genres = canonical_genres(genres), version = version + 1
+WHERE genres <> canonical_genres(genres);
+
+UPDATE notification_preferences SET followed_genres = canonical_genres(followed_genres)
+WHERE followed_genres <> canonical_genres(followed_genres);
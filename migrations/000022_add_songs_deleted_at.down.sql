@@ -0,0 +1,5 @@
+-- Songs in the trash were deleted as far as their owners are concerned, so they are
+-- removed rather than brought back.
+DELETE FROM songs WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS songs_deleted_at_idx;
+ALTER TABLE songs DROP COLUMN IF EXISTS deleted_at;
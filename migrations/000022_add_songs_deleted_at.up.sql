@@ -0,0 +1,5 @@
+-- Deleting a song moves it to the trash by setting deleted_at, and the purge job
+-- removes it for good once the retention period has passed. Everything which refers to
+-- the song is kept until then, so that restoring it brings everything back.
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS songs_deleted_at_idx ON songs (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS song_revisions;
+DROP FUNCTION IF EXISTS song_snapshot(songs);
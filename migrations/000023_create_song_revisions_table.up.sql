@@ -0,0 +1,40 @@
+-- The song_snapshot() function captures the state of a song which is kept in its
+-- revision history.
+CREATE OR REPLACE FUNCTION song_snapshot(s songs) RETURNS jsonb
+LANGUAGE sql IMMUTABLE AS $$
+    SELECT jsonb_build_object(
+        'title', s.title,
+        'year', s.year,
+        'duration', s.duration,
+        'genres', s.genres,
+        'isrc', s.isrc,
+        'iswc', s.iswc,
+        'credits', s.credits,
+        'audio', CASE WHEN s.audio_key IS NULL THEN NULL ELSE jsonb_build_object(
+            'key', s.audio_key,
+            'size', s.audio_size,
+            'checksum_sha256', s.audio_checksum,
+            'mime_type', s.audio_mime
+        ) END,
+        'artwork_key', s.artwork_key,
+        'deleted', s.deleted_at IS NOT NULL
+    )
+$$;
+
+-- Every version of a song is kept in song_revisions, along with the user who made it.
+-- The user is NULL for changes made by the system, such as genre merges.
+CREATE TABLE IF NOT EXISTS song_revisions (
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    version integer NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    snapshot jsonb NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (song_id, version)
+);
+
+-- The earlier versions of existing songs are gone, so their history starts from the
+-- current version.
+INSERT INTO song_revisions (song_id, version, snapshot)
+SELECT songs.id, songs.version, song_snapshot(songs)
+FROM songs
+ON CONFLICT DO NOTHING;
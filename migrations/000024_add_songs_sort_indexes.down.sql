@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS songs_title_id_idx;
+DROP INDEX IF EXISTS songs_year_id_idx;
+DROP INDEX IF EXISTS songs_duration_id_idx;
@@ -0,0 +1,5 @@
+-- Cursor queries on the song list compare (column, id) against the cursor, which these
+-- indexes can seek to directly rather than reading the songs before it.
+CREATE INDEX IF NOT EXISTS songs_title_id_idx ON songs (title, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS songs_year_id_idx ON songs (year, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS songs_duration_id_idx ON songs (duration, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS songs_artist_names_trgm_idx;
+DROP INDEX IF EXISTS songs_artist_names_idx;
+DROP FUNCTION IF EXISTS song_artist_names(jsonb);
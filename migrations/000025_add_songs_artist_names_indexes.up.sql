@@ -0,0 +1,8 @@
+-- The song_artist_names() function puts the artists of a song into one string, so that
+-- an artist search can find the songs crediting a matching artist using these indexes
+-- before it groups the credits by artist.
+CREATE OR REPLACE FUNCTION song_artist_names(credits jsonb) RETURNS text AS $$
+    SELECT array_to_string(song_artists(credits), ' ')
+$$ LANGUAGE sql IMMUTABLE;
+CREATE INDEX IF NOT EXISTS songs_artist_names_idx ON songs USING GIN (to_tsvector('simple', song_artist_names(credits)));
+CREATE INDEX IF NOT EXISTS songs_artist_names_trgm_idx ON songs USING GIN (lower(song_artist_names(credits)) gin_trgm_ops);
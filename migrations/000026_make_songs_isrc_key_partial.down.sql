@@ -0,0 +1,4 @@
+-- This fails if a song in the trash shares its ISRC with another song, which has to be
+-- resolved by hand first.
+DROP INDEX IF EXISTS songs_isrc_key;
+ALTER TABLE songs ADD CONSTRAINT songs_isrc_key UNIQUE (isrc);
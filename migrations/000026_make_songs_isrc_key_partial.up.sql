@@ -0,0 +1,5 @@
+-- Songs in the trash no longer hold on to their ISRC, so a new song can take it. The
+-- index keeps the constraint's name, so that duplicates are reported the same way,
+-- and restoring a song whose ISRC has since been taken fails on it.
+ALTER TABLE songs DROP CONSTRAINT IF EXISTS songs_isrc_key;
+CREATE UNIQUE INDEX IF NOT EXISTS songs_isrc_key ON songs (isrc) WHERE deleted_at IS NULL;
@@ -0,0 +1,5 @@
+-- The chunks of uploads in progress can't be found from a count of them any more, so
+-- those uploads are abandoned.
+DELETE FROM audio_uploads WHERE cardinality(part_keys) > 0;
+ALTER TABLE audio_uploads ADD COLUMN IF NOT EXISTS parts integer NOT NULL DEFAULT 0;
+ALTER TABLE audio_uploads DROP COLUMN IF EXISTS part_keys;
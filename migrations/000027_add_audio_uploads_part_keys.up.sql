@@ -0,0 +1,10 @@
+-- Each chunk of an upload is stored under a key of its own, recorded here when the
+-- chunk is accepted, so that two attempts at the same chunk never share a key. The
+-- chunks of uploads in progress were stored under keys numbered by their position.
+ALTER TABLE audio_uploads ADD COLUMN IF NOT EXISTS part_keys text[] NOT NULL DEFAULT '{}';
+UPDATE audio_uploads SET part_keys = ARRAY(
+    SELECT format('uploads/%s/%s', id, lpad(part::text, 6, '0'))
+    FROM generate_series(0, parts - 1) AS part
+    ORDER BY part
+);
+ALTER TABLE audio_uploads DROP COLUMN IF EXISTS parts;
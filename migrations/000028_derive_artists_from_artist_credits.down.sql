@@ -0,0 +1,7 @@
+CREATE OR REPLACE FUNCTION song_artists(credits jsonb) RETURNS text[] AS $$
+    SELECT COALESCE(array_agg(DISTINCT lower(credit->>'name')), '{}')
+    FROM jsonb_array_elements(credits) AS credit
+$$ LANGUAGE sql IMMUTABLE;
+REINDEX INDEX songs_artists_idx;
+REINDEX INDEX songs_artist_names_idx;
+REINDEX INDEX songs_artist_names_trgm_idx;
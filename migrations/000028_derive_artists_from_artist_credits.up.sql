@@ -0,0 +1,11 @@
+-- Only the performers of a song, credited with the artist role, count as its artists.
+-- Writers, composers and producers are no longer followed, searched or matched as
+-- artists. The indexes built on the function have to be rebuilt to match.
+CREATE OR REPLACE FUNCTION song_artists(credits jsonb) RETURNS text[] AS $$
+    SELECT COALESCE(array_agg(DISTINCT lower(credit->>'name')), '{}')
+    FROM jsonb_array_elements(credits) AS credit
+    WHERE credit->>'role' = 'artist'
+$$ LANGUAGE sql IMMUTABLE;
+REINDEX INDEX songs_artists_idx;
+REINDEX INDEX songs_artist_names_idx;
+REINDEX INDEX songs_artist_names_trgm_idx;
@@ -0,0 +1,8 @@
+DELETE FROM comments WHERE album_id IS NOT NULL;
+DROP INDEX IF EXISTS comments_album_review_key;
+DROP INDEX IF EXISTS comments_album_id_idx;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_subject_check;
+ALTER TABLE comments DROP COLUMN IF EXISTS album_id;
+ALTER TABLE comments ALTER COLUMN song_id SET NOT NULL;
+DROP TABLE IF EXISTS album_songs;
+DROP TABLE IF EXISTS albums;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS albums (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    artist text NOT NULL,
+    year integer NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- The track list of an album, in order. Songs in the trash stay on the list but are
+-- left out when it is read.
+CREATE TABLE IF NOT EXISTS album_songs (
+    album_id bigint NOT NULL REFERENCES albums ON DELETE CASCADE,
+    song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (album_id, song_id)
+);
+CREATE INDEX IF NOT EXISTS album_songs_song_id_idx ON album_songs (song_id);
+
+-- A post is on either a song or an album. Replies are on the same song or album as
+-- the post they reply to, and a user can have one review of each album as well.
+ALTER TABLE comments ALTER COLUMN song_id DROP NOT NULL;
+ALTER TABLE comments ADD COLUMN album_id bigint REFERENCES albums ON DELETE CASCADE;
+ALTER TABLE comments ADD CONSTRAINT comments_subject_check CHECK ((song_id IS NULL) <> (album_id IS NULL));
+CREATE INDEX IF NOT EXISTS comments_album_id_idx ON comments (album_id, created_at) WHERE parent_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS comments_album_review_key ON comments (album_id, user_id) WHERE kind = 'review' AND deleted_at IS NULL;